@@ -1,10 +1,16 @@
 package grunway
 
 import (
+	"bytes"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
+	"encoding/binary"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -17,6 +23,8 @@ const (
 	MAX_PASSWORD_LENGTH = 1024
 )
 
+// The expected behavior of every method is checked by the accountstoretest package.
+// Backends can certify themselves with accountstoretest.RunSuite.
 type AccountStore interface {
 
 	// Common startup, shutdown, utility methods
@@ -24,7 +32,8 @@ type AccountStore interface {
 	Shutdown() error
 
 	// CREATE
-	// returns created account's accountid
+	// returns created account, with non-zero PKey and unique, non-empty PublicKey and SecretKey
+	// returns non-nil err for invalid email or password, or if the email is already in use
 	CreateAccount(name, email, password string) (*Account, error)
 
 	// DELETE
@@ -33,6 +42,8 @@ type AccountStore interface {
 	DeleteAccount(pkey int64) (bool, error)
 
 	// QUERY
+	// No match is not an error: the Account* methods return a nil MaybeAccount and nil err.
+	// Email addresses are compared case-insensitively.
 	AllAccounts() ([]*Account, error)
 	AccountWithId(pkey int64) (MaybeAccount, error)
 	AccountWithEmail(q string) (MaybeAccount, error)
@@ -40,11 +51,14 @@ type AccountStore interface {
 	EmailAddressAvailable(email string) (bool, *deeperror.DeepError)
 
 	// UPDATE
+	// Change* methods return non-nil err for unknown pkeys, invalid input or an email already in use by another account.
+	// UpdateUserLastLogin returns a nil MaybeAccount for unknown pkeys
 	ChangeUserEmail(pkey int64, newEmail string) error
 	ChangeUserPassword(pkey int64, newPassword string) error
 	UpdateUserLastLogin(pkey int64) (MaybeAccount, error)
 
 	// AUTH
+	// returns nil account and non-nil err for unknown email or wrong password
 	Login(submittedEmail, submittedPassword string) (*Account, error)
 }
 
@@ -100,7 +114,14 @@ func (ma MaybeAccount) IsNil() bool {
 // UTILITIES
 
 func generateSecretKey() (string, error) {
-	c := 64
+	return generateRandomKey(64)
+}
+
+func generatePublicKey() (string, error) {
+	return generateRandomKey(24)
+}
+
+func generateRandomKey(c int) (string, error) {
 	data := make([]byte, c)
 	n, err := io.ReadFull(rand.Reader, data)
 	if n != len(data) || err != nil {
@@ -111,6 +132,91 @@ func generateSecretKey() (string, error) {
 	return base64.URLEncoding.EncodeToString(data), nil
 }
 
+// Passwords are stored as PBKDF2-HMAC-SHA256 hashes.
+// The stored form is "pbkdf2-sha256$<iterations>$<salt>$<hash>" so the parameters can be raised later
+// without invalidating existing hashes.
+const (
+	passhashPrefix     = "pbkdf2-sha256"
+	passhashIterations = 100000
+	passhashSaltLength = 16
+	passhashKeyLength  = 32
+)
+
+func HashPassword(password string) ([]byte, error) {
+	salt := make([]byte, passhashSaltLength)
+	n, err := io.ReadFull(rand.Reader, salt)
+	if n != len(salt) || err != nil {
+		return nil, deeperror.NewHTTPError(1297539204, "Password Hash Error", err, http.StatusInternalServerError)
+	}
+
+	key := pbkdf2SHA256([]byte(password), salt, passhashIterations, passhashKeyLength)
+	parts := []string{
+		passhashPrefix,
+		strconv.Itoa(passhashIterations),
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	}
+	return []byte(strings.Join(parts, "$")), nil
+}
+
+// constant time comparison.  returns false for any malformed passhash.
+func PasswordMatchesHash(password string, passhash []byte) bool {
+	parts := bytes.Split(passhash, []byte("$"))
+	if len(parts) != 4 || string(parts[0]) != passhashPrefix {
+		return false
+	}
+	iterations, err := strconv.Atoi(string(parts[1]))
+	if err != nil || iterations < 1 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(string(parts[2]))
+	if err != nil {
+		return false
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(string(parts[3]))
+	if err != nil || len(expected) == 0 {
+		return false
+	}
+
+	key := pbkdf2SHA256([]byte(password), salt, iterations, len(expected))
+	return subtle.ConstantTimeCompare(key, expected) == 1
+}
+
+// RFC 2898 PBKDF2, specialized for HMAC-SHA256
+func pbkdf2SHA256(password, salt []byte, iterations, keyLength int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLength := prf.Size()
+	blockCount := (keyLength + hashLength - 1) / hashLength
+
+	key := make([]byte, 0, blockCount*hashLength)
+	blockIndex := make([]byte, 4)
+	u := make([]byte, hashLength)
+	for block := 1; block <= blockCount; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(blockIndex, uint32(block))
+		prf.Write(blockIndex)
+		t := prf.Sum(nil)
+		copy(u, t)
+
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLength]
+}
+
+// Email addresses are unique per store and compared case-insensitively.
+func normalizedEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
 // Yes this could be better... good enough for now.
 func SimpleEmailValidation(email string) bool {
 	if len(email) < 5 || len(email) > MAX_EMAIL_LENGTH {
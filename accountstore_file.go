@@ -0,0 +1,834 @@
+package grunway
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amattn/deeperror"
+)
+
+// FileAccountStore is an embedded, file-backed AccountStore intended for small deployments.
+//
+// State lives in a single directory:
+//   - accounts.snapshot is the complete set of accounts as of the last compaction
+//   - accounts.journal is an append-only log (JSON Lines) of every change since then
+//
+// Every change is appended to the journal (and fsync'd, unless disabled) before it is applied in memory.
+// On Startup the snapshot is loaded, the journal is replayed and then compacted into a new snapshot.
+// A partially written final journal line (ie a crash mid-write) is discarded during replay.
+// Journal entries carry a sequence number and the snapshot records the last one it contains, so entries
+// left behind by a crash mid-compaction are skipped rather than applied twice.
+//
+// The store is safe for concurrent use within a single process.  It is NOT safe to point two
+// processes at the same directory.
+type FileAccountStore struct {
+	mu sync.RWMutex
+
+	config FileAccountStoreConfig
+
+	journal      *os.File
+	journalCount int   // entries written since the last compaction
+	seq          int64 // sequence number of the last journal entry applied
+
+	nextPKey       int64
+	accounts       map[int64]*Account // key is pkey
+	emailIndex     map[string]int64   // key is normalized email, value is pkey
+	publicKeyIndex map[string]int64   // key is public key, value is pkey
+}
+
+type FileAccountStoreConfig struct {
+	Dir              string      // required
+	Fsync            bool        // fsync the journal after every write.  default true
+	SnapshotInterval int         // number of journal entries between compactions.  default 1000
+	FileMode         os.FileMode // mode for newly created files.  default 0600
+}
+
+const (
+	fileAccountStoreSnapshotName = "accounts.snapshot"
+	fileAccountStoreJournalName  = "accounts.journal"
+
+	fileAccountStoreDefaultSnapshotInterval = 1000
+	fileAccountStoreDefaultFileMode         = 0600
+
+	fileAccountJournalOpPut    = "put"
+	fileAccountJournalOpDelete = "delete"
+)
+
+type fileAccountJournalEntry struct {
+	Seq     int64    `json:"seq,omitempty"` // zero in journals written before sequence numbers
+	Op      string   `json:"op"`
+	PKey    int64    `json:"pkey"`
+	Account *Account `json:"account,omitempty"`
+}
+
+type fileAccountSnapshot struct {
+	Seq      int64      `json:"seq,omitempty"` // the last journal entry included
+	NextPKey int64      `json:"nextPKey"`
+	Accounts []*Account `json:"accounts"`
+}
+
+func NewFileAccountStore() *FileAccountStore {
+	store := new(FileAccountStore)
+	store.resetIndexes()
+	return store
+}
+
+// ParseFileAccountStoreAttribs parses a DSN-style attribute string, as passed to Startup.
+// Pairs are separated by spaces or semicolons:
+//
+//	dir=/var/lib/myapp/accounts fsync=true snapshot=1000 mode=0600
+//
+// A lone value without a key is treated as the dir.
+func ParseFileAccountStoreAttribs(attribs string) (FileAccountStoreConfig, error) {
+	config := FileAccountStoreConfig{
+		Fsync:            true,
+		SnapshotInterval: fileAccountStoreDefaultSnapshotInterval,
+		FileMode:         fileAccountStoreDefaultFileMode,
+	}
+
+	fields := strings.FieldsFunc(attribs, func(r rune) bool {
+		return r == ' ' || r == ';' || r == '\t' || r == '\n'
+	})
+
+	for _, field := range fields {
+		key, value := "dir", field
+		if i := strings.Index(field, "="); i >= 0 {
+			key, value = strings.ToLower(field[:i]), field[i+1:]
+		}
+
+		switch key {
+		case "dir", "path":
+			config.Dir = value
+		case "fsync", "sync":
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return config, deeperror.New(2280174353, "Invalid fsync attribute: "+value, err)
+			}
+			config.Fsync = b
+		case "snapshot", "snapshotinterval":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return config, deeperror.New(2280174354, "Invalid snapshot attribute: "+value, err)
+			}
+			config.SnapshotInterval = n
+		case "mode":
+			m, err := strconv.ParseUint(value, 8, 32)
+			if err != nil {
+				return config, deeperror.New(2280174355, "Invalid mode attribute: "+value, err)
+			}
+			config.FileMode = os.FileMode(m)
+		default:
+			return config, deeperror.New(2280174356, "Unknown attribute: "+key, nil)
+		}
+	}
+
+	if config.Dir == "" {
+		return config, deeperror.New(2280174357, "Missing dir attribute", nil)
+	}
+	return config, nil
+}
+
+//  #####                                           #####
+// #     # #####   ##   #####  ##### #    # #####  #     # #    # #    # #####
+// #         #    #  #  #    #   #   #    # #    # #       #    # #    #   #
+//  #####    #   #    # #    #   #   #    # #    #  #####  ###### #    #   #
+//       #   #   ###### #####    #   #    # #####        # #    # #    #   #
+// #     #   #   #    # #   #    #   #    # #      #     # #    # #    #   #
+//  #####    #   #    # #    #   #    ####  #       #####  #    #  ####    #
+//
+
+func (store *FileAccountStore) Startup(attribs string) error {
+	config, err := ParseFileAccountStoreAttribs(attribs)
+	if err != nil {
+		return err
+	}
+	return store.StartupWithConfig(config)
+}
+
+func (store *FileAccountStore) StartupWithConfig(config FileAccountStoreConfig) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if store.journal != nil {
+		return deeperror.New(2280174360, "FileAccountStore already started", nil)
+	}
+	if config.SnapshotInterval < 1 {
+		config.SnapshotInterval = fileAccountStoreDefaultSnapshotInterval
+	}
+	if config.FileMode == 0 {
+		config.FileMode = fileAccountStoreDefaultFileMode
+	}
+	store.config = config
+	store.resetIndexes()
+
+	err := os.MkdirAll(config.Dir, 0700)
+	if err != nil {
+		return deeperror.New(2280174361, "Cannot create account store directory", err)
+	}
+
+	err = store.loadSnapshot()
+	if err != nil {
+		return err
+	}
+
+	journal, err := os.OpenFile(store.journalPath(), os.O_RDWR|os.O_CREATE|os.O_APPEND, config.FileMode)
+	if err != nil {
+		return deeperror.New(2280174362, "Cannot open account store journal", err)
+	}
+
+	err = store.replayJournal(journal)
+	if err != nil {
+		journal.Close()
+		return err
+	}
+	store.journal = journal
+
+	// start every run with an empty journal
+	err = store.compact()
+	if err != nil {
+		store.journal.Close()
+		store.journal = nil
+		return err
+	}
+	return nil
+}
+
+func (store *FileAccountStore) Shutdown() error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if store.journal == nil {
+		return nil
+	}
+
+	compactErr := store.compact()
+	closeErr := store.journal.Close()
+	store.journal = nil
+
+	if compactErr != nil {
+		return compactErr
+	}
+	if closeErr != nil {
+		return deeperror.New(2280174363, "Cannot close account store journal", closeErr)
+	}
+	return nil
+}
+
+//  #####
+// #     # #####  ######   ##   ##### ######
+// #       #    # #       #  #    #   #
+// #       #    # #####  #    #   #   #####
+// #       #####  #      ######   #   #
+// #     # #   #  #      #    #   #   #
+//  #####  #    # ###### #    #   #   ######
+//
+
+func (store *FileAccountStore) CreateAccount(name, email, password string) (*Account, error) {
+	if SimpleEmailValidation(email) == false {
+		return nil, deeperror.NewHTTPError(2280174370, "Invalid email address", nil, http.StatusBadRequest)
+	}
+	if SimplePasswordValidation(password) == false {
+		return nil, deeperror.NewHTTPError(2280174371, "Invalid password", nil, http.StatusBadRequest)
+	}
+
+	passhash, err := HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	secretKey, err := generateSecretKey()
+	if err != nil {
+		return nil, err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if derr := store.checkStarted(); derr != nil {
+		return nil, derr
+	}
+	if _, exists := store.emailIndex[normalizedEmail(email)]; exists {
+		return nil, deeperror.NewHTTPError(2280174372, "Email address already in use", nil, http.StatusConflict)
+	}
+
+	publicKey, err := store.uniquePublicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	acct := &Account{
+		PKey:      store.nextPKey,
+		Name:      name,
+		Email:     strings.TrimSpace(email),
+		Passhash:  passhash,
+		PublicKey: publicKey,
+		SecretKey: secretKey,
+		Created:   now,
+		Modified:  now,
+	}
+
+	err = store.writePut(acct)
+	if err != nil {
+		return nil, err
+	}
+	return copyAccount(acct), nil
+}
+
+// ######
+// #     # ###### #      ###### ##### ######
+// #     # #      #      #        #   #
+// #     # #####  #      #####    #   #####
+// #     # #      #      #        #   #
+// #     # #      #      #        #   #
+// ######  ###### ###### ######   #   ######
+//
+
+func (store *FileAccountStore) DeleteAccount(pkey int64) (bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if derr := store.checkStarted(); derr != nil {
+		return false, derr
+	}
+	if _, exists := store.accounts[pkey]; exists == false {
+		return false, nil
+	}
+
+	err := store.writeEntry(fileAccountJournalEntry{Op: fileAccountJournalOpDelete, PKey: pkey})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+//  #####
+// #     # #    # ###### #####  #   #
+// #     # #    # #      #    #  # #
+// #     # #    # #####  #    #   #
+// #   # # #    # #      #####    #
+// #    #  #    # #      #   #    #
+//  #### #  ####  ###### #    #   #
+//
+
+// sorted by pkey
+func (store *FileAccountStore) AllAccounts() ([]*Account, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	if derr := store.checkStarted(); derr != nil {
+		return nil, derr
+	}
+
+	accounts := make([]*Account, 0, len(store.accounts))
+	for _, acct := range store.accounts {
+		accounts = append(accounts, copyAccount(acct))
+	}
+	sort.Sort(accountsByPKey(accounts))
+	return accounts, nil
+}
+
+func (store *FileAccountStore) AccountWithId(pkey int64) (MaybeAccount, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	if derr := store.checkStarted(); derr != nil {
+		return MaybeAccount{}, derr
+	}
+	return MakeMaybeAccount(copyAccount(store.accounts[pkey])), nil
+}
+
+func (store *FileAccountStore) AccountWithEmail(q string) (MaybeAccount, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	if derr := store.checkStarted(); derr != nil {
+		return MaybeAccount{}, derr
+	}
+	pkey, exists := store.emailIndex[normalizedEmail(q)]
+	if exists == false {
+		return MaybeAccount{}, nil
+	}
+	return MakeMaybeAccount(copyAccount(store.accounts[pkey])), nil
+}
+
+func (store *FileAccountStore) AccountWithPublicKey(q string) (MaybeAccount, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	if derr := store.checkStarted(); derr != nil {
+		return MaybeAccount{}, derr
+	}
+	pkey, exists := store.publicKeyIndex[q]
+	if exists == false {
+		return MaybeAccount{}, nil
+	}
+	return MakeMaybeAccount(copyAccount(store.accounts[pkey])), nil
+}
+
+func (store *FileAccountStore) EmailAddressAvailable(email string) (bool, *deeperror.DeepError) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	if derr := store.checkStarted(); derr != nil {
+		return false, derr
+	}
+	_, exists := store.emailIndex[normalizedEmail(email)]
+	return !exists, nil
+}
+
+// #     #
+// #     # #####  #####    ##   ##### ######
+// #     # #    # #    #  #  #    #   #
+// #     # #    # #    # #    #   #   #####
+// #     # #####  #    # ######   #   #
+// #     # #      #    # #    #   #   #
+//  #####  #      #####  #    #   #   ######
+//
+
+func (store *FileAccountStore) ChangeUserEmail(pkey int64, newEmail string) error {
+	if SimpleEmailValidation(newEmail) == false {
+		return deeperror.NewHTTPError(2280174380, "Invalid email address", nil, http.StatusBadRequest)
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	acct, derr := store.existingAccount(pkey)
+	if derr != nil {
+		return derr
+	}
+	if existingPKey, exists := store.emailIndex[normalizedEmail(newEmail)]; exists && existingPKey != pkey {
+		return deeperror.NewHTTPError(2280174381, "Email address already in use", nil, http.StatusConflict)
+	}
+
+	acct.Email = strings.TrimSpace(newEmail)
+	acct.Modified = time.Now().UTC()
+	return store.writePut(acct)
+}
+
+func (store *FileAccountStore) ChangeUserPassword(pkey int64, newPassword string) error {
+	if SimplePasswordValidation(newPassword) == false {
+		return deeperror.NewHTTPError(2280174382, "Invalid password", nil, http.StatusBadRequest)
+	}
+	passhash, err := HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	acct, derr := store.existingAccount(pkey)
+	if derr != nil {
+		return derr
+	}
+
+	acct.Passhash = passhash
+	acct.Modified = time.Now().UTC()
+	return store.writePut(acct)
+}
+
+func (store *FileAccountStore) UpdateUserLastLogin(pkey int64) (MaybeAccount, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	acct, derr := store.existingAccount(pkey)
+	if derr != nil {
+		if derr.StatusCode == http.StatusNotFound {
+			return MaybeAccount{}, nil
+		}
+		return MaybeAccount{}, derr
+	}
+
+	acct.LastLogin = time.Now().UTC()
+	err := store.writePut(acct)
+	if err != nil {
+		return MaybeAccount{}, err
+	}
+	return MakeMaybeAccount(copyAccount(acct)), nil
+}
+
+//    #
+//   # #   #    # ##### #    #
+//  #   #  #    #   #   #    #
+// #     # #    #   #   ######
+// ####### #    #   #   #    #
+// #     # #    #   #   #    #
+// #     #  ####    #   #    #
+//
+
+// Login does not touch LastLogin, call UpdateUserLastLogin for that.
+// Unknown email and wrong password return the same error.
+func (store *FileAccountStore) Login(submittedEmail, submittedPassword string) (*Account, error) {
+	store.mu.RLock()
+	if derr := store.checkStarted(); derr != nil {
+		store.mu.RUnlock()
+		return nil, derr
+	}
+	acct := copyAccount(store.accounts[store.emailIndex[normalizedEmail(submittedEmail)]])
+	store.mu.RUnlock()
+
+	if acct == nil {
+		// burn roughly the same amount of time as a real check.
+		PasswordMatchesHash(submittedPassword, dummyPasshash())
+		return nil, deeperror.NewHTTPError(2280174390, "Invalid email or password", nil, http.StatusUnauthorized)
+	}
+	if PasswordMatchesHash(submittedPassword, acct.Passhash) == false {
+		return nil, deeperror.NewHTTPError(2280174390, "Invalid email or password", nil, http.StatusUnauthorized)
+	}
+	return acct, nil
+}
+
+var dummyPasshashOnce sync.Once
+var dummyPasshashBytes []byte
+
+func dummyPasshash() []byte {
+	dummyPasshashOnce.Do(func() {
+		dummyPasshashBytes, _ = HashPassword("not a real password 2280174391")
+	})
+	return dummyPasshashBytes
+}
+
+// #     #
+// #     # ##### # #      # ##### # ######  ####
+// #     #   #   # #      #   #   # #      #
+// #     #   #   # #      #   #   # #####   ####
+// #     #   #   # #      #   #   # #           #
+// #     #   #   # #      #   #   # #      #    #
+//  #####    #   # ###### #   #   # ######  ####
+//
+
+func (store *FileAccountStore) resetIndexes() {
+	store.seq = 0
+	store.nextPKey = 1
+	store.accounts = make(map[int64]*Account)
+	store.emailIndex = make(map[string]int64)
+	store.publicKeyIndex = make(map[string]int64)
+}
+
+func (store *FileAccountStore) snapshotPath() string {
+	return filepath.Join(store.config.Dir, fileAccountStoreSnapshotName)
+}
+func (store *FileAccountStore) journalPath() string {
+	return filepath.Join(store.config.Dir, fileAccountStoreJournalName)
+}
+
+// must hold lock
+func (store *FileAccountStore) checkStarted() *deeperror.DeepError {
+	if store.journal == nil {
+		return deeperror.NewHTTPError(2280174400, "FileAccountStore not started", nil, http.StatusServiceUnavailable)
+	}
+	return nil
+}
+
+// must hold lock.  returns a copy, safe to modify and pass to writePut
+func (store *FileAccountStore) existingAccount(pkey int64) (*Account, *deeperror.DeepError) {
+	if derr := store.checkStarted(); derr != nil {
+		return nil, derr
+	}
+	acct, exists := store.accounts[pkey]
+	if exists == false {
+		return nil, deeperror.NewHTTPError(2280174401, "No such account", nil, http.StatusNotFound)
+	}
+	return copyAccount(acct), nil
+}
+
+// must hold lock
+func (store *FileAccountStore) uniquePublicKey() (string, error) {
+	for i := 0; i < 8; i++ {
+		publicKey, err := generatePublicKey()
+		if err != nil {
+			return "", err
+		}
+		if _, exists := store.publicKeyIndex[publicKey]; exists == false {
+			return publicKey, nil
+		}
+	}
+	return "", deeperror.NewHTTPError(2280174402, "Key Generation Error", nil, http.StatusInternalServerError)
+}
+
+// must hold lock
+func (store *FileAccountStore) writePut(acct *Account) error {
+	return store.writeEntry(fileAccountJournalEntry{Op: fileAccountJournalOpPut, PKey: acct.PKey, Account: acct})
+}
+
+// must hold lock.  The entry is durable before it is visible.
+func (store *FileAccountStore) writeEntry(entry fileAccountJournalEntry) error {
+	entry.Seq = store.seq + 1
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return deeperror.New(2280174410, "Cannot encode account store journal entry", err)
+	}
+	line = append(line, '\n')
+
+	info, err := store.journal.Stat()
+	if err != nil {
+		return deeperror.New(2280174413, "Cannot stat account store journal", err)
+	}
+	offset := info.Size()
+
+	written, err := store.journal.Write(line)
+	if err == nil && written != len(line) {
+		err = io.ErrShortWrite
+	}
+	if err != nil {
+		store.discardJournalAfter(offset)
+		return deeperror.New(2280174411, "Cannot write account store journal", err)
+	}
+	if store.config.Fsync {
+		err = store.journal.Sync()
+		if err != nil {
+			store.discardJournalAfter(offset)
+			return deeperror.New(2280174412, "Cannot sync account store journal", err)
+		}
+	}
+
+	err = store.apply(entry)
+	if err != nil {
+		store.discardJournalAfter(offset)
+		return err
+	}
+	store.seq = entry.Seq
+
+	store.journalCount++
+	if store.journalCount >= store.config.SnapshotInterval {
+		// the change is already durable in the journal, so a failed compaction isn't the caller's problem.
+		// journalCount stays put and the next write tries again.
+		err = store.compact()
+		if err != nil {
+			log.Println("2280174414 account store compaction failed, will retry", err)
+		}
+	}
+	return nil
+}
+
+// must hold lock.  Drops a failed write so the next entry doesn't land after half a line,
+// and an unacknowledged change isn't replayed on the next Startup.
+func (store *FileAccountStore) discardJournalAfter(offset int64) {
+	err := store.journal.Truncate(offset)
+	if err != nil {
+		log.Println("2280174415 cannot truncate account store journal after failed write", err)
+	}
+}
+
+// must hold lock.  Re-applying an entry the snapshot already contains is harmless on its own, but a later
+// entry may have reused its email or public key, so replayJournal skips anything the snapshot covers.
+func (store *FileAccountStore) apply(entry fileAccountJournalEntry) error {
+	switch entry.Op {
+	case fileAccountJournalOpPut:
+		acct := entry.Account
+		if acct == nil || acct.PKey != entry.PKey || acct.PKey < 1 {
+			return deeperror.New(2280174420, "Malformed account store journal entry", nil)
+		}
+		emailKey := normalizedEmail(acct.Email)
+		if existingPKey, exists := store.emailIndex[emailKey]; exists && existingPKey != acct.PKey {
+			return deeperror.NewHTTPError(2280174421, "Duplicate email address in account store", nil, http.StatusConflict)
+		}
+		if existingPKey, exists := store.publicKeyIndex[acct.PublicKey]; exists && existingPKey != acct.PKey {
+			return deeperror.NewHTTPError(2280174422, "Duplicate public key in account store", nil, http.StatusConflict)
+		}
+
+		store.removeFromIndexes(acct.PKey)
+		store.accounts[acct.PKey] = copyAccount(acct)
+		store.emailIndex[emailKey] = acct.PKey
+		store.publicKeyIndex[acct.PublicKey] = acct.PKey
+		if acct.PKey >= store.nextPKey {
+			store.nextPKey = acct.PKey + 1
+		}
+	case fileAccountJournalOpDelete:
+		store.removeFromIndexes(entry.PKey)
+		delete(store.accounts, entry.PKey)
+	default:
+		return deeperror.New(2280174423, "Unknown account store journal op: "+entry.Op, nil)
+	}
+	return nil
+}
+
+// must hold lock
+func (store *FileAccountStore) removeFromIndexes(pkey int64) {
+	existing, exists := store.accounts[pkey]
+	if exists == false {
+		return
+	}
+	delete(store.emailIndex, normalizedEmail(existing.Email))
+	delete(store.publicKeyIndex, existing.PublicKey)
+}
+
+// must hold lock
+func (store *FileAccountStore) loadSnapshot() error {
+	snapshotBytes, err := ioutil.ReadFile(store.snapshotPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return deeperror.New(2280174430, "Cannot read account store snapshot", err)
+	}
+
+	var snapshot fileAccountSnapshot
+	err = json.Unmarshal(snapshotBytes, &snapshot)
+	if err != nil {
+		return deeperror.New(2280174431, "Cannot parse account store snapshot", err)
+	}
+
+	for _, acct := range snapshot.Accounts {
+		var pkey int64
+		if acct != nil {
+			pkey = acct.PKey
+		}
+		err = store.apply(fileAccountJournalEntry{Op: fileAccountJournalOpPut, PKey: pkey, Account: acct})
+		if err != nil {
+			return err
+		}
+	}
+	if snapshot.NextPKey > store.nextPKey {
+		store.nextPKey = snapshot.NextPKey
+	}
+	store.seq = snapshot.Seq
+	return nil
+}
+
+// must hold lock
+func (store *FileAccountStore) replayJournal(journal *os.File) error {
+	_, err := journal.Seek(0, io.SeekStart)
+	if err != nil {
+		return deeperror.New(2280174440, "Cannot read account store journal", err)
+	}
+
+	reader := bufio.NewReader(journal)
+	var goodOffset int64
+	lineNumber := 0
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if readErr == io.EOF {
+			if len(bytes.TrimSpace(line)) > 0 {
+				// a torn final write.  it was never acknowledged, so it's safe to drop.
+				err = journal.Truncate(goodOffset)
+				if err != nil {
+					return deeperror.New(2280174441, "Cannot truncate account store journal", err)
+				}
+			}
+			return nil
+		}
+		if readErr != nil {
+			return deeperror.New(2280174442, "Cannot read account store journal", readErr)
+		}
+		lineNumber++
+
+		var entry fileAccountJournalEntry
+		err = json.Unmarshal(line, &entry)
+		if err != nil {
+			return deeperror.New(2280174443, fmt.Sprintf("Corrupt account store journal at line %d", lineNumber), err)
+		}
+		goodOffset += int64(len(line))
+		if entry.Seq != 0 && entry.Seq <= store.seq {
+			// already in the snapshot, we crashed before the journal was truncated
+			continue
+		}
+		err = store.apply(entry)
+		if err != nil {
+			return err
+		}
+		if entry.Seq > store.seq {
+			store.seq = entry.Seq
+		}
+	}
+}
+
+// must hold lock.  Writes a new snapshot then empties the journal.
+// If we crash in between, the old journal's entries are skipped on the next Startup, see fileAccountSnapshot.Seq.
+func (store *FileAccountStore) compact() error {
+	snapshot := fileAccountSnapshot{
+		Seq:      store.seq,
+		NextPKey: store.nextPKey,
+		Accounts: make([]*Account, 0, len(store.accounts)),
+	}
+	for _, acct := range store.accounts {
+		snapshot.Accounts = append(snapshot.Accounts, acct)
+	}
+	sort.Sort(accountsByPKey(snapshot.Accounts))
+
+	snapshotBytes, err := json.Marshal(snapshot)
+	if err != nil {
+		return deeperror.New(2280174450, "Cannot encode account store snapshot", err)
+	}
+
+	err = writeFileAtomically(store.snapshotPath(), snapshotBytes, store.config.FileMode)
+	if err != nil {
+		return err
+	}
+
+	err = store.journal.Truncate(0)
+	if err != nil {
+		return deeperror.New(2280174451, "Cannot truncate account store journal", err)
+	}
+	err = store.journal.Sync()
+	if err != nil {
+		return deeperror.New(2280174452, "Cannot sync account store journal", err)
+	}
+	store.journalCount = 0
+	return nil
+}
+
+// write to a temp file, fsync, rename over the original, fsync the directory
+func writeFileAtomically(path string, data []byte, mode os.FileMode) error {
+	tmpPath := path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return deeperror.New(2280174460, "Cannot create temp file", err)
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return deeperror.New(2280174461, "Cannot write temp file", err)
+	}
+
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		os.Remove(tmpPath)
+		return deeperror.New(2280174462, "Cannot rename temp file", err)
+	}
+
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return deeperror.New(2280174463, "Cannot open directory", err)
+	}
+	defer dir.Close()
+	// some platforms don't support fsync on directories.  best effort.
+	dir.Sync()
+	return nil
+}
+
+func copyAccount(acct *Account) *Account {
+	if acct == nil {
+		return nil
+	}
+	dup := *acct
+	dup.Passhash = append([]byte(nil), acct.Passhash...)
+	return &dup
+}
+
+type accountsByPKey []*Account
+
+func (accts accountsByPKey) Len() int           { return len(accts) }
+func (accts accountsByPKey) Less(i, j int) bool { return accts[i].PKey < accts[j].PKey }
+func (accts accountsByPKey) Swap(i, j int)      { accts[i], accts[j] = accts[j], accts[i] }
@@ -0,0 +1,184 @@
+package grunway
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var _ AccountStore = (*FileAccountStore)(nil)
+
+func startTestFileAccountStore(t *testing.T, dir string) *FileAccountStore {
+	store := NewFileAccountStore()
+	err := store.Startup("dir=" + dir + ";fsync=false")
+	if err != nil {
+		t.Fatal("2469921070 Startup failed", err)
+	}
+	return store
+}
+
+func TestParseFileAccountStoreAttribs(t *testing.T) {
+	config, err := ParseFileAccountStoreAttribs("dir=/tmp/accts fsync=false;snapshot=10 mode=0640")
+	if err != nil {
+		t.Fatal("2469921071", err)
+	}
+	if config.Dir != "/tmp/accts" || config.Fsync != false || config.SnapshotInterval != 10 || config.FileMode != 0640 {
+		t.Errorf("2469921072 unexpected config %+v", config)
+	}
+
+	config, err = ParseFileAccountStoreAttribs("/tmp/accts")
+	if err != nil || config.Dir != "/tmp/accts" || config.Fsync != true {
+		t.Errorf("2469921073 unexpected config %+v, err %v", config, err)
+	}
+
+	badInputs := []string{"", "fsync=true", "dir=/tmp bogus=1", "dir=/tmp snapshot=0", "dir=/tmp fsync=maybe"}
+	for _, input := range badInputs {
+		if _, err := ParseFileAccountStoreAttribs(input); err == nil {
+			t.Errorf("2469921074 expected error for %q", input)
+		}
+	}
+}
+
+func TestFileAccountStoreRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grunway-accounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := startTestFileAccountStore(t, dir)
+	alice, err := store.CreateAccount("Alice", "alice@example.com", "password1")
+	if err != nil {
+		t.Fatal("2469921075", err)
+	}
+	bob, err := store.CreateAccount("Bob", "bob@example.com", "password2")
+	if err != nil {
+		t.Fatal("2469921076", err)
+	}
+	if _, err := store.CreateAccount("Alice Again", "ALICE@example.com", "password3"); err == nil {
+		t.Error("2469921077 expected duplicate email error")
+	}
+	if err := store.ChangeUserEmail(bob.PKey, "robert@example.com"); err != nil {
+		t.Error("2469921078", err)
+	}
+	if deleted, err := store.DeleteAccount(alice.PKey); deleted == false || err != nil {
+		t.Error("2469921079 expected delete to succeed", deleted, err)
+	}
+
+	// simulate a crash: no Shutdown, plus a torn write at the end of the journal.
+	journal, err := os.OpenFile(filepath.Join(dir, fileAccountStoreJournalName), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal.WriteString(`{"op":"put","pkey":99,"acc`)
+	journal.Close()
+	store.journal.Close()
+
+	store = startTestFileAccountStore(t, dir)
+	defer store.Shutdown()
+
+	accounts, err := store.AllAccounts()
+	if err != nil || len(accounts) != 1 {
+		t.Fatalf("2469921080 expected 1 account, got %d, err %v", len(accounts), err)
+	}
+
+	ma, _ := store.AccountWithEmail("Robert@Example.com")
+	if ma.IsNil() || ma.AccountOrCrash(0).PKey != bob.PKey {
+		t.Errorf("2469921081 expected bob by new email, got %+v", ma)
+	}
+	ma, _ = store.AccountWithPublicKey(bob.PublicKey)
+	if ma.IsNil() {
+		t.Error("2469921082 expected bob by public key")
+	}
+	available, _ := store.EmailAddressAvailable("bob@example.com")
+	if available == false {
+		t.Error("2469921083 old email should be available again")
+	}
+
+	if _, err := store.Login("robert@example.com", "password2"); err != nil {
+		t.Error("2469921084 expected login to succeed", err)
+	}
+	if _, err := store.Login("robert@example.com", "password1"); err == nil {
+		t.Error("2469921085 expected login with wrong password to fail")
+	}
+
+	// pkeys are never reused
+	carol, err := store.CreateAccount("Carol", "carol@example.com", "password4")
+	if err != nil {
+		t.Fatal("2469921086", err)
+	}
+	if carol.PKey <= bob.PKey {
+		t.Errorf("2469921087 expected new pkey > %d, got %d", bob.PKey, carol.PKey)
+	}
+}
+
+func TestFileAccountStoreStaleJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grunway-accounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// alice's email is freed and then reused, so replaying her put over the newer snapshot would collide
+	store := startTestFileAccountStore(t, dir)
+	alice, err := store.CreateAccount("Alice", "alice@example.com", "password1")
+	if err != nil {
+		t.Fatal("2469921090", err)
+	}
+	if deleted, err := store.DeleteAccount(alice.PKey); deleted == false || err != nil {
+		t.Fatal("2469921091 expected delete to succeed", deleted, err)
+	}
+	bob, err := store.CreateAccount("Bob", "alice@example.com", "password2")
+	if err != nil {
+		t.Fatal("2469921092", err)
+	}
+
+	journalPath := filepath.Join(dir, fileAccountStoreJournalName)
+	staleJournal, err := ioutil.ReadFile(journalPath)
+	if err != nil || len(staleJournal) == 0 {
+		t.Fatal("2469921093 expected a journal", err)
+	}
+
+	// simulate a crash after the snapshot was written but before the journal was truncated
+	if err := store.Shutdown(); err != nil {
+		t.Fatal("2469921094", err)
+	}
+	if err := ioutil.WriteFile(journalPath, staleJournal, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for restart := 0; restart < 2; restart++ {
+		store = startTestFileAccountStore(t, dir)
+		accounts, err := store.AllAccounts()
+		if err != nil || len(accounts) != 1 || accounts[0].PKey != bob.PKey {
+			t.Fatalf("2469921095 expected only bob after restart %d, got %+v, err %v", restart, accounts, err)
+		}
+		carol, err := store.CreateAccount("Carol", "carol@example.com", "password3")
+		if err != nil {
+			t.Fatal("2469921096", err)
+		}
+		if deleted, err := store.DeleteAccount(carol.PKey); deleted == false || err != nil {
+			t.Fatal("2469921097 expected delete to succeed", deleted, err)
+		}
+		if err := store.Shutdown(); err != nil {
+			t.Fatal("2469921098", err)
+		}
+	}
+}
+
+func TestPasswordHash(t *testing.T) {
+	passhash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal("2469921099", err)
+	}
+	if PasswordMatchesHash("correct horse", passhash) == false {
+		t.Error("2469921100 expected password to match")
+	}
+	if PasswordMatchesHash("correct horse!", passhash) {
+		t.Error("2469921101 expected password mismatch")
+	}
+	if PasswordMatchesHash("", []byte("garbage")) {
+		t.Error("2469921102 expected malformed hash to never match")
+	}
+}
@@ -0,0 +1,643 @@
+// Package accountstoretest is a conformance suite for grunway.AccountStore implementations.
+//
+// A backend certifies itself from its own tests with a single call:
+//
+//	func TestConformance(t *testing.T) {
+//		accountstoretest.RunSuite(t, accountstoretest.Backend{
+//			NewStore: func() grunway.AccountStore { return NewMyStore() },
+//			Attribs: func(t *testing.T) (string, func()) {
+//				db := createEmptyTestDatabase(t)
+//				return db.DSN(), func() { db.Drop() }
+//			},
+//			Persistent: true,
+//		})
+//	}
+package accountstoretest
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/amattn/grunway"
+)
+
+// Backend describes the stores under test.  The suite calls Startup and Shutdown itself.
+type Backend struct {
+	// NewStore returns a new, not yet started store.
+	NewStore func() grunway.AccountStore
+
+	// Attribs returns the Startup attribs of a fresh, empty backing store and a cleanup func to remove it.
+	// It is called once per sub-test.
+	Attribs func(t *testing.T) (attribs string, cleanup func())
+
+	// Persistent stores keep their data across Shutdown and Startup w/ the same attribs, which the Restart check verifies.
+	// Leave it false for in-memory stores.
+	Persistent bool
+}
+
+const (
+	validPassword      = "password1"
+	otherValidPassword = "password2"
+
+	concurrentWorkers = 16
+)
+
+// RunSuite runs every conformance check against stores produced by backend.
+func RunSuite(t *testing.T, backend Backend) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, store grunway.AccountStore)
+	}{
+		{"CreateAccount", testCreateAccount},
+		{"CreateAccountInvalidInput", testCreateAccountInvalidInput},
+		{"CreateAccountDuplicateEmail", testCreateAccountDuplicateEmail},
+		{"DeleteAccount", testDeleteAccount},
+		{"AllAccounts", testAllAccounts},
+		{"MaybeAccountSemantics", testMaybeAccountSemantics},
+		{"AccountWithEmailCaseInsensitive", testAccountWithEmailCaseInsensitive},
+		{"EmailAddressAvailable", testEmailAddressAvailable},
+		{"ChangeUserEmail", testChangeUserEmail},
+		{"ChangeUserPassword", testChangeUserPassword},
+		{"UpdateUserLastLogin", testUpdateUserLastLogin},
+		{"LoginFailureModes", testLoginFailureModes},
+		{"ConcurrentCreate", testConcurrentCreate},
+		{"ConcurrentCreateSameEmail", testConcurrentCreateSameEmail},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			attribs, cleanup := backend.Attribs(t)
+			if cleanup != nil {
+				defer cleanup()
+			}
+			store := mustStartup(t, backend, attribs)
+			defer mustShutdown(t, store)
+			test.fn(t, store)
+		})
+	}
+
+	t.Run("Restart", func(t *testing.T) {
+		if backend.Persistent == false {
+			t.Skip("not a Persistent backend")
+		}
+		attribs, cleanup := backend.Attribs(t)
+		if cleanup != nil {
+			defer cleanup()
+		}
+		testRestart(t, backend, attribs)
+	})
+}
+
+// #     #
+// #     # ###### #      #####  ###### #####   ####
+// #     # #      #      #    # #      #    # #
+// ####### #####  #      #    # #####  #    #  ####
+// #     # #      #      #####  #      #####       #
+// #     # #      #      #      #      #   #  #    #
+// #     # ###### ###### #      ###### #    #  ####
+//
+
+func mustStartup(t *testing.T, backend Backend, attribs string) grunway.AccountStore {
+	store := backend.NewStore()
+	if err := store.Startup(attribs); err != nil {
+		t.Fatalf("1513356430 Startup(%q) failed: %v", attribs, err)
+	}
+	return store
+}
+
+func mustShutdown(t *testing.T, store grunway.AccountStore) {
+	if err := store.Shutdown(); err != nil {
+		t.Errorf("1513356431 Shutdown failed: %v", err)
+	}
+}
+
+func mustCreate(t *testing.T, store grunway.AccountStore, name, email string) *grunway.Account {
+	acct, err := store.CreateAccount(name, email, validPassword)
+	if err != nil {
+		t.Fatalf("1513356290 CreateAccount(%q, %q) failed: %v", name, email, err)
+	}
+	if acct == nil {
+		t.Fatalf("1513356291 CreateAccount(%q, %q) returned nil account and nil err", name, email)
+	}
+	return acct
+}
+
+func mustFind(t *testing.T, ma grunway.MaybeAccount, err error, expectedPKey int64, what string) *grunway.Account {
+	if err != nil {
+		t.Fatalf("1513356292 %s returned err: %v", what, err)
+	}
+	if ma.IsNil() {
+		t.Fatalf("1513356293 %s returned nil MaybeAccount, expected pkey %d", what, expectedPKey)
+	}
+	acct := ma.AccountOrCrash(1513356294)
+	if acct.PKey != expectedPKey {
+		t.Fatalf("1513356295 %s returned pkey %d, expected %d", what, acct.PKey, expectedPKey)
+	}
+	return acct
+}
+
+func mustNotFind(t *testing.T, ma grunway.MaybeAccount, err error, what string) {
+	if err != nil {
+		t.Fatalf("1513356296 %s returned err: %v (no match is not an error)", what, err)
+	}
+	if ma.HasValidAccountPointer() {
+		t.Fatalf("1513356297 %s expected nil MaybeAccount, got %+v", what, ma.AccountOrCrash(1513356298))
+	}
+}
+
+//  #####
+// #     # #####  ######   ##   ##### ######
+// #       #    # #       #  #    #   #
+// #       #    # #####  #    #   #   #####
+// #       #####  #      ######   #   #
+// #     # #   #  #      #    #   #   #
+//  #####  #    # ###### #    #   #   ######
+//
+
+func testCreateAccount(t *testing.T, store grunway.AccountStore) {
+	before := time.Now().Add(-time.Second)
+	acct := mustCreate(t, store, "Alice", "alice@example.com")
+
+	if acct.PKey == 0 {
+		t.Error("1513356300 expected non-zero PKey")
+	}
+	if acct.Name != "Alice" || acct.Email != "alice@example.com" {
+		t.Errorf("1513356301 name/email not preserved: %+v", acct)
+	}
+	if acct.PublicKey == "" || acct.SecretKey == "" {
+		t.Errorf("1513356302 expected non-empty PublicKey and SecretKey: %+v", acct)
+	}
+	if acct.PublicKey == acct.SecretKey {
+		t.Error("1513356303 PublicKey and SecretKey must differ")
+	}
+	if len(acct.Passhash) == 0 || string(acct.Passhash) == validPassword {
+		t.Error("1513356304 Passhash must be set and must not be the plaintext password")
+	}
+	if acct.Created.Before(before) {
+		t.Errorf("1513356305 expected Created to be set, got %v", acct.Created)
+	}
+
+	other := mustCreate(t, store, "Bob", "bob@example.com")
+	if other.PKey == acct.PKey {
+		t.Error("1513356306 expected distinct PKeys")
+	}
+	if other.PublicKey == acct.PublicKey {
+		t.Error("1513356307 expected distinct PublicKeys")
+	}
+
+	ma, err := store.AccountWithPublicKey(acct.PublicKey)
+	mustFind(t, ma, err, acct.PKey, "AccountWithPublicKey")
+}
+
+func testCreateAccountInvalidInput(t *testing.T, store grunway.AccountStore) {
+	badEmails := []string{"", "alice", "alice@example", "@example.com", "alice@example."}
+	for _, email := range badEmails {
+		acct, err := store.CreateAccount("Alice", email, validPassword)
+		if err == nil || acct != nil {
+			t.Errorf("1513356310 CreateAccount with invalid email %q should fail, got %+v, %v", email, acct, err)
+		}
+	}
+
+	badPasswords := []string{"", "short1", "nodigitsorpunctuation"}
+	for _, password := range badPasswords {
+		acct, err := store.CreateAccount("Alice", "alice@example.com", password)
+		if err == nil || acct != nil {
+			t.Errorf("1513356311 CreateAccount with invalid password %q should fail, got %+v, %v", password, acct, err)
+		}
+	}
+
+	accounts, err := store.AllAccounts()
+	if err != nil || len(accounts) != 0 {
+		t.Errorf("1513356312 failed creates must not leave accounts behind, got %d, err %v", len(accounts), err)
+	}
+}
+
+func testCreateAccountDuplicateEmail(t *testing.T, store grunway.AccountStore) {
+	mustCreate(t, store, "Alice", "alice@example.com")
+
+	for _, email := range []string{"alice@example.com", "ALICE@Example.COM"} {
+		acct, err := store.CreateAccount("Mallory", email, validPassword)
+		if err == nil || acct != nil {
+			t.Errorf("1513356320 CreateAccount with duplicate email %q should fail, got %+v, %v", email, acct, err)
+		}
+	}
+}
+
+// ######
+// #     # ###### #      ###### ##### ######
+// #     # #      #      #        #   #
+// #     # #####  #      #####    #   #####
+// #     # #      #      #        #   #
+// #     # #      #      #        #   #
+// ######  ###### ###### ######   #   ######
+//
+
+func testDeleteAccount(t *testing.T, store grunway.AccountStore) {
+	acct := mustCreate(t, store, "Alice", "alice@example.com")
+
+	deleted, err := store.DeleteAccount(acct.PKey + 1000)
+	if deleted || err != nil {
+		t.Errorf("1513356330 DeleteAccount of unknown pkey should return false, nil.  got %v, %v", deleted, err)
+	}
+
+	deleted, err = store.DeleteAccount(acct.PKey)
+	if deleted == false || err != nil {
+		t.Errorf("1513356331 DeleteAccount should return true, nil.  got %v, %v", deleted, err)
+	}
+
+	deleted, err = store.DeleteAccount(acct.PKey)
+	if deleted || err != nil {
+		t.Errorf("1513356332 second DeleteAccount should return false, nil.  got %v, %v", deleted, err)
+	}
+
+	ma, err := store.AccountWithId(acct.PKey)
+	mustNotFind(t, ma, err, "AccountWithId after delete")
+	ma, err = store.AccountWithEmail(acct.Email)
+	mustNotFind(t, ma, err, "AccountWithEmail after delete")
+	ma, err = store.AccountWithPublicKey(acct.PublicKey)
+	mustNotFind(t, ma, err, "AccountWithPublicKey after delete")
+
+	available, derr := store.EmailAddressAvailable(acct.Email)
+	if derr != nil || available == false {
+		t.Errorf("1513356333 email should be available after delete, got %v, %v", available, derr)
+	}
+
+	if _, err := store.Login(acct.Email, validPassword); err == nil {
+		t.Error("1513356334 Login to deleted account should fail")
+	}
+}
+
+//  #####
+// #     # #    # ###### #####  #   #
+// #     # #    # #      #    #  # #
+// #     # #    # #####  #    #   #
+// #   # # #    # #      #####    #
+// #    #  #    # #      #   #    #
+//  #### #  ####  ###### #    #   #
+//
+
+func testAllAccounts(t *testing.T, store grunway.AccountStore) {
+	accounts, err := store.AllAccounts()
+	if err != nil || len(accounts) != 0 {
+		t.Fatalf("1513356340 expected empty store, got %d accounts, err %v", len(accounts), err)
+	}
+
+	created := make(map[int64]bool)
+	for i := 0; i < 5; i++ {
+		acct := mustCreate(t, store, fmt.Sprint("User", i), fmt.Sprintf("user%d@example.com", i))
+		created[acct.PKey] = true
+	}
+
+	accounts, err = store.AllAccounts()
+	if err != nil || len(accounts) != len(created) {
+		t.Fatalf("1513356341 expected %d accounts, got %d, err %v", len(created), len(accounts), err)
+	}
+	for _, acct := range accounts {
+		if acct == nil || created[acct.PKey] == false {
+			t.Errorf("1513356342 unexpected account %+v", acct)
+		}
+	}
+}
+
+func testMaybeAccountSemantics(t *testing.T, store grunway.AccountStore) {
+	acct := mustCreate(t, store, "Alice", "alice@example.com")
+
+	ma, err := store.AccountWithId(acct.PKey)
+	found := mustFind(t, ma, err, acct.PKey, "AccountWithId")
+	if found.Email != acct.Email || found.PublicKey != acct.PublicKey || found.SecretKey != acct.SecretKey {
+		t.Errorf("1513356350 AccountWithId returned different account: %+v vs %+v", found, acct)
+	}
+
+	// callers must not be able to corrupt the store by mutating returned accounts
+	found.Email = "mallory@example.com"
+	ma, err = store.AccountWithId(acct.PKey)
+	if refetched := mustFind(t, ma, err, acct.PKey, "AccountWithId"); refetched.Email != acct.Email {
+		t.Errorf("1513356351 mutating a returned account changed the store: %v", refetched.Email)
+	}
+
+	ma, err = store.AccountWithId(acct.PKey + 1000)
+	mustNotFind(t, ma, err, "AccountWithId(unknown)")
+	ma, err = store.AccountWithId(0)
+	mustNotFind(t, ma, err, "AccountWithId(0)")
+	ma, err = store.AccountWithEmail("nobody@example.com")
+	mustNotFind(t, ma, err, "AccountWithEmail(unknown)")
+	ma, err = store.AccountWithEmail("")
+	mustNotFind(t, ma, err, "AccountWithEmail(\"\")")
+	ma, err = store.AccountWithPublicKey("bogus")
+	mustNotFind(t, ma, err, "AccountWithPublicKey(unknown)")
+	ma, err = store.AccountWithPublicKey("")
+	mustNotFind(t, ma, err, "AccountWithPublicKey(\"\")")
+}
+
+func testAccountWithEmailCaseInsensitive(t *testing.T, store grunway.AccountStore) {
+	acct := mustCreate(t, store, "Alice", "Alice@Example.com")
+
+	for _, q := range []string{"Alice@Example.com", "alice@example.com", "ALICE@EXAMPLE.COM"} {
+		ma, err := store.AccountWithEmail(q)
+		mustFind(t, ma, err, acct.PKey, fmt.Sprintf("AccountWithEmail(%q)", q))
+	}
+}
+
+func testEmailAddressAvailable(t *testing.T, store grunway.AccountStore) {
+	available, derr := store.EmailAddressAvailable("alice@example.com")
+	if derr != nil || available == false {
+		t.Errorf("1513356360 expected available, got %v, %v", available, derr)
+	}
+
+	mustCreate(t, store, "Alice", "alice@example.com")
+
+	for _, email := range []string{"alice@example.com", "ALICE@example.com"} {
+		available, derr = store.EmailAddressAvailable(email)
+		if derr != nil || available {
+			t.Errorf("1513356361 expected %q unavailable, got %v, %v", email, available, derr)
+		}
+	}
+}
+
+// #     #
+// #     # #####  #####    ##   ##### ######
+// #     # #    # #    #  #  #    #   #
+// #     # #    # #    # #    #   #   #####
+// #     # #####  #    # ######   #   #
+// #     # #      #    # #    #   #   #
+//  #####  #      #####  #    #   #   ######
+//
+
+func testChangeUserEmail(t *testing.T, store grunway.AccountStore) {
+	alice := mustCreate(t, store, "Alice", "alice@example.com")
+	bob := mustCreate(t, store, "Bob", "bob@example.com")
+
+	if err := store.ChangeUserEmail(alice.PKey, "bob@example.com"); err == nil {
+		t.Error("1513356370 changing to an email in use should fail")
+	}
+	if err := store.ChangeUserEmail(alice.PKey, "BOB@example.com"); err == nil {
+		t.Error("1513356371 changing to an email in use (different case) should fail")
+	}
+	if err := store.ChangeUserEmail(alice.PKey, "not an email"); err == nil {
+		t.Error("1513356372 changing to an invalid email should fail")
+	}
+	if err := store.ChangeUserEmail(bob.PKey+1000, "carol@example.com"); err == nil {
+		t.Error("1513356373 changing email of unknown pkey should fail")
+	}
+
+	if err := store.ChangeUserEmail(alice.PKey, "alice2@example.com"); err != nil {
+		t.Fatal("1513356374 ChangeUserEmail failed", err)
+	}
+	// changing to your own address (in any case) is fine
+	if err := store.ChangeUserEmail(alice.PKey, "ALICE2@example.com"); err != nil {
+		t.Error("1513356375 ChangeUserEmail to own address failed", err)
+	}
+
+	ma, err := store.AccountWithEmail("alice2@example.com")
+	mustFind(t, ma, err, alice.PKey, "AccountWithEmail(new)")
+	ma, err = store.AccountWithEmail("alice@example.com")
+	mustNotFind(t, ma, err, "AccountWithEmail(old)")
+
+	available, derr := store.EmailAddressAvailable("alice@example.com")
+	if derr != nil || available == false {
+		t.Errorf("1513356376 old email should be available, got %v, %v", available, derr)
+	}
+	if _, err := store.Login("alice2@example.com", validPassword); err != nil {
+		t.Error("1513356377 Login with new email failed", err)
+	}
+}
+
+func testChangeUserPassword(t *testing.T, store grunway.AccountStore) {
+	alice := mustCreate(t, store, "Alice", "alice@example.com")
+
+	if err := store.ChangeUserPassword(alice.PKey, "short"); err == nil {
+		t.Error("1513356380 changing to an invalid password should fail")
+	}
+	if err := store.ChangeUserPassword(alice.PKey+1000, otherValidPassword); err == nil {
+		t.Error("1513356381 changing password of unknown pkey should fail")
+	}
+
+	if err := store.ChangeUserPassword(alice.PKey, otherValidPassword); err != nil {
+		t.Fatal("1513356382 ChangeUserPassword failed", err)
+	}
+	if _, err := store.Login("alice@example.com", validPassword); err == nil {
+		t.Error("1513356383 Login with old password should fail")
+	}
+	if acct, err := store.Login("alice@example.com", otherValidPassword); err != nil || acct == nil {
+		t.Error("1513356384 Login with new password failed", err)
+	}
+}
+
+func testUpdateUserLastLogin(t *testing.T, store grunway.AccountStore) {
+	alice := mustCreate(t, store, "Alice", "alice@example.com")
+	before := time.Now().Add(-time.Second)
+
+	ma, err := store.UpdateUserLastLogin(alice.PKey)
+	updated := mustFind(t, ma, err, alice.PKey, "UpdateUserLastLogin")
+	if updated.LastLogin.Before(before) {
+		t.Errorf("1513356390 expected LastLogin to be updated, got %v", updated.LastLogin)
+	}
+
+	ma, err = store.AccountWithId(alice.PKey)
+	refetched := mustFind(t, ma, err, alice.PKey, "AccountWithId")
+	if refetched.LastLogin.Before(before) {
+		t.Errorf("1513356391 expected LastLogin to be persisted, got %v", refetched.LastLogin)
+	}
+
+	ma, err = store.UpdateUserLastLogin(alice.PKey + 1000)
+	mustNotFind(t, ma, err, "UpdateUserLastLogin(unknown)")
+}
+
+//    #
+//   # #   #    # ##### #    #
+//  #   #  #    #   #   #    #
+// #     # #    #   #   ######
+// ####### #    #   #   #    #
+// #     # #    #   #   #    #
+// #     #  ####    #   #    #
+//
+
+func testLoginFailureModes(t *testing.T, store grunway.AccountStore) {
+	alice := mustCreate(t, store, "Alice", "alice@example.com")
+
+	acct, err := store.Login("alice@example.com", validPassword)
+	if err != nil || acct == nil || acct.PKey != alice.PKey {
+		t.Fatalf("1513356400 Login failed: %+v, %v", acct, err)
+	}
+	acct, err = store.Login("ALICE@example.com", validPassword)
+	if err != nil || acct == nil || acct.PKey != alice.PKey {
+		t.Errorf("1513356401 Login should be case-insensitive on email: %+v, %v", acct, err)
+	}
+
+	failures := []struct {
+		email, password string
+	}{
+		{"alice@example.com", otherValidPassword},
+		{"alice@example.com", ""},
+		{"alice@example.com", "PASSWORD1"},
+		{"nobody@example.com", validPassword},
+		{"", validPassword},
+		{"", ""},
+	}
+	for _, f := range failures {
+		acct, err := store.Login(f.email, f.password)
+		if err == nil {
+			t.Errorf("1513356402 Login(%q, %q) should fail", f.email, f.password)
+		}
+		if acct != nil {
+			t.Errorf("1513356403 Login(%q, %q) must return nil account on failure, got %+v", f.email, f.password, acct)
+		}
+	}
+}
+
+//  #####
+// #     #  ####  #    #  ####  #    # #####  #####  ###### #    #  ####  #   #
+// #       #    # ##   # #    # #    # #    # #    # #      ##   # #    #  # #
+// #       #    # # #  # #      #    # #    # #    # #####  # #  # #        #
+// #       #    # #  # # #      #    # #####  #####  #      #  # # #        #
+// #     # #    # #   ## #    # #    # #   #  #   #  #      #   ## #    #   #
+//  #####   ####  #    #  ####   ####  #    # #    # ###### #    #  ####    #
+//
+
+func testConcurrentCreate(t *testing.T, store grunway.AccountStore) {
+	var wg sync.WaitGroup
+	results := make(chan *grunway.Account, concurrentWorkers)
+	errs := make(chan error, concurrentWorkers)
+
+	for i := 0; i < concurrentWorkers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			acct, err := store.CreateAccount(fmt.Sprint("User", i), fmt.Sprintf("user%d@example.com", i), validPassword)
+			if err != nil {
+				errs <- err
+				return
+			}
+			results <- acct
+			// mix in some reads
+			store.AccountWithId(acct.PKey)
+			store.AllAccounts()
+		}(i)
+	}
+	wg.Wait()
+	close(results)
+	close(errs)
+
+	for err := range errs {
+		t.Error("1513356410 concurrent CreateAccount failed", err)
+	}
+
+	pkeys := make(map[int64]bool)
+	publicKeys := make(map[string]bool)
+	for acct := range results {
+		if pkeys[acct.PKey] || publicKeys[acct.PublicKey] {
+			t.Errorf("1513356411 duplicate pkey or public key under concurrency: %+v", acct)
+		}
+		pkeys[acct.PKey] = true
+		publicKeys[acct.PublicKey] = true
+	}
+
+	accounts, err := store.AllAccounts()
+	if err != nil || len(accounts) != concurrentWorkers {
+		t.Errorf("1513356412 expected %d accounts, got %d, err %v", concurrentWorkers, len(accounts), err)
+	}
+}
+
+func testConcurrentCreateSameEmail(t *testing.T, store grunway.AccountStore) {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	successes := 0
+
+	for i := 0; i < concurrentWorkers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			acct, err := store.CreateAccount(fmt.Sprint("User", i), "same@example.com", validPassword)
+			if err == nil && acct != nil {
+				mu.Lock()
+				successes++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if successes != 1 {
+		t.Errorf("1513356420 expected exactly 1 successful CreateAccount for the same email, got %d", successes)
+	}
+	accounts, err := store.AllAccounts()
+	if err != nil || len(accounts) != 1 {
+		t.Errorf("1513356421 expected 1 account, got %d, err %v", len(accounts), err)
+	}
+}
+
+// ######
+// #     # ######  ####  #####   ##   #####  #####
+// #     # #      #        #    #  #  #    #   #
+// ######  #####   ####    #   #    # #    #   #
+// #   #   #           #   #   ###### #####    #
+// #    #  #      #    #   #   #    # #   #    #
+// #     # ######  ####    #   #    # #    #   #
+//
+
+func testRestart(t *testing.T, backend Backend, attribs string) {
+	store := mustStartup(t, backend, attribs)
+	alice := mustCreate(t, store, "Alice", "alice@example.com")
+	bob := mustCreate(t, store, "Bob", "bob@example.com")
+	carol := mustCreate(t, store, "Carol", "carol@example.com")
+	if err := store.ChangeUserEmail(alice.PKey, "alice@example.org"); err != nil {
+		t.Fatal("1513356432 ChangeUserEmail failed:", err)
+	}
+	if err := store.ChangeUserPassword(bob.PKey, otherValidPassword); err != nil {
+		t.Fatal("1513356433 ChangeUserPassword failed:", err)
+	}
+	ma, err := store.UpdateUserLastLogin(bob.PKey)
+	bobLoggedIn := mustFind(t, ma, err, bob.PKey, "UpdateUserLastLogin")
+	if deleted, err := store.DeleteAccount(carol.PKey); deleted == false || err != nil {
+		t.Fatal("1513356434 DeleteAccount failed:", deleted, err)
+	}
+	mustShutdown(t, store)
+
+	// twice, so whatever the first Startup rewrites is also read back
+	for restart := 1; restart <= 2; restart++ {
+		store = mustStartup(t, backend, attribs)
+
+		ma, err = store.AccountWithEmail("alice@example.org")
+		reopened := mustFind(t, ma, err, alice.PKey, "AccountWithEmail after restart")
+		if reopened.Name != alice.Name || reopened.PublicKey != alice.PublicKey || reopened.SecretKey != alice.SecretKey {
+			t.Errorf("1513356435 restart %d: account not preserved, had %+v, got %+v", restart, alice, reopened)
+		}
+		if reopened.Created.Equal(alice.Created) == false {
+			t.Errorf("1513356436 restart %d: Created not preserved, had %v, got %v", restart, alice.Created, reopened.Created)
+		}
+		ma, err = store.AccountWithEmail("alice@example.com")
+		mustNotFind(t, ma, err, "AccountWithEmail(old email) after restart")
+
+		ma, err = store.AccountWithPublicKey(bob.PublicKey)
+		reopened = mustFind(t, ma, err, bob.PKey, "AccountWithPublicKey after restart")
+		if reopened.LastLogin.Before(bobLoggedIn.LastLogin.Add(-time.Second)) {
+			t.Errorf("1513356437 restart %d: LastLogin not preserved, had %v, got %v", restart, bobLoggedIn.LastLogin, reopened.LastLogin)
+		}
+		if _, err := store.Login("bob@example.com", otherValidPassword); err != nil {
+			t.Errorf("1513356438 restart %d: changed password not preserved: %v", restart, err)
+		}
+		if _, err := store.Login("bob@example.com", validPassword); err == nil {
+			t.Errorf("1513356439 restart %d: old password still works", restart)
+		}
+
+		ma, err = store.AccountWithId(carol.PKey)
+		mustNotFind(t, ma, err, "AccountWithId(deleted) after restart")
+		if available, derr := store.EmailAddressAvailable("carol@example.com"); available == false || derr != nil {
+			t.Errorf("1513356440 restart %d: deleted account's email should be available: %v %v", restart, available, derr)
+		}
+
+		// primary keys are never handed out twice, deleted ones included
+		dave := mustCreate(t, store, fmt.Sprint("Dave", restart), fmt.Sprintf("dave%d@example.com", restart))
+		if dave.PKey == alice.PKey || dave.PKey == bob.PKey || dave.PKey == carol.PKey {
+			t.Errorf("1513356441 restart %d: reused pkey %d", restart, dave.PKey)
+		}
+		all, err := store.AllAccounts()
+		if err != nil || len(all) != 2+restart {
+			t.Errorf("1513356442 restart %d: expected %d accounts, got %d (%v)", restart, 2+restart, len(all), err)
+		}
+		mustShutdown(t, store)
+	}
+}
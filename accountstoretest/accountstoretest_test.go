@@ -0,0 +1,23 @@
+package accountstoretest
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/amattn/grunway"
+)
+
+func TestFileAccountStoreConformance(t *testing.T) {
+	RunSuite(t, Backend{
+		NewStore: func() grunway.AccountStore { return grunway.NewFileAccountStore() },
+		Attribs: func(t *testing.T) (string, func()) {
+			dir, err := ioutil.TempDir("", "grunway-accountstoretest")
+			if err != nil {
+				t.Fatal(err)
+			}
+			return "dir=" + dir + " fsync=false", func() { os.RemoveAll(dir) }
+		},
+		Persistent: true,
+	})
+}
@@ -0,0 +1,331 @@
+package grunway
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/amattn/deeperror"
+)
+
+// Single-use, expiring tokens for flows where the user proves they control an email address.
+// Only a SHA-256 hash of each token is ever stored, the plaintext token only exists in the email.
+
+type AccountTokenPurpose string
+
+const (
+	AccountTokenPasswordReset     AccountTokenPurpose = "password_reset"
+	AccountTokenEmailVerification AccountTokenPurpose = "email_verification"
+)
+
+const (
+	AccountTokenInvalidErrorNumber = 4000000101 // unknown, expired, already used or wrong purpose.  we don't say which.
+)
+
+type AccountToken struct {
+	Hash        string // hex encoded sha256 of the plaintext token
+	Purpose     AccountTokenPurpose
+	AccountPKey int64
+	Email       string // the address the token was sent to
+	Created     time.Time
+	Expires     time.Time
+}
+
+// AccountTokenStore persists hashed tokens.  Implementations must be safe for concurrent use.
+type AccountTokenStore interface {
+	SaveToken(token AccountToken) error
+
+	// ConsumeToken atomically removes and returns the token with the given hash and purpose.
+	// Unknown, expired and wrong-purpose tokens return a non-nil err.
+	// A token can only ever be consumed once.
+	ConsumeToken(hash string, purpose AccountTokenPurpose, now time.Time) (AccountToken, error)
+
+	// Invalidate all outstanding tokens for an account, eg after a successful reset
+	DeleteTokensForAccount(pkey int64, purpose AccountTokenPurpose) error
+}
+
+// Messages are pluggable so apps can link to their own web pages
+type AccountTokenMessageFunc func(acct *Account, email, token string, expires time.Time) (subject, body string)
+
+type AccountTokenManager struct {
+	Accounts AccountStore
+	Tokens   AccountTokenStore
+	Mailer   Mailer
+
+	PasswordResetTTL     time.Duration
+	EmailVerificationTTL time.Duration
+
+	PasswordResetMessage     AccountTokenMessageFunc
+	EmailVerificationMessage AccountTokenMessageFunc
+
+	now func() time.Time // swappable for testing
+}
+
+func NewAccountTokenManager(accounts AccountStore, tokens AccountTokenStore, mailer Mailer) *AccountTokenManager {
+	manager := new(AccountTokenManager)
+	manager.Accounts = accounts
+	manager.Tokens = tokens
+	manager.Mailer = mailer
+	manager.PasswordResetTTL = time.Hour
+	manager.EmailVerificationTTL = 24 * time.Hour
+	manager.PasswordResetMessage = DefaultPasswordResetMessage
+	manager.EmailVerificationMessage = DefaultEmailVerificationMessage
+	manager.now = time.Now
+	return manager
+}
+
+func DefaultPasswordResetMessage(acct *Account, email, token string, expires time.Time) (subject, body string) {
+	subject = "Password reset"
+	body = fmt.Sprintf("Someone requested a password reset for this address.\n\nReset code: %s\n\nThis code expires at %s.  If you didn't request a reset, ignore this message.\n",
+		token, expires.UTC().Format(time.RFC1123))
+	return
+}
+
+func DefaultEmailVerificationMessage(acct *Account, email, token string, expires time.Time) (subject, body string) {
+	subject = "Verify your email address"
+	body = fmt.Sprintf("Please confirm this address.\n\nVerification code: %s\n\nThis code expires at %s.  If you didn't request this, ignore this message.\n",
+		token, expires.UTC().Format(time.RFC1123))
+	return
+}
+
+// ######
+// #     #   ##    ####   ####  #    #  ####  #####  #####
+// #     #  #  #  #      #      #    # #    # #    # #    #
+// ######  #    #  ####   ####  #    # #    # #    # #    #
+// #       ######      #      # # ## # #    # #####  #    #
+// #       #    # #    # #    # ##  ## #    # #   #  #    #
+// #       #    #  ####   ####  #    #  ####  #    # #####
+//
+
+// RequestPasswordReset mails a reset token if there is an account with that email.
+// Unknown emails are not an error, so callers can't use this to probe for accounts.
+func (manager *AccountTokenManager) RequestPasswordReset(email string) error {
+	ma, err := manager.Accounts.AccountWithEmail(email)
+	if err != nil {
+		return err
+	}
+	if ma.IsNil() {
+		return nil
+	}
+	acct := ma.AccountOrCrash(1601983420)
+
+	return manager.issue(acct, acct.Email, AccountTokenPasswordReset, manager.PasswordResetTTL, manager.PasswordResetMessage)
+}
+
+// ConfirmPasswordReset consumes the token and sets the new password.
+// The password is validated before the token is consumed, so a typo doesn't burn the token.
+func (manager *AccountTokenManager) ConfirmPasswordReset(token, newPassword string) (*Account, error) {
+	if SimplePasswordValidation(newPassword) == false {
+		return nil, deeperror.NewHTTPError(1601983421, "Invalid password", nil, http.StatusBadRequest)
+	}
+
+	consumed, err := manager.consume(token, AccountTokenPasswordReset)
+	if err != nil {
+		return nil, err
+	}
+
+	err = manager.Accounts.ChangeUserPassword(consumed.AccountPKey, newPassword)
+	if err != nil {
+		return nil, err
+	}
+	err = manager.Tokens.DeleteTokensForAccount(consumed.AccountPKey, AccountTokenPasswordReset)
+	if err != nil {
+		return nil, err
+	}
+
+	return manager.accountForToken(consumed)
+}
+
+// #######
+// #       #    #   ##   # #
+// #       ##  ##  #  #  # #
+// #####   # ## # #    # # #
+// #       #    # ###### # #
+// #       #    # #    # # #
+// ####### #    # #    # # ######
+//
+
+// RequestEmailVerification mails a verification token to newEmail.
+// Once confirmed, the account's email is changed to newEmail.  Pass the current address to just verify it.
+func (manager *AccountTokenManager) RequestEmailVerification(pkey int64, newEmail string) error {
+	if SimpleEmailValidation(newEmail) == false {
+		return deeperror.NewHTTPError(1601983430, "Invalid email address", nil, http.StatusBadRequest)
+	}
+
+	ma, err := manager.Accounts.AccountWithId(pkey)
+	if err != nil {
+		return err
+	}
+	if ma.IsNil() {
+		return deeperror.NewHTTPError(1601983431, "No such account", nil, http.StatusNotFound)
+	}
+	acct := ma.AccountOrCrash(1601983432)
+
+	if normalizedEmail(newEmail) != normalizedEmail(acct.Email) {
+		available, derr := manager.Accounts.EmailAddressAvailable(newEmail)
+		if derr != nil {
+			return derr
+		}
+		if available == false {
+			return deeperror.NewHTTPError(1601983433, "Email address already in use", nil, http.StatusConflict)
+		}
+	}
+
+	return manager.issue(acct, newEmail, AccountTokenEmailVerification, manager.EmailVerificationTTL, manager.EmailVerificationMessage)
+}
+
+// ConfirmEmailVerification consumes the token and, if necessary, changes the account's email to the verified address.
+func (manager *AccountTokenManager) ConfirmEmailVerification(token string) (*Account, error) {
+	consumed, err := manager.consume(token, AccountTokenEmailVerification)
+	if err != nil {
+		return nil, err
+	}
+
+	acct, err := manager.accountForToken(consumed)
+	if err != nil {
+		return nil, err
+	}
+
+	if acct.Email != consumed.Email {
+		err = manager.Accounts.ChangeUserEmail(consumed.AccountPKey, consumed.Email)
+		if err != nil {
+			return nil, err
+		}
+		acct, err = manager.accountForToken(consumed)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = manager.Tokens.DeleteTokensForAccount(consumed.AccountPKey, AccountTokenEmailVerification)
+	if err != nil {
+		return nil, err
+	}
+	return acct, nil
+}
+
+// #     #
+// #     # ##### # #      # ##### # ######  ####
+// #     #   #   # #      #   #   # #      #
+// #     #   #   # #      #   #   # #####   ####
+// #     #   #   # #      #   #   # #           #
+// #     #   #   # #      #   #   # #      #    #
+//  #####    #   # ###### #   #   # ######  ####
+//
+
+func (manager *AccountTokenManager) issue(acct *Account, email string, purpose AccountTokenPurpose, ttl time.Duration, messageFunc AccountTokenMessageFunc) error {
+	plaintext, err := generateRandomKey(32)
+	if err != nil {
+		return err
+	}
+
+	now := manager.now()
+	token := AccountToken{
+		Hash:        HashAccountToken(plaintext),
+		Purpose:     purpose,
+		AccountPKey: acct.PKey,
+		Email:       email,
+		Created:     now,
+		Expires:     now.Add(ttl),
+	}
+	err = manager.Tokens.SaveToken(token)
+	if err != nil {
+		return err
+	}
+
+	subject, body := messageFunc(acct, email, plaintext, token.Expires)
+	err = manager.Mailer.SendMail(email, subject, body)
+	if err != nil {
+		return deeperror.New(1601983440, "Cannot send mail", err)
+	}
+	return nil
+}
+
+func (manager *AccountTokenManager) consume(plaintext string, purpose AccountTokenPurpose) (AccountToken, error) {
+	if plaintext == "" {
+		return AccountToken{}, deeperror.NewHTTPError(AccountTokenInvalidErrorNumber, "Invalid or expired token", nil, http.StatusBadRequest)
+	}
+	return manager.Tokens.ConsumeToken(HashAccountToken(plaintext), purpose, manager.now())
+}
+
+func (manager *AccountTokenManager) accountForToken(token AccountToken) (*Account, error) {
+	ma, err := manager.Accounts.AccountWithId(token.AccountPKey)
+	if err != nil {
+		return nil, err
+	}
+	if ma.IsNil() {
+		return nil, deeperror.NewHTTPError(AccountTokenInvalidErrorNumber, "Invalid or expired token", nil, http.StatusBadRequest)
+	}
+	return ma.AccountOrCrash(1601983441), nil
+}
+
+// Tokens are 256 bits of randomness, so a plain (fast) hash is fine.  No salt or stretching required.
+func HashAccountToken(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
+
+// #     #
+// ##   ## ###### #    #  ####  #####  #   #
+// # # # # #      ##  ## #    # #    #  # #
+// #  #  # #####  # ## # #    # #    #   #
+// #     # #      #    # #    # #####    #
+// #     # #      #    # #    # #   #    #
+// #     # ###### #    #  ####  #    #   #
+//
+
+// MemoryAccountTokenStore is an in-process AccountTokenStore.  Expired tokens are dropped on each SaveToken.
+type MemoryAccountTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]AccountToken // key is hash
+}
+
+func NewMemoryAccountTokenStore() *MemoryAccountTokenStore {
+	store := new(MemoryAccountTokenStore)
+	store.tokens = make(map[string]AccountToken)
+	return store
+}
+
+func (store *MemoryAccountTokenStore) SaveToken(token AccountToken) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for hash, existing := range store.tokens {
+		if existing.Expires.Before(token.Created) {
+			delete(store.tokens, hash)
+		}
+	}
+	store.tokens[token.Hash] = token
+	return nil
+}
+
+func (store *MemoryAccountTokenStore) ConsumeToken(hash string, purpose AccountTokenPurpose, now time.Time) (AccountToken, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	token, exists := store.tokens[hash]
+	if exists == false || token.Purpose != purpose {
+		return AccountToken{}, deeperror.NewHTTPError(AccountTokenInvalidErrorNumber, "Invalid or expired token", nil, http.StatusBadRequest)
+	}
+	delete(store.tokens, hash)
+
+	if now.After(token.Expires) {
+		return AccountToken{}, deeperror.NewHTTPError(AccountTokenInvalidErrorNumber, "Invalid or expired token", nil, http.StatusBadRequest)
+	}
+	return token, nil
+}
+
+func (store *MemoryAccountTokenStore) DeleteTokensForAccount(pkey int64, purpose AccountTokenPurpose) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for hash, token := range store.tokens {
+		if token.AccountPKey == pkey && token.Purpose == purpose {
+			delete(store.tokens, hash)
+		}
+	}
+	return nil
+}
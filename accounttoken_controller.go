@@ -0,0 +1,159 @@
+package grunway
+
+import (
+	"net/http"
+)
+
+// Standard controllers for the AccountTokenManager flows.  Register them like any other entity:
+//
+//	router.RegisterEntity("passwordreset", &grunway.PasswordResetController{Manager: manager})
+//	router.RegisterEntity("emailverification", &grunway.EmailVerificationController{Manager: manager, Authenticator: auth})
+//
+// which gives:
+//
+//	POST /v1/passwordreset/request       {"Email": "..."}
+//	POST /v1/passwordreset/confirm       {"Token": "...", "NewPassword": "..."}
+//	POST /v1/emailverification/request   {"Email": "..."}  (requires auth)
+//	POST /v1/emailverification/confirm   {"Token": "..."}
+
+type PasswordResetRequestPayload struct {
+	Email string
+}
+
+func (payload PasswordResetRequestPayload) PayloadType() string {
+	return "passwordResetRequest"
+}
+
+type PasswordResetConfirmPayload struct {
+	Token       string
+	NewPassword string
+}
+
+func (payload PasswordResetConfirmPayload) PayloadType() string {
+	return "passwordResetConfirm"
+}
+
+type EmailVerificationRequestPayload struct {
+	Email string
+}
+
+func (payload EmailVerificationRequestPayload) PayloadType() string {
+	return "emailVerificationRequest"
+}
+
+type EmailVerificationConfirmPayload struct {
+	Token string
+}
+
+func (payload EmailVerificationConfirmPayload) PayloadType() string {
+	return "emailVerificationConfirm"
+}
+
+// ######
+// #     #   ##    ####   ####  #    #  ####  #####  #####
+// #     #  #  #  #      #      #    # #    # #    # #    #
+// ######  #    #  ####   ####  #    # #    # #    # #    #
+// #       ######      #      # # ## # #    # #####  #    #
+// #       #    # #    # #    # ##  ## #    # #   #  #    #
+// #       #    #  ####   ####  #    #  ####  #    # #####
+//
+
+type PasswordResetController struct {
+	Manager *AccountTokenManager
+}
+
+// Always responds Ok for well formed requests, whether or not the account exists.
+func (controller *PasswordResetController) PostHandlerV1Request(ctx *Context) RouteHandlerResult {
+	var payload PasswordResetRequestPayload
+	if rhr, failed := decodeRequestBody(ctx, &payload, 2035519541); failed {
+		return rhr
+	}
+
+	err := controller.Manager.RequestPasswordReset(payload.Email)
+	if err != nil {
+		return ctx.MakeRouteHandlerResultFromError(err)
+	}
+	return ctx.MakeRouteHandlerResultOk()
+}
+
+func (controller *PasswordResetController) PostHandlerV1Confirm(ctx *Context) RouteHandlerResult {
+	var payload PasswordResetConfirmPayload
+	if rhr, failed := decodeRequestBody(ctx, &payload, 2035519542); failed {
+		return rhr
+	}
+
+	_, err := controller.Manager.ConfirmPasswordReset(payload.Token, payload.NewPassword)
+	if err != nil {
+		return ctx.MakeRouteHandlerResultFromError(err)
+	}
+	return ctx.MakeRouteHandlerResultOk()
+}
+
+// #######
+// #       #    #   ##   # #
+// #       ##  ##  #  #  # #
+// #####   # ## # #    # # #
+// #       #    # ###### # #
+// #       #    # #    # # #
+// ####### #    # #    # # ######
+//
+
+// Requesting verification requires auth, so we know whose address is changing.
+// The Authenticator must set ctx.AccountPKey or ctx.PublicKey on success.
+type EmailVerificationController struct {
+	Manager       *AccountTokenManager
+	Authenticator AuthHandler
+}
+
+func (controller *EmailVerificationController) PerformAuth(routePtr *Route, ctx *Context) (bool, int) {
+	if controller.Authenticator == nil {
+		return false, 2035519550
+	}
+	return controller.Authenticator.PerformAuth(routePtr, ctx)
+}
+func (controller *EmailVerificationController) GetSecretKey(publicKey string) (string, int) {
+	if controller.Authenticator == nil {
+		return "", 2035519551
+	}
+	return controller.Authenticator.GetSecretKey(publicKey)
+}
+
+func (controller *EmailVerificationController) AuthPostHandlerV1Request(ctx *Context) RouteHandlerResult {
+	var payload EmailVerificationRequestPayload
+	if rhr, failed := decodeRequestBody(ctx, &payload, 2035519552); failed {
+		return rhr
+	}
+
+	var ma MaybeAccount
+	var err error
+	if ctx.AccountPKey != 0 {
+		ma, err = controller.Manager.Accounts.AccountWithId(ctx.AccountPKey)
+	} else {
+		ma, err = controller.Manager.Accounts.AccountWithPublicKey(ctx.PublicKey)
+	}
+	if err != nil {
+		return ctx.MakeRouteHandlerResultFromError(err)
+	}
+	if ma.IsNil() {
+		return ctx.MakeRouteHandlerResultError(http.StatusForbidden, 2035519553, "Forbidden")
+	}
+
+	err = controller.Manager.RequestEmailVerification(ma.AccountOrCrash(2035519554).PKey, payload.Email)
+	if err != nil {
+		return ctx.MakeRouteHandlerResultFromError(err)
+	}
+	return ctx.MakeRouteHandlerResultOk()
+}
+
+func (controller *EmailVerificationController) PostHandlerV1Confirm(ctx *Context) RouteHandlerResult {
+	var payload EmailVerificationConfirmPayload
+	if rhr, failed := decodeRequestBody(ctx, &payload, 2035519555); failed {
+		return rhr
+	}
+
+	_, err := controller.Manager.ConfirmEmailVerification(payload.Token)
+	if err != nil {
+		return ctx.MakeRouteHandlerResultFromError(err)
+	}
+	return ctx.MakeRouteHandlerResultOk()
+}
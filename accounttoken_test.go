@@ -0,0 +1,137 @@
+package grunway
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+type capturingMailer struct {
+	to, subject, body []string
+}
+
+func (mailer *capturingMailer) SendMail(to, subject, body string) error {
+	mailer.to = append(mailer.to, to)
+	mailer.subject = append(mailer.subject, subject)
+	mailer.body = append(mailer.body, body)
+	return nil
+}
+
+// the message body is just the token, to make tests easy
+func tokenOnlyMessage(acct *Account, email, token string, expires time.Time) (string, string) {
+	return "token", token
+}
+
+func makeTestAccountTokenManager(t *testing.T) (*AccountTokenManager, *capturingMailer, func()) {
+	dir, err := ioutil.TempDir("", "grunway-accounttoken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := startTestFileAccountStore(t, dir)
+	mailer := new(capturingMailer)
+	manager := NewAccountTokenManager(store, NewMemoryAccountTokenStore(), mailer)
+	manager.PasswordResetMessage = tokenOnlyMessage
+	manager.EmailVerificationMessage = tokenOnlyMessage
+	return manager, mailer, func() {
+		store.Shutdown()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestPasswordResetFlow(t *testing.T) {
+	manager, mailer, cleanup := makeTestAccountTokenManager(t)
+	defer cleanup()
+
+	alice, err := manager.Accounts.CreateAccount("Alice", "alice@example.com", "password1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.RequestPasswordReset("nobody@example.com"); err != nil || len(mailer.to) != 0 {
+		t.Fatal("1047825531 unknown email should silently succeed without mail", err, mailer.to)
+	}
+	if err := manager.RequestPasswordReset("ALICE@example.com"); err != nil || len(mailer.to) != 1 {
+		t.Fatal("1047825532 expected one reset mail", err, mailer.to)
+	}
+	token := mailer.body[0]
+
+	if _, err := manager.ConfirmEmailVerification(token); err == nil {
+		t.Error("1047825533 reset token must not work for email verification")
+	}
+	if _, err := manager.ConfirmPasswordReset(token, "short"); err == nil {
+		t.Error("1047825534 invalid new password should fail")
+	}
+	acct, err := manager.ConfirmPasswordReset(token, "password2")
+	if err != nil || acct.PKey != alice.PKey {
+		t.Fatal("1047825535 ConfirmPasswordReset failed", err)
+	}
+	if _, err := manager.ConfirmPasswordReset(token, "password3"); err == nil {
+		t.Error("1047825536 tokens must be single use")
+	}
+	if _, err := manager.Accounts.Login("alice@example.com", "password2"); err != nil {
+		t.Error("1047825537 login with new password failed", err)
+	}
+
+	// expiry
+	manager.RequestPasswordReset("alice@example.com")
+	manager.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if _, err := manager.ConfirmPasswordReset(mailer.body[1], "password4"); err == nil {
+		t.Error("1047825538 expired token should fail")
+	}
+}
+
+func TestEmailVerificationFlow(t *testing.T) {
+	manager, mailer, cleanup := makeTestAccountTokenManager(t)
+	defer cleanup()
+
+	alice, _ := manager.Accounts.CreateAccount("Alice", "alice@example.com", "password1")
+	manager.Accounts.CreateAccount("Bob", "bob@example.com", "password1")
+
+	if err := manager.RequestEmailVerification(alice.PKey, "bob@example.com"); err == nil {
+		t.Error("1047825540 verifying an address in use should fail")
+	}
+	if err := manager.RequestEmailVerification(alice.PKey, "alice@new.example.com"); err != nil {
+		t.Fatal("1047825541", err)
+	}
+	if mailer.to[0] != "alice@new.example.com" {
+		t.Errorf("1047825542 verification should be sent to the new address, got %v", mailer.to[0])
+	}
+
+	acct, err := manager.ConfirmEmailVerification(mailer.body[0])
+	if err != nil || acct.Email != "alice@new.example.com" {
+		t.Fatal("1047825543 ConfirmEmailVerification failed", acct, err)
+	}
+}
+
+func TestPasswordResetController(t *testing.T) {
+	manager, mailer, cleanup := makeTestAccountTokenManager(t)
+	defer cleanup()
+	manager.Accounts.CreateAccount("Alice", "alice@example.com", "password1")
+
+	router := NewRouter()
+	router.BasePath = "/api/"
+	router.PostProcessors = nil
+	router.RegisterEntity("passwordreset", &PasswordResetController{Manager: manager})
+
+	post := func(path string, payload interface{}) int {
+		body, _ := json.Marshal(payload)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", path, bytes.NewReader(body)))
+		return w.Code
+	}
+
+	if code := post("/api/v1/passwordreset/request", PasswordResetRequestPayload{Email: "alice@example.com"}); code != http.StatusOK {
+		t.Fatal("1047825550 expected 200, got", code)
+	}
+	if code := post("/api/v1/passwordreset/confirm", PasswordResetConfirmPayload{Token: "bogus", NewPassword: "password2"}); code != http.StatusBadRequest {
+		t.Error("1047825551 expected 400 for bogus token, got", code)
+	}
+	if code := post("/api/v1/passwordreset/confirm", PasswordResetConfirmPayload{Token: mailer.body[0], NewPassword: "password2"}); code != http.StatusOK {
+		t.Error("1047825552 expected 200, got", code)
+	}
+}
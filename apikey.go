@@ -0,0 +1,290 @@
+package grunway
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/amattn/deeperror"
+)
+
+// An account can hold any number of named API keys.  To rotate, create a new key, move clients over,
+// then revoke the old one.  Both keys work in the meantime.
+type APIKey struct {
+	PublicKey   string
+	SecretKey   string
+	AccountPKey int64
+	Name        string
+
+	Created  time.Time
+	LastUsed time.Time // zero if never used
+	Expires  time.Time // zero means never expires
+	Revoked  time.Time // zero unless revoked
+}
+
+func (key *APIKey) IsRevoked() bool {
+	return key.Revoked.IsZero() == false
+}
+func (key *APIKey) IsExpired(now time.Time) bool {
+	return key.Expires.IsZero() == false && now.After(key.Expires)
+}
+func (key *APIKey) IsActive(now time.Time) bool {
+	return key.IsRevoked() == false && key.IsExpired(now) == false
+}
+
+// APIKeyStore persists API keys.  Implementations must be safe for concurrent use.
+type APIKeyStore interface {
+	// expires may be zero for keys that never expire
+	CreateAPIKey(accountPKey int64, name string, expires time.Time) (*APIKey, error)
+
+	// includes revoked and expired keys, sorted by Created
+	APIKeysForAccount(accountPKey int64) ([]*APIKey, error)
+
+	// returns nil, nil for unknown keys.  revoked and expired keys are still returned.
+	APIKeyWithPublicKey(publicKey string) (*APIKey, error)
+
+	// returns true if the key belonged to the account and was not already revoked
+	RevokeAPIKey(accountPKey int64, publicKey string) (bool, error)
+
+	// record use.  implementations may coarsen this to avoid a write per request
+	TouchAPIKey(publicKey string, now time.Time) error
+}
+
+const (
+	APIKeyUnknownErrorNumber = 4030000101
+	APIKeyRevokedErrorNumber = 4030000102
+	APIKeyExpiredErrorNumber = 4030000103
+)
+
+//    #
+//   # #   #    # ##### #    # ###### #    # ##### #  ####    ##   #####  ####  #####
+//  #   #  #    #   #   #    # #      ##   #   #   # #    #  #  #    #   #    # #    #
+// #     # #    #   #   ###### #####  # #  #   #   # #      #    #   #   #    # #    #
+// ####### #    #   #   #    # #      #  # #   #   # #      ######   #   #    # #####
+// #     # #    #   #   #    # #      #   ##   #   # #    # #    #   #   #    # #   #
+// #     #  ####    #   #    # ###### #    #   #   #  ####  #    #   #    ####  #    #
+//
+
+// APIKeyAuthenticator is an AuthHandler that verifies signed requests (see SignRequest)
+// against any active key in Keys.
+// If Accounts is set, each account's original Account.PublicKey/SecretKey pair is also accepted.
+//
+// On success ctx.PublicKey is set to the key used and ctx.AccountPKey to the owning account.
+type APIKeyAuthenticator struct {
+	Keys     APIKeyStore
+	Accounts AccountStore // optional, for legacy single-key accounts
+
+	MaxSkew      time.Duration
+	MaxBodyBytes int64 // signed bodies larger than this are rejected.  default DefaultSignatureMaxBodyBytes
+
+	now func() time.Time // swappable for testing
+}
+
+func NewAPIKeyAuthenticator(keys APIKeyStore, accounts AccountStore) *APIKeyAuthenticator {
+	auth := new(APIKeyAuthenticator)
+	auth.Keys = keys
+	auth.Accounts = accounts
+	auth.MaxSkew = DefaultSignatureMaxSkew
+	auth.MaxBodyBytes = DefaultSignatureMaxBodyBytes
+	auth.now = time.Now
+	return auth
+}
+
+func (auth *APIKeyAuthenticator) PerformAuth(routePtr *Route, ctx *Context) (bool, int) {
+	now := auth.now()
+	publicKey, isValid, errNum := VerifyRequestSignatureWithLimit(ctx, auth.GetSecretKey, auth.MaxSkew, auth.MaxBodyBytes, now)
+	if isValid == false {
+		return false, errNum
+	}
+
+	accountPKey, isAPIKey, errNum := auth.accountPKey(publicKey)
+	if errNum != 0 {
+		return false, errNum
+	}
+
+	// only genuine, signed requests count as use.  LastUsed is informational, so a failure doesn't fail the request.
+	if isAPIKey {
+		err := auth.Keys.TouchAPIKey(publicKey, now)
+		if err != nil {
+			log.Println("2113094316 TouchAPIKey failed", publicKey, err)
+		}
+	}
+
+	ctx.PublicKey = publicKey
+	ctx.AccountPKey = accountPKey
+	return true, 0
+}
+
+// GetSecretKey resolves through the key set first, then legacy account keys.
+func (auth *APIKeyAuthenticator) GetSecretKey(publicKey string) (string, int) {
+	key, err := auth.Keys.APIKeyWithPublicKey(publicKey)
+	if err != nil {
+		return "", 2113094310
+	}
+	if key != nil {
+		now := auth.now()
+		switch {
+		case key.IsRevoked():
+			return "", APIKeyRevokedErrorNumber
+		case key.IsExpired(now):
+			return "", APIKeyExpiredErrorNumber
+		}
+		return key.SecretKey, 0
+	}
+
+	if auth.Accounts != nil {
+		ma, err := auth.Accounts.AccountWithPublicKey(publicKey)
+		if err != nil {
+			return "", 2113094311
+		}
+		if ma.HasValidAccountPointer() {
+			return ma.AccountOrCrash(2113094312).SecretKey, 0
+		}
+	}
+	return "", APIKeyUnknownErrorNumber
+}
+
+// isAPIKey is false for legacy account keys
+func (auth *APIKeyAuthenticator) accountPKey(publicKey string) (accountPKey int64, isAPIKey bool, errNum int) {
+	key, err := auth.Keys.APIKeyWithPublicKey(publicKey)
+	if err != nil {
+		return 0, false, 2113094313
+	}
+	if key != nil {
+		return key.AccountPKey, true, 0
+	}
+	if auth.Accounts != nil {
+		ma, err := auth.Accounts.AccountWithPublicKey(publicKey)
+		if err != nil {
+			return 0, false, 2113094314
+		}
+		if ma.HasValidAccountPointer() {
+			return ma.AccountOrCrash(2113094315).PKey, false, 0
+		}
+	}
+	return 0, false, APIKeyUnknownErrorNumber
+}
+
+// #     #
+// ##   ## ###### #    #  ####  #####  #   #
+// # # # # #      ##  ## #    # #    #  # #
+// #  #  # #####  # ## # #    # #    #   #
+// #     # #      #    # #    # #####    #
+// #     # #      #    # #    # #   #    #
+// #     # ###### #    #  ####  #    #   #
+//
+
+// MemoryAPIKeyStore is an in-process APIKeyStore.
+type MemoryAPIKeyStore struct {
+	mu   sync.RWMutex
+	keys map[string]*APIKey // key is public key
+}
+
+func NewMemoryAPIKeyStore() *MemoryAPIKeyStore {
+	store := new(MemoryAPIKeyStore)
+	store.keys = make(map[string]*APIKey)
+	return store
+}
+
+func (store *MemoryAPIKeyStore) CreateAPIKey(accountPKey int64, name string, expires time.Time) (*APIKey, error) {
+	if accountPKey <= 0 {
+		return nil, deeperror.NewHTTPError(2113094320, "Invalid account", nil, http.StatusBadRequest)
+	}
+	secretKey, err := generateSecretKey()
+	if err != nil {
+		return nil, err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	var publicKey string
+	for i := 0; i < 8 && publicKey == ""; i++ {
+		candidate, err := generatePublicKey()
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := store.keys[candidate]; exists == false {
+			publicKey = candidate
+		}
+	}
+	if publicKey == "" {
+		return nil, deeperror.NewHTTPError(2113094321, "Key Generation Error", nil, http.StatusInternalServerError)
+	}
+
+	key := &APIKey{
+		PublicKey:   publicKey,
+		SecretKey:   secretKey,
+		AccountPKey: accountPKey,
+		Name:        name,
+		Created:     time.Now().UTC(),
+		Expires:     expires,
+	}
+	store.keys[publicKey] = key
+
+	dup := *key
+	return &dup, nil
+}
+
+func (store *MemoryAPIKeyStore) APIKeysForAccount(accountPKey int64) ([]*APIKey, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	keys := make([]*APIKey, 0)
+	for _, key := range store.keys {
+		if key.AccountPKey == accountPKey {
+			dup := *key
+			keys = append(keys, &dup)
+		}
+	}
+	sort.Sort(apiKeysByCreated(keys))
+	return keys, nil
+}
+
+func (store *MemoryAPIKeyStore) APIKeyWithPublicKey(publicKey string) (*APIKey, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	key, exists := store.keys[publicKey]
+	if exists == false {
+		return nil, nil
+	}
+	dup := *key
+	return &dup, nil
+}
+
+func (store *MemoryAPIKeyStore) RevokeAPIKey(accountPKey int64, publicKey string) (bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	key, exists := store.keys[publicKey]
+	if exists == false || key.AccountPKey != accountPKey || key.IsRevoked() {
+		return false, nil
+	}
+	key.Revoked = time.Now().UTC()
+	return true, nil
+}
+
+func (store *MemoryAPIKeyStore) TouchAPIKey(publicKey string, now time.Time) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	key, exists := store.keys[publicKey]
+	if exists {
+		key.LastUsed = now
+	}
+	return nil
+}
+
+type apiKeysByCreated []*APIKey
+
+func (keys apiKeysByCreated) Len() int      { return len(keys) }
+func (keys apiKeysByCreated) Swap(i, j int) { keys[i], keys[j] = keys[j], keys[i] }
+func (keys apiKeysByCreated) Less(i, j int) bool {
+	if keys[i].Created.Equal(keys[j].Created) {
+		return keys[i].PublicKey < keys[j].PublicKey
+	}
+	return keys[i].Created.Before(keys[j].Created)
+}
@@ -0,0 +1,189 @@
+package grunway
+
+import (
+	"net/http"
+	"time"
+)
+
+// APIKeyController exposes admin endpoints for managing API keys.
+// Every route requires auth, which is delegated to Authenticator (typically an admin-only AuthHandler).
+//
+//	router.RegisterEntity("apikey", &grunway.APIKeyController{Keys: keys, Accounts: accounts, Authenticator: adminAuth})
+//
+// gives:
+//
+//	POST /v1/apikey/             {"AccountPKey": 12, "Name": "ci", "Expires": "..."}  create, response includes the SecretKey
+//	GET  /v1/apikey/<accountid>                                                    list keys for an account, w/o secrets
+//	POST /v1/apikey/revoke       {"AccountPKey": 12, "PublicKey": "..."}           revoke
+type APIKeyController struct {
+	Keys          APIKeyStore
+	Accounts      AccountStore // optional.  if set, account pkeys are checked for existence on create
+	Authenticator AuthHandler
+}
+
+// The SecretKey is only ever populated in the response to create.
+type APIKeyPayload struct {
+	PublicKey   string
+	SecretKey   string `json:",omitempty"`
+	AccountPKey int64
+	Name        string
+	Created     time.Time
+	LastUsed    time.Time
+	Expires     time.Time
+	Revoked     time.Time
+	Active      bool
+}
+
+func (payload APIKeyPayload) PayloadType() string {
+	return "apikey"
+}
+
+type APIKeyCreatePayload struct {
+	AccountPKey int64
+	Name        string
+	Expires     time.Time // optional
+}
+
+func (payload APIKeyCreatePayload) PayloadType() string {
+	return "apikeyCreate"
+}
+
+type APIKeyRevokePayload struct {
+	AccountPKey int64
+	PublicKey   string
+}
+
+func (payload APIKeyRevokePayload) PayloadType() string {
+	return "apikeyRevoke"
+}
+
+func MakeAPIKeyPayload(key *APIKey, includeSecret bool, now time.Time) APIKeyPayload {
+	payload := APIKeyPayload{
+		PublicKey:   key.PublicKey,
+		AccountPKey: key.AccountPKey,
+		Name:        key.Name,
+		Created:     key.Created,
+		LastUsed:    key.LastUsed,
+		Expires:     key.Expires,
+		Revoked:     key.Revoked,
+		Active:      key.IsActive(now),
+	}
+	if includeSecret {
+		payload.SecretKey = key.SecretKey
+	}
+	return payload
+}
+
+//    #
+//   # #   #    # ##### #    #
+//  #   #  #    #   #   #    #
+// #     # #    #   #   ######
+// ####### #    #   #   #    #
+// #     # #    #   #   #    #
+// #     #  ####    #   #    #
+//
+
+func (controller *APIKeyController) PerformAuth(routePtr *Route, ctx *Context) (bool, int) {
+	if controller.Authenticator == nil {
+		return false, 1182355901
+	}
+	return controller.Authenticator.PerformAuth(routePtr, ctx)
+}
+func (controller *APIKeyController) GetSecretKey(publicKey string) (string, int) {
+	if controller.Authenticator == nil {
+		return "", 1182355902
+	}
+	return controller.Authenticator.GetSecretKey(publicKey)
+}
+
+// #     #
+// #     #   ##   #    # #####  #      ###### #####   ####
+// #     #  #  #  ##   # #    # #      #      #    # #
+// ####### #    # # #  # #    # #      #####  #    #  ####
+// #     # ###### #  # # #    # #      #      #####       #
+// #     # #    # #   ## #    # #      #      #   #  #    #
+// #     # #    # #    # #####  ###### ###### #    #  ####
+//
+
+func (controller *APIKeyController) RouteDocs() map[string]HandlerDoc {
+	return map[string]HandlerDoc{
+		"AuthPostHandlerV1": {
+			Summary:   "Create an API key.  The response is the only time the SecretKey is returned",
+			Request:   APIKeyCreatePayload{},
+			Responses: []Payload{APIKeyPayload{}},
+			Errors: []HandlerErrorDoc{
+				{StatusCode: http.StatusBadRequest, ErrorNumber: 1182355910, Description: "cannot parse body"},
+				{StatusCode: http.StatusBadRequest, ErrorNumber: 1182355911, Description: "missing AccountPKey"},
+				{StatusCode: http.StatusNotFound, ErrorNumber: 1182355912, Description: "unknown account"},
+			},
+		},
+		"AuthGetHandlerV1": {Summary: "List API keys for an account", Responses: []Payload{APIKeyPayload{}}},
+		"AuthPostHandlerV1Revoke": {
+			Summary: "Revoke an API key",
+			Request: APIKeyRevokePayload{},
+			Errors: []HandlerErrorDoc{
+				{StatusCode: http.StatusBadRequest, ErrorNumber: 1182355920, Description: "cannot parse body"},
+				{StatusCode: http.StatusNotFound, ErrorNumber: 1182355921, Description: "unknown key"},
+			},
+		},
+	}
+}
+
+func (controller *APIKeyController) AuthPostHandlerV1(ctx *Context) RouteHandlerResult {
+	var payload APIKeyCreatePayload
+	if rhr, failed := decodeRequestBody(ctx, &payload, 1182355910); failed {
+		return rhr
+	}
+	if payload.AccountPKey <= 0 {
+		return ctx.MakeRouteHandlerResultError(http.StatusBadRequest, 1182355911, BadRequestPrefix+": Missing AccountPKey")
+	}
+	if controller.Accounts != nil {
+		ma, err := controller.Accounts.AccountWithId(payload.AccountPKey)
+		if err != nil {
+			return ctx.MakeRouteHandlerResultFromError(err)
+		}
+		if ma.IsNil() {
+			return ctx.MakeRouteHandlerResultNotFound(1182355912)
+		}
+	}
+
+	key, err := controller.Keys.CreateAPIKey(payload.AccountPKey, payload.Name, payload.Expires)
+	if err != nil {
+		return ctx.MakeRouteHandlerResultFromError(err)
+	}
+	return ctx.MakeRouteHandlerResultPayloads(MakeAPIKeyPayload(key, true, time.Now()))
+}
+
+// the primary key is the account pkey
+func (controller *APIKeyController) AuthGetHandlerV1(ctx *Context) RouteHandlerResult {
+	keys, err := controller.Keys.APIKeysForAccount(ctx.End.PrimaryKey)
+	if err != nil {
+		return ctx.MakeRouteHandlerResultFromError(err)
+	}
+
+	now := time.Now()
+	payloads := make([]Payload, 0, len(keys))
+	for _, key := range keys {
+		payloads = append(payloads, MakeAPIKeyPayload(key, false, now))
+	}
+	if len(payloads) == 0 {
+		return ctx.MakeRouteHandlerResultOk()
+	}
+	return ctx.MakeRouteHandlerResultPayloads(payloads...)
+}
+
+func (controller *APIKeyController) AuthPostHandlerV1Revoke(ctx *Context) RouteHandlerResult {
+	var payload APIKeyRevokePayload
+	if rhr, failed := decodeRequestBody(ctx, &payload, 1182355920); failed {
+		return rhr
+	}
+
+	revoked, err := controller.Keys.RevokeAPIKey(payload.AccountPKey, payload.PublicKey)
+	if err != nil {
+		return ctx.MakeRouteHandlerResultFromError(err)
+	}
+	if revoked == false {
+		return ctx.MakeRouteHandlerResultNotFound(1182355921)
+	}
+	return ctx.MakeRouteHandlerResultOk()
+}
@@ -0,0 +1,117 @@
+package grunway
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type WidgetController struct {
+	*APIKeyAuthenticator
+}
+
+func (wc *WidgetController) AuthPostHandlerV1(ctx *Context) RouteHandlerResult {
+	body, _ := ctx.RequestBody()
+	if string(body) != `{"Name":"sprocket"}` {
+		return ctx.MakeRouteHandlerResultError(http.StatusBadRequest, 1, "body not restored after auth")
+	}
+	if ctx.AccountPKey != 12 {
+		return ctx.MakeRouteHandlerResultError(http.StatusInternalServerError, 2, "AccountPKey not set")
+	}
+	return ctx.MakeRouteHandlerResultOk()
+}
+
+func TestAPIKeyRotation(t *testing.T) {
+	keys := NewMemoryAPIKeyStore()
+	auth := NewAPIKeyAuthenticator(keys, nil)
+
+	router := NewRouter()
+	router.BasePath = "/api/"
+	router.PostProcessors = nil
+	router.RegisterEntity("widget", &WidgetController{auth})
+
+	oldKey, _ := keys.CreateAPIKey(12, "old", time.Time{})
+	newKey, _ := keys.CreateAPIKey(12, "new", time.Time{})
+
+	post := func(key *APIKey, now time.Time) int {
+		body := []byte(`{"Name":"sprocket"}`)
+		req := httptest.NewRequest("POST", "/api/v1/widget/", bytes.NewReader(body))
+		SignRequest(req, key.PublicKey, key.SecretKey, body, now)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// both keys work during rotation
+	if code := post(oldKey, time.Now()); code != http.StatusOK {
+		t.Error("3960284411 old key should work, got", code)
+	}
+	if code := post(newKey, time.Now()); code != http.StatusOK {
+		t.Error("3960284412 new key should work, got", code)
+	}
+
+	if revoked, _ := keys.RevokeAPIKey(12, oldKey.PublicKey); revoked == false {
+		t.Fatal("3960284413 expected revoke to succeed")
+	}
+	if code := post(oldKey, time.Now()); code != http.StatusForbidden {
+		t.Error("3960284414 revoked key should fail, got", code)
+	}
+	if code := post(newKey, time.Now()); code != http.StatusOK {
+		t.Error("3960284415 new key should still work, got", code)
+	}
+
+	// stale signatures and wrong secrets fail
+	if code := post(newKey, time.Now().Add(-time.Hour)); code != http.StatusForbidden {
+		t.Error("3960284416 stale timestamp should fail, got", code)
+	}
+	forged := *newKey
+	forged.SecretKey = "not the secret"
+	if code := post(&forged, time.Now()); code != http.StatusForbidden {
+		t.Error("3960284417 bad signature should fail, got", code)
+	}
+
+	used, _ := keys.APIKeyWithPublicKey(newKey.PublicKey)
+	if used.LastUsed.IsZero() {
+		t.Error("3960284418 expected LastUsed to be recorded")
+	}
+
+	// forged requests don't count as use
+	unusedKey, _ := keys.CreateAPIKey(12, "unused", time.Time{})
+	forged = *unusedKey
+	forged.SecretKey = "not the secret"
+	post(&forged, time.Now())
+	if unused, _ := keys.APIKeyWithPublicKey(unusedKey.PublicKey); unused.LastUsed.IsZero() == false {
+		t.Error("3960284423 a bad signature should not update LastUsed")
+	}
+
+	// signed bodies are capped
+	auth.MaxBodyBytes = 8
+	if code := post(newKey, time.Now()); code != http.StatusForbidden {
+		t.Error("3960284424 oversized body should fail, got", code)
+	}
+	auth.MaxBodyBytes = DefaultSignatureMaxBodyBytes
+
+	list, _ := keys.APIKeysForAccount(12)
+	if len(list) != 3 || list[0].Name != "old" || list[0].IsActive(time.Now()) || list[1].IsActive(time.Now()) == false {
+		t.Errorf("3960284419 unexpected key list %+v", list)
+	}
+}
+
+func TestAPIKeyExpiry(t *testing.T) {
+	keys := NewMemoryAPIKeyStore()
+	auth := NewAPIKeyAuthenticator(keys, nil)
+	key, _ := keys.CreateAPIKey(12, "short lived", time.Now().Add(time.Minute))
+
+	if _, errNum := auth.GetSecretKey(key.PublicKey); errNum != 0 {
+		t.Error("3960284420 expected active key, got errNum", errNum)
+	}
+	auth.now = func() time.Time { return time.Now().Add(time.Hour) }
+	if _, errNum := auth.GetSecretKey(key.PublicKey); errNum != APIKeyExpiredErrorNumber {
+		t.Error("3960284421 expected expired key, got errNum", errNum)
+	}
+	if _, errNum := auth.GetSecretKey("bogus"); errNum != APIKeyUnknownErrorNumber {
+		t.Error("3960284422 expected unknown key, got errNum", errNum)
+	}
+}
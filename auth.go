@@ -1,6 +1,20 @@
 package grunway
 
+import (
+	"net/http"
+
+	"github.com/amattn/grunway/internal/testhooks"
+)
+
 type AuthHandler interface {
 	PerformAuth(routePtr *Route, ctx *Context) (authenticationWasSucessful bool, failureToAuthErrorNum int)
 	GetSecretKey(publicKey string) (secretKey string, errNum int)
 }
+
+// the route's Authenticator, unless grunwaytest replaced it for this request
+func routeAuthHandler(routePtr *Route, req *http.Request) AuthHandler {
+	if override, isAuthHandler := testhooks.AuthHandler(req).(AuthHandler); isAuthHandler {
+		return override
+	}
+	return routePtr.Authenticator
+}
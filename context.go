@@ -1,7 +1,6 @@
 package grunway
 
 import (
-	"encoding/json"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -24,12 +23,23 @@ type Context struct {
 	router *Router
 
 	// only populated after auth
-	PublicKey string // for Auth'd requests, will be set to public key if Auth was successful, "" otherwise
+	PublicKey   string // for Auth'd requests, will be set to public key if Auth was successful, "" otherwise
+	AccountPKey int64  // for Auth'd requests, set by AuthHandlers that can resolve the owning account (eg APIKeyAuthenticator), 0 otherwise
 
 	// generic maps for middleware to stuff arbitrary data
 	middleware map[string]interface{}
 	postware   map[string]interface{}
 
+	excludedFromAccessLog    bool                     // see ExcludeFromAccessLog
+	capturedResponse         *capturingResponseWriter // only set if a PostProcessor implements ResponseCapturer
+	capturedRequestTruncated bool                     // the request body was too big to capture, see CapturedBodiesTruncated
+	requestBodyCaptured      bool                     // the request got far enough to have its body captured
+	encoder                  Encoder                  // negotiated from the Accept header, see responseEncoder
+
+	// added to the response's PayloadWrapper, see SetResponseMeta and SetResponseLinks
+	responseMeta  map[string]interface{}
+	responseLinks *PayloadLinks
+
 	// only populated after a write
 
 	written       bool // true after a write, false before.  Used to prevent "double writes".
@@ -50,6 +60,11 @@ func (ctx *Context) SetHeader(key, value string) {
 	ctx.w.Header().Set(key, value)
 }
 
+// ExcludeFromAccessLog tells CommonLogger to skip this request, eg for noisy health checks.
+func (ctx *Context) ExcludeFromAccessLog() {
+	ctx.excludedFromAccessLog = true
+}
+
 func (ctx *Context) RequestBody() ([]byte, error) {
 	if ctx.cachedRequestBody != nil {
 		return ctx.cachedRequestBody, nil
@@ -100,6 +115,18 @@ func (ctx *Context) MakeRouteHandlerResultErrorInfo(code int, errInfo ErrorInfo)
 	return RouteHandlerResult{rerr, nil, nil}
 }
 
+// DeepErrors w/ a status code are passed through as is.  Anything else is treated as a 500.
+func (ctx *Context) MakeRouteHandlerResultFromError(err error) RouteHandlerResult {
+	derr, isDeepError := err.(*deeperror.DeepError)
+	if isDeepError == false || derr == nil {
+		return ctx.MakeRouteHandlerResultError(http.StatusInternalServerError, 2035519560, InternalServerErrorPrefix)
+	}
+	if derr.StatusCodeIsDefaultValue() {
+		return ctx.MakeRouteHandlerResultError(http.StatusInternalServerError, derr.Num, InternalServerErrorPrefix)
+	}
+	return ctx.MakeRouteHandlerResultError(derr.StatusCode, derr.Num, derr.EndUserMsg)
+}
+
 func (ctx *Context) MakeRouteHandlerResultAlert(code int, errNo int64, alert string) RouteHandlerResult {
 	return ctx.MakeRouteHandlerResultCustom(func(innerCtx *Context) {
 		sendErrorPayload(innerCtx, code, ErrorInfo{ErrorNumber: errNo}, alert)
@@ -108,35 +135,33 @@ func (ctx *Context) MakeRouteHandlerResultAlert(code int, errNo int64, alert str
 func (ctx *Context) MakeRouteHandlerResultPayloads(payloads ...Payload) RouteHandlerResult {
 	return RouteHandlerResult{nil, MakePayloadMapFromPayloads(payloads...), nil}
 }
+
+// MakeRouteHandlerResultPage is MakeRouteHandlerResultPayloads w/ Links and Meta, eg for one page of a list:
+//
+//	links := grunway.PayloadLinks{Self: ctx.LinkWithQuery("cursor", cursor), Next: ctx.LinkWithQuery("cursor", nextCursor)}
+//	return ctx.MakeRouteHandlerResultPage(links, map[string]interface{}{"total": total}, books...)
+func (ctx *Context) MakeRouteHandlerResultPage(links PayloadLinks, meta map[string]interface{}, payloads ...Payload) RouteHandlerResult {
+	ctx.SetResponseLinks(links)
+	for key, value := range meta {
+		ctx.SetResponseMeta(key, value)
+	}
+	return ctx.MakeRouteHandlerResultPayloads(payloads...)
+}
 func (ctx *Context) MakeRouteHandlerResultGenericJSON(v interface{}) RouteHandlerResult {
 	return ctx.MakeRouteHandlerResultStatusGenericJSON(http.StatusOK, v)
 }
+
+// Despite the name, v is encoded w/ the negotiated Encoder, so it is only JSON when the client asked for JSON.
+// If v can't be encoded that way, the client gets a 500.
 func (ctx *Context) MakeRouteHandlerResultStatusGenericJSON(statusCode int, v interface{}) RouteHandlerResult {
-	ctx.SetHeader(httpHeaderContentType, httpHeaderContentTypeJSON)
-	jsonBytes, err := json.Marshal(v)
-	if err != nil {
-		rerr := NewRouteError(
-			http.StatusInternalServerError,
-			ErrorInfo{
-				ErrorNumber:  3913952842,
-				ErrorMessage: "Internal Server Error",
-			})
-		return RouteHandlerResult{rerr, nil, nil}
-	} else {
-		return RouteHandlerResult{nil, nil, func(innerCtx *Context) {
-			if rw, isResponseWriter := innerCtx.w.(http.ResponseWriter); isResponseWriter {
-				rw.WriteHeader(statusCode)
-				if len(jsonBytes) == 0 {
-					log.Println("jsonBytes", jsonBytes, innerCtx.Req.URL)
-				}
-				bytesWritten, err := rw.Write(jsonBytes)
-				if err != nil {
-					log.Println("3952513088 WRITE ERROR", err)
-				}
-				innerCtx.ContentLength = bytesWritten
-			}
-		}}
-	}
+	return ctx.MakeRouteHandlerResultCustom(func(innerCtx *Context) {
+		if innerCtx.written == true {
+			log.Println(deeperror.New(3913952843, "ERROR attempt to write multiple times to same writer", nil))
+			return
+		}
+		innerCtx.written = true
+		writeEncoded(innerCtx, statusCode, v)
+	})
 }
 func (ctx *Context) MakeRouteHandlerResultCustom(crr CustomRouteResponse) RouteHandlerResult {
 	return RouteHandlerResult{nil, nil, crr}
@@ -207,25 +232,67 @@ func (ctx *Context) SendSimpleAlertPayload(code int, errNo int64, errMsg, alert
 	sendErrorPayload(ctx, code, ErrorInfo{ErrorNumber: errNo, ErrorMessage: errMsg}, alert)
 }
 
+// Despite the name, this decodes the request body, w/ the Decoder registered for its Content-Type.
 func (ctx *Context) DecodeResponseBodyOrSendError(pc PayloadController, payloadReference interface{}) interface{} {
-	requestBody := ctx.Req.Body
-	if requestBody == nil {
-		errMsg := BadRequestPrefix + ": Expected non-empty body"
-		ctx.SendSimpleErrorPayload(http.StatusBadRequest, 3003399819, errMsg)
+	rerr := ctx.decodeRequest(payloadReference, 3003399819, 3005488054)
+	if rerr != nil {
+		ctx.SendErrorInfoPayload(rerr.statusCode, rerr.errorInfo)
 		return nil
 	}
-	defer requestBody.Close()
+	return payloadReference
+}
 
-	decoder := json.NewDecoder(requestBody)
-	err := decoder.Decode(payloadReference)
+// #     #
+// ##   ## ###### #####   ##
+// # # # # #        #    #  #
+// #  #  # #####    #   #    #
+// #     # #        #   ######
+// #     # #        #   #    #
+// #     # ######   #   #    #
+//
 
-	if err != nil {
-		errMsg := BadRequestPrefix + ": Cannot parse body"
-		derr := deeperror.New(3005488054, errMsg, err)
-		log.Println("derr", derr)
-		ctx.SendSimpleErrorPayload(http.StatusBadRequest, derr.Num, errMsg)
-		return nil
+// SetResponseMeta adds key to the Meta section of whatever PayloadWrapper this request responds w/, errors included.
+// Handlers and middleware can both use it, eg for a request id.
+func (ctx *Context) SetResponseMeta(key string, value interface{}) {
+	if ctx.responseMeta == nil {
+		ctx.responseMeta = make(map[string]interface{})
 	}
+	ctx.responseMeta[key] = value
+}
 
-	return payloadReference
+// SetResponseLinks sets the Links section of whatever PayloadWrapper this request responds w/.
+func (ctx *Context) SetResponseLinks(links PayloadLinks) {
+	ctx.responseLinks = &links
+}
+
+// LinkWithQuery is the request's path and query w/ key set to value, or removed if value is "".
+// Handy for building pagination links.
+func (ctx *Context) LinkWithQuery(key, value string) string {
+	linkURL := *ctx.Req.URL
+	query := linkURL.Query()
+	if value == "" {
+		query.Del(key)
+	} else {
+		query.Set(key, value)
+	}
+	linkURL.RawQuery = query.Encode()
+	return linkURL.RequestURI()
+}
+
+// values already on the PayloadWrapper win
+func (ctx *Context) applyResponseSections(payloadWrapper *PayloadWrapper) {
+	if len(ctx.responseMeta) > 0 {
+		meta := make(map[string]interface{}, len(ctx.responseMeta)+len(payloadWrapper.Meta))
+		for key, value := range ctx.responseMeta {
+			meta[key] = value
+		}
+		for key, value := range payloadWrapper.Meta {
+			meta[key] = value
+		}
+		payloadWrapper.Meta = meta
+	}
+	if payloadWrapper.Links == nil && ctx.responseLinks != nil {
+		links := *ctx.responseLinks
+		payloadWrapper.Links = &links
+	}
 }
@@ -0,0 +1,206 @@
+package grunway
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	UnsupportedMediaTypePrefix      = "415 Unsupported Media Type"
+	UnsupportedMediaTypeErrorNumber = 4150000415
+
+	httpHeaderContentTypeForm = "application/x-www-form-urlencoded"
+)
+
+// Decoder is the request side of Encoder: it parses a request body of one media type.
+// Register them w/ router.RegisterDecoder; the request's Content-Type picks one.
+type Decoder interface {
+	MediaType() string // eg "application/json"
+	Decode(data []byte, v interface{}) error
+}
+
+// NewDecoder adapts a decode func, eg from a MessagePack or CBOR library:
+//
+//	router.RegisterDecoder(grunway.NewDecoder("application/msgpack", msgpack.Unmarshal))
+func NewDecoder(mediaType string, decode func(data []byte, v interface{}) error) Decoder {
+	return &funcDecoder{mediaType, decode}
+}
+
+type funcDecoder struct {
+	mediaType string
+	decode    func(data []byte, v interface{}) error
+}
+
+func (decoder *funcDecoder) MediaType() string {
+	return decoder.mediaType
+}
+func (decoder *funcDecoder) Decode(data []byte, v interface{}) error {
+	return decoder.decode(data, v)
+}
+
+type JSONDecoder struct{}
+
+func (decoder JSONDecoder) MediaType() string {
+	return httpHeaderContentTypeJSON
+}
+func (decoder JSONDecoder) Decode(data []byte, v interface{}) error {
+	return json.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// XMLDecoder is not registered by default.
+type XMLDecoder struct{}
+
+func (decoder XMLDecoder) MediaType() string {
+	return httpHeaderContentTypeXML
+}
+func (decoder XMLDecoder) Decode(data []byte, v interface{}) error {
+	return xml.Unmarshal(data, v)
+}
+
+// FormDecoder decodes application/x-www-form-urlencoded bodies into flat structs.  Keys match the field's
+// json name and values are converted to the field's type, so payloads need no extra tags.
+// Repeated keys fill slice fields.  Nested structs are not supported.
+//
+// It is not registered by default: browsers post forms cross-origin w/o a CORS preflight.
+type FormDecoder struct{}
+
+func (decoder FormDecoder) MediaType() string {
+	return httpHeaderContentTypeForm
+}
+func (decoder FormDecoder) Decode(data []byte, v interface{}) error {
+	values, err := url.ParseQuery(string(data))
+	if err != nil {
+		return err
+	}
+
+	fieldTypes := map[string]jsonField{}
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t != nil && t.Kind() == reflect.Struct {
+		for _, field := range structJSONFields(t) {
+			fieldTypes[strings.ToLower(field.Name)] = field
+		}
+	}
+
+	// build the equivalent JSON document and let encoding/json do the rest
+	doc := map[string]interface{}{}
+	for key, list := range values {
+		field, known := fieldTypes[strings.ToLower(key)]
+		if known == false {
+			doc[key] = list[len(list)-1]
+			continue
+		}
+		fieldType := field.Type
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if fieldType.Kind() == reflect.Slice && fieldType.Elem().Kind() != reflect.Uint8 {
+			converted := make([]interface{}, len(list))
+			for i, value := range list {
+				converted[i] = formValue(value, fieldType.Elem(), field.AsString)
+			}
+			doc[key] = converted
+			continue
+		}
+		doc[key] = formValue(list[len(list)-1], fieldType, field.AsString)
+	}
+
+	jsonBytes, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(jsonBytes, v)
+}
+
+// form values are strings.  numbers and bools become their JSON equivalents when they parse, otherwise the
+// string is left for encoding/json to reject.
+func formValue(value string, t reflect.Type, asString bool) interface{} {
+	if asString {
+		return value
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		if value == "on" {
+			return true
+		}
+		if parsed, err := strconv.ParseBool(value); err == nil {
+			return parsed
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		if _, err := strconv.ParseFloat(value, 64); err == nil {
+			return json.Number(value)
+		}
+	}
+	return value
+}
+
+// RegisterDecoder adds a decoder, or replaces the one w/ the same media type.
+// The first registered decoder is used for requests w/o a Content-Type.
+func (router *Router) RegisterDecoder(decoder Decoder) {
+	for i, existing := range router.Decoders {
+		if mediaTypeBase(existing.MediaType()) == mediaTypeBase(decoder.MediaType()) {
+			router.Decoders[i] = decoder
+			return
+		}
+	}
+	router.Decoders = append(router.Decoders, decoder)
+}
+
+// requestDecoder returns the decoder for contentType, nil if none is registered
+func (router *Router) requestDecoder(contentType string) Decoder {
+	decoders := router.Decoders
+	if len(decoders) == 0 {
+		decoders = []Decoder{JSONDecoder{}}
+	}
+	if strings.TrimSpace(contentType) == "" {
+		return decoders[0]
+	}
+	base := mediaTypeBase(contentType)
+	for _, decoder := range decoders {
+		if mediaTypeBase(decoder.MediaType()) == base {
+			return decoder
+		}
+	}
+	return nil
+}
+
+// decodeRequest is the single place request bodies are decoded, selected by Content-Type.
+// Returns nil on success, otherwise a 400 (w/ emptyErrNo or parseErrNo) or a 415.
+func (ctx *Context) decodeRequest(v interface{}, emptyErrNo, parseErrNo int64) *RouteError {
+	router := ctx.router
+	if router == nil {
+		router = new(Router)
+	}
+	decoder := router.requestDecoder(ctx.Req.Header.Get(httpHeaderContentType))
+	if decoder == nil {
+		return NewRouteError(http.StatusUnsupportedMediaType, ErrorInfo{ErrorNumber: UnsupportedMediaTypeErrorNumber, ErrorMessage: UnsupportedMediaTypePrefix})
+	}
+
+	if ctx.Req.Body == nil {
+		return NewRouteError(http.StatusBadRequest, ErrorInfo{ErrorNumber: emptyErrNo, ErrorMessage: BadRequestPrefix + ": Expected non-empty body"})
+	}
+	defer ctx.Req.Body.Close()
+	body, err := ctx.RequestBody()
+	if err != nil || len(bytes.TrimSpace(body)) == 0 {
+		return NewRouteError(http.StatusBadRequest, ErrorInfo{ErrorNumber: emptyErrNo, ErrorMessage: BadRequestPrefix + ": Expected non-empty body"})
+	}
+
+	err = decoder.Decode(body, v)
+	if err != nil {
+		return NewRouteError(http.StatusBadRequest, ErrorInfo{ErrorNumber: parseErrNo, ErrorMessage: BadRequestPrefix + ": Cannot parse body"})
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package grunway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/amattn/deeperror"
+)
+
+type gadgetPayload struct {
+	Name   string   `json:"name" xml:"name"`
+	Count  int      `json:"count" xml:"count"`
+	Active bool     `json:"active" xml:"active"`
+	Tags   []string `json:"tags" xml:"tag"`
+}
+
+func (payload gadgetPayload) PayloadType() string {
+	return "gadget"
+}
+
+type gadgetController struct{}
+
+func (controller *gadgetController) CreatePayloadIsValid(ctx *Context, createRequestPayload Payload) *deeperror.DeepError {
+	return nil
+}
+func (controller *gadgetController) PerformCreate(ctx *Context, createRequestPayload Payload) (Payload, *deeperror.DeepError) {
+	return *createRequestPayload.(*gadgetPayload), nil
+}
+func (controller *gadgetController) PostHandlerV1(ctx *Context) RouteHandlerResult {
+	return StandardCreateHandler(controller, ctx, new(gadgetPayload))
+}
+
+func TestRequestDecoders(t *testing.T) {
+	router := NewRouter()
+	router.BasePath = "/api/"
+	router.PostProcessors = nil
+	router.RegisterDecoder(FormDecoder{})
+	router.RegisterDecoder(XMLDecoder{})
+	router.RegisterEntity("gadget", &gadgetController{})
+
+	post := func(contentType, body string) (int, *PayloadWrapper) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "http://localhost/api/v1/gadget/", strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set(httpHeaderContentType, contentType)
+		}
+		router.ServeHTTP(w, req)
+		pw, err := UnmarshalPayloadWrapper(w.Body.Bytes(), gadgetPayload{})
+		if err != nil {
+			t.Fatal("1590364401", err, w.Body.String())
+		}
+		return w.Code, pw
+	}
+
+	expected := gadgetPayload{Name: "sprocket", Count: 3, Active: true, Tags: []string{"a", "b"}}
+	bodies := map[string]string{
+		"":                                `{"name":"sprocket","count":3,"active":true,"tags":["a","b"]}`,
+		"application/json; charset=utf-8": `{"name":"sprocket","count":3,"active":true,"tags":["a","b"]}`,
+		httpHeaderContentTypeForm:         "name=sprocket&count=3&active=on&tags=a&tags=b",
+		httpHeaderContentTypeXML:          "<gadget><name>sprocket</name><count>3</count><active>true</active><tag>a</tag><tag>b</tag></gadget>",
+	}
+	for contentType, body := range bodies {
+		code, pw := post(contentType, body)
+		if code != http.StatusOK || len(pw.Payloads["gadget"]) != 1 {
+			t.Error("1590364402 unexpected response for", contentType, code, pw)
+			continue
+		}
+		actual := *pw.Payloads["gadget"][0].(*gadgetPayload)
+		if actual.Name != expected.Name || actual.Count != expected.Count || actual.Active != expected.Active || strings.Join(actual.Tags, ",") != "a,b" {
+			t.Error("1590364403 unexpected gadget for", contentType, actual)
+		}
+	}
+
+	if code, pw := post("text/csv", "name,count"); code != http.StatusUnsupportedMediaType || pw.ErrorNumber != UnsupportedMediaTypeErrorNumber {
+		t.Error("1590364404 expected 415", code, pw.ErrorNumber)
+	}
+	if code, pw := post(httpHeaderContentTypeForm, "name=sprocket&count=lots"); code != http.StatusBadRequest || pw.ErrorNumber != 3540227685 {
+		t.Error("1590364405 expected a parse error", code, pw.ErrorNumber)
+	}
+	if code, pw := post("", ""); code != http.StatusBadRequest || pw.ErrorNumber != 3370318075 {
+		t.Error("1590364406 expected an empty body error", code, pw.ErrorNumber)
+	}
+}
@@ -0,0 +1,361 @@
+package grunway
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/amattn/deeperror"
+)
+
+const (
+	NotAcceptablePrefix      = "406 Not Acceptable"
+	NotAcceptableErrorNumber = 4060000406
+
+	httpHeaderAccept         = "Accept"
+	httpHeaderContentTypeXML = "application/xml"
+)
+
+// Encoder turns a response (usually a *PayloadWrapper) into bytes for one media type.
+// Register them w/ router.RegisterEncoder; the request's Accept header picks one.
+type Encoder interface {
+	MediaType() string // eg "application/json", sent as the Content-Type
+	Encode(v interface{}) ([]byte, error)
+}
+
+// NewEncoder adapts an encode func, eg from a MessagePack or CBOR library:
+//
+//	router.RegisterEncoder(grunway.NewEncoder("application/msgpack", msgpack.Marshal))
+func NewEncoder(mediaType string, encode func(v interface{}) ([]byte, error)) Encoder {
+	return &funcEncoder{mediaType, encode}
+}
+
+type funcEncoder struct {
+	mediaType string
+	encode    func(v interface{}) ([]byte, error)
+}
+
+func (encoder *funcEncoder) MediaType() string {
+	return encoder.mediaType
+}
+func (encoder *funcEncoder) Encode(v interface{}) ([]byte, error) {
+	return encoder.encode(v)
+}
+
+//       #  #####  ####### #     #
+//       # #     # #     # ##    #
+//       # #       #     # # #   #
+//       #  #####  #     # #  #  #
+// #     #       # #     # #   # #
+// #     # #     # #     # #    ##
+//  #####   #####  ####### #     #
+//
+
+type JSONEncoder struct{}
+
+func (encoder JSONEncoder) MediaType() string {
+	return httpHeaderContentTypeJSON
+}
+func (encoder JSONEncoder) Encode(v interface{}) ([]byte, error) {
+	if pw, isPayloadWrapper := v.(*PayloadWrapper); isPayloadWrapper {
+		return MarshallPayloadWrapper(pw)
+	}
+	return json.Marshal(v)
+}
+
+// #     # #     # #
+//  #   #  ##   ## #
+//   # #   # # # # #
+//    #    #  #  # #
+//   # #   #     # #
+//  #   #  #     # #
+// #     # #     # #######
+//
+
+// XMLEncoder is not registered by default.  encoding/xml can't encode maps, so a PayloadWrapper is
+// written as <PayloadWrapper><Payloads><List type="book"><Payload>...</Payload></List></Payloads>...
+// Payload structs with map fields can't be encoded and produce a 500.
+type XMLEncoder struct{}
+
+type xmlPayloadWrapper struct {
+	XMLName      xml.Name         `xml:"PayloadWrapper"`
+	Payloads     []xmlPayloadList `xml:"Payloads>List,omitempty"`
+	ErrorNumber  int64            `xml:",omitempty"`
+	ErrorMessage string           `xml:",omitempty"`
+	DebugNumber  int64            `xml:",omitempty"`
+	DebugMessage string           `xml:",omitempty"`
+	Alert        string           `xml:",omitempty"`
+	Meta         []xmlMetaEntry   `xml:"Meta>Entry,omitempty"`
+	Links        *PayloadLinks    `xml:",omitempty"`
+}
+
+// non-string values are written as JSON
+type xmlMetaEntry struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type xmlPayloadList struct {
+	Type     string    `xml:"type,attr"`
+	Payloads []Payload `xml:"Payload"`
+}
+
+func (encoder XMLEncoder) MediaType() string {
+	return httpHeaderContentTypeXML
+}
+func (encoder XMLEncoder) Encode(v interface{}) ([]byte, error) {
+	encoded, err := xml.Marshal(xmlEncodable(v))
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), encoded...), nil
+}
+
+// PayloadWrappers are converted to xmlPayloadWrapper, anything else is encoded as is
+func xmlEncodable(v interface{}) interface{} {
+	if pw, isPayloadWrapper := v.(*PayloadWrapper); isPayloadWrapper {
+		xpw := xmlPayloadWrapper{
+			ErrorNumber:  pw.ErrorNumber,
+			ErrorMessage: pw.ErrorMessage,
+			DebugNumber:  pw.DebugNumber,
+			DebugMessage: pw.DebugMessage,
+			Alert:        pw.Alert,
+			Links:        pw.Links,
+		}
+		metaKeys := make([]string, 0, len(pw.Meta))
+		for key := range pw.Meta {
+			metaKeys = append(metaKeys, key)
+		}
+		sort.Strings(metaKeys)
+		for _, key := range metaKeys {
+			value, isString := pw.Meta[key].(string)
+			if isString == false {
+				encoded, _ := json.Marshal(pw.Meta[key])
+				value = string(encoded)
+			}
+			xpw.Meta = append(xpw.Meta, xmlMetaEntry{key, value})
+		}
+		payloadTypes := make([]string, 0, len(pw.Payloads))
+		for payloadType := range pw.Payloads {
+			payloadTypes = append(payloadTypes, payloadType)
+		}
+		sort.Strings(payloadTypes)
+		for _, payloadType := range payloadTypes {
+			xpw.Payloads = append(xpw.Payloads, xmlPayloadList{payloadType, pw.Payloads[payloadType]})
+		}
+		return xpw
+	}
+	return v
+}
+
+// #     #
+// ##    # ######  ####   ####  ##### #   ##   ##### #  ####  #    #
+// # #   # #      #    # #    #   #   #  #  #    #   # #    # ##   #
+// #  #  # #####  #      #    #   #   # #    #   #   # #    # # #  #
+// #   # # #      #  ### #    #   #   # ######   #   # #    # #  # #
+// #    ## #      #    # #    #   #   # #    #   #   # #    # #   ##
+// #     # ######  ####   ####    #   # #    #   #   #  ####  #    #
+//
+
+// RegisterEncoder adds an encoder, or replaces the one w/ the same media type.
+// Registration order is the server's preference when the client accepts several equally, eg */*.
+func (router *Router) RegisterEncoder(encoder Encoder) {
+	for i, existing := range router.Encoders {
+		if mediaTypeBase(existing.MediaType()) == mediaTypeBase(encoder.MediaType()) {
+			router.Encoders[i] = encoder
+			return
+		}
+	}
+	router.Encoders = append(router.Encoders, encoder)
+}
+
+// SetDefaultEncoder registers encoder first, making it the default: used when there's no Accept header,
+// or when the client accepts anything, eg */*.
+func (router *Router) SetDefaultEncoder(encoder Encoder) {
+	encoders := []Encoder{encoder}
+	for _, existing := range router.Encoders {
+		if mediaTypeBase(existing.MediaType()) != mediaTypeBase(encoder.MediaType()) {
+			encoders = append(encoders, existing)
+		}
+	}
+	router.Encoders = encoders
+}
+
+func (router *Router) defaultEncoder() Encoder {
+	if len(router.Encoders) == 0 {
+		return JSONEncoder{}
+	}
+	return router.Encoders[0]
+}
+
+// negotiateEncoder picks the encoder w/ the highest q value in accept.  nil if nothing is acceptable.
+// A missing Accept header means anything is acceptable.
+func (router *Router) negotiateEncoder(accept string) Encoder {
+	if strings.TrimSpace(accept) == "" {
+		return router.defaultEncoder()
+	}
+	ranges := parseAccept(accept)
+
+	encoders := router.Encoders
+	if len(encoders) == 0 {
+		encoders = []Encoder{JSONEncoder{}}
+	}
+	var best Encoder
+	bestQuality := 0.0
+	for i, encoder := range encoders {
+		quality, exact := acceptQuality(ranges, mediaTypeBase(encoder.MediaType()))
+		if _, namedOnly := encoder.(namedOnlyEncoder); namedOnly && exact == false && i > 0 {
+			continue
+		}
+		if quality > bestQuality {
+			best = encoder
+			bestQuality = quality
+		}
+	}
+	return best
+}
+
+// encoders that are only picked when the client names their media type, never for */*.  Unless they're the default.
+type namedOnlyEncoder interface {
+	namedOnly()
+}
+
+// encoders that need to know about the response they're encoding, eg JSONAPIEncoder.
+// forResponse returns the encoder to use for this one response.
+type contextualEncoder interface {
+	forResponse(ctx *Context, code int) Encoder
+}
+
+type acceptRange struct {
+	mediaType string // lowercased, w/o params, eg "application/*"
+	quality   float64
+}
+
+func parseAccept(accept string) []acceptRange {
+	ranges := []acceptRange{}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if qString, exists := params["q"]; exists {
+			quality, err = strconv.ParseFloat(qString, 64)
+			if err != nil {
+				continue
+			}
+		}
+		ranges = append(ranges, acceptRange{mediaType, quality})
+	}
+	return ranges
+}
+
+// the quality of the most specific range that matches mediaType, 0 if none do.
+// exact is true if that range names mediaType, ie isn't a wildcard.
+func acceptQuality(ranges []acceptRange, mediaType string) (quality float64, exact bool) {
+	mainType := strings.SplitN(mediaType, "/", 2)[0]
+	specificity := 0
+	for _, r := range ranges {
+		matchSpecificity := 0
+		switch {
+		case r.mediaType == mediaType:
+			matchSpecificity = 3
+		case r.mediaType == mainType+"/*":
+			matchSpecificity = 2
+		case r.mediaType == "*/*":
+			matchSpecificity = 1
+		}
+		if matchSpecificity > specificity {
+			specificity = matchSpecificity
+			quality = r.quality
+		}
+	}
+	return quality, specificity == 3
+}
+
+func mediaTypeBase(mediaType string) string {
+	base, _, err := mime.ParseMediaType(mediaType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(mediaType))
+	}
+	return base
+}
+
+// the encoder negotiated in ServeHTTP, or the router default for contexts created elsewhere
+func (ctx *Context) responseEncoder() Encoder {
+	if ctx.encoder != nil {
+		return ctx.encoder
+	}
+	if ctx.router != nil {
+		return ctx.router.defaultEncoder()
+	}
+	return JSONEncoder{}
+}
+
+// ResponseMediaType is the media type responses to this request are encoded as, eg "application/json"
+func (ctx *Context) ResponseMediaType() string {
+	return ctx.responseEncoder().MediaType()
+}
+
+// writeEncoded is the single place response bodies are encoded and written.
+// If v can't be encoded, a 500 error PayloadWrapper is written instead.
+func writeEncoded(ctx *Context, code int, v interface{}) {
+	encoder := ctx.responseEncoder()
+	if _, isPayloadWrapper := v.(*PayloadWrapper); isPayloadWrapper && ctx.wantsProblemDetails(code) {
+		encoder = ProblemEncoder{}
+	}
+	if contextual, isContextual := encoder.(contextualEncoder); isContextual {
+		encoder = contextual.forResponse(ctx, code)
+	}
+	if streamEncoder, canStream := encoder.(StreamEncoder); canStream {
+		writeStreamEncoded(ctx, code, streamEncoder, v)
+		return
+	}
+
+	encoded, err := encoder.Encode(v)
+	if err != nil {
+		writeEncodingError(ctx, encoder, err)
+		return
+	}
+	writeEncodedBytes(ctx, code, encoder.MediaType(), encoded)
+}
+
+// only safe to call before anything has been written
+func writeEncodingError(ctx *Context, encoder Encoder, err error) {
+	derr := deeperror.NewHTTPError(3589720731, "Fatal Internal Output Error", err, http.StatusInternalServerError)
+	log.Println(derr)
+	ctx.AddHeader("X-ErrorNum", fmt.Sprintf("%d", derr.Num))
+	ctx.AddHeader("X-ErrorStr", fmt.Sprintf("%s", derr.EndUserMsg))
+	errorWrapper := NewPayloadWrapper()
+	errorWrapper.ErrorNumber = derr.Num
+	errorWrapper.ErrorMessage = derr.EndUserMsg
+	if ctx.wantsProblemDetails(derr.StatusCode) {
+		encoder = ProblemEncoder{}
+	}
+	if contextual, isContextual := encoder.(contextualEncoder); isContextual {
+		encoder = contextual.forResponse(ctx, derr.StatusCode)
+	}
+	encoded, err := encoder.Encode(errorWrapper)
+	if err != nil {
+		encoder = JSONEncoder{}
+		encoded, _ = encoder.Encode(errorWrapper)
+	}
+	writeEncodedBytes(ctx, derr.StatusCode, encoder.MediaType(), encoded)
+}
+
+func writeEncodedBytes(ctx *Context, code int, mediaType string, encoded []byte) {
+	ctx.SetHeader(httpHeaderContentType, mediaType)
+	ctx.StatusCode = code
+	ctx.w.WriteHeader(code)
+	bytesWritten, err := ctx.w.Write(encoded)
+	if err != nil {
+		log.Println("3952513088 WRITE ERROR", err)
+	}
+	ctx.ContentLength = bytesWritten
+}
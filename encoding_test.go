@@ -0,0 +1,114 @@
+package grunway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateEncoder(t *testing.T) {
+	router := NewRouter()
+	router.RegisterEncoder(XMLEncoder{})
+	msgpack := NewEncoder("application/msgpack", func(v interface{}) ([]byte, error) { return []byte{0x80}, nil })
+	router.RegisterEncoder(msgpack)
+
+	tests := map[string]string{
+		"":                httpHeaderContentTypeJSON,
+		"*/*":             httpHeaderContentTypeJSON,
+		"application/xml": httpHeaderContentTypeXML,
+		"text/html, application/xml;q=0.9, */*;q=0.8": httpHeaderContentTypeXML,
+		"application/*;q=0.5, application/msgpack":    "application/msgpack",
+		"*/*, application/json;q=0":                   httpHeaderContentTypeXML,
+		"APPLICATION/JSON":                            httpHeaderContentTypeJSON,
+		"text/plain":                                  "",
+		"application/json;q=0":                        "",
+	}
+	for accept, expected := range tests {
+		encoder := router.negotiateEncoder(accept)
+		actual := ""
+		if encoder != nil {
+			actual = encoder.MediaType()
+		}
+		if actual != expected {
+			t.Errorf("1187349201 Accept %q expected %q, got %q", accept, expected, actual)
+		}
+	}
+}
+
+func TestEncodedResponses(t *testing.T) {
+	router := makeLibrary(t)
+	router.PostProcessors = nil
+	router.RegisterEncoder(XMLEncoder{})
+
+	get := func(path, accept string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://localhost/api/"+path, nil)
+		req.Header.Set("Accept", accept)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("v1/book/1", "application/xml")
+	if w.Code != http.StatusOK || w.Header().Get(httpHeaderContentType) != httpHeaderContentTypeXML || w.Header().Get("Vary") != "Accept" {
+		t.Fatal("1187349202 unexpected xml response", w.Code, w.Header())
+	}
+	if strings.Contains(w.Body.String(), `<List type="book"><Payload><PKey>1</PKey>`) == false {
+		t.Error("1187349203 unexpected xml body", w.Body.String())
+	}
+
+	// errors use the negotiated encoder too
+	w = get("v1/nothing/1", "application/xml")
+	if w.Code != http.StatusNotFound || strings.Contains(w.Body.String(), "<ErrorNumber>4040000404</ErrorNumber>") == false {
+		t.Error("1187349204 unexpected xml error", w.Code, w.Body.String())
+	}
+
+	w = get("v1/book/1", "text/csv")
+	if w.Code != http.StatusNotAcceptable || w.Header().Get(httpHeaderContentType) != httpHeaderContentTypeJSON || w.Header().Get("Grunway-ErrorNumber") != "4060000406" {
+		t.Error("1187349205 expected 406 in the default encoding", w.Code, w.Header(), w.Body.String())
+	}
+}
+
+type genericController struct{}
+
+type genericResponse struct {
+	Name string
+}
+
+func (controller *genericController) GetHandlerV1Generic(ctx *Context) RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultStatusGenericJSON(http.StatusAccepted, genericResponse{"plain"})
+}
+
+func (controller *genericController) GetHandlerV1Map(ctx *Context) RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultGenericJSON(map[string]string{"encoding/xml": "can't do maps"})
+}
+
+func TestGenericJSONUsesEncoder(t *testing.T) {
+	router := NewRouter()
+	router.BasePath = "/api/"
+	router.PostProcessors = nil
+	router.RegisterEncoder(XMLEncoder{})
+	router.RegisterEntity("thing", &genericController{})
+
+	for accept, expected := range map[string]string{
+		"application/xml":  `<?xml version="1.0" encoding="UTF-8"?>` + "\n<genericResponse><Name>plain</Name></genericResponse>",
+		"application/json": `{"Name":"plain"}`,
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://localhost/api/v1/thing/generic", nil)
+		req.Header.Set("Accept", accept)
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusAccepted || w.Header().Get(httpHeaderContentType) != accept || w.Body.String() != expected {
+			t.Error("1187349206 unexpected generic response", accept, w.Code, w.Header(), w.Body.String())
+		}
+	}
+
+	// encoding failures are the same 500 every other response gets
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://localhost/api/v1/thing/map", nil)
+	req.Header.Set("Accept", "application/xml")
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusInternalServerError || w.Header().Get("X-ErrorNum") != "3589720731" || w.Header().Get(httpHeaderContentType) != "application/xml" {
+		t.Error("1187349207 unexpected encoding failure", w.Code, w.Header(), w.Body.String())
+	}
+}
@@ -0,0 +1,518 @@
+// Package grunwayclient is a client for APIs served by a grunway.Router.
+//
+//	client := grunwayclient.New("https://api.example.com/api", BookPayload{}, AuthorPayload{})
+//	client.PublicKey, client.SecretKey = publicKey, secretKey // optional, signs every request
+//
+//	resp, err := client.Entity(1, "book").Key(12).Action("popular").Get(ctx)
+//	if apiErr, ok := err.(*grunwayclient.Error); ok {
+//		log.Println(apiErr.StatusCode, apiErr.ErrorNumber, apiErr.ErrorMessage)
+//	}
+//	var books []BookPayload
+//	err = resp.DecodePayloads("book", &books)
+package grunwayclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amattn/grunway"
+)
+
+const (
+	headerErrorNumber  = "Grunway-ErrorNumber"
+	headerErrorMessage = "Grunway-ErrorMessage"
+	headerDebugNumber  = "Grunway-DebugNumber"
+	headerDebugMessage = "Grunway-DebugMessage"
+	headerAlert        = "Grunway-Alert"
+	contentTypeJSON    = "application/json"
+)
+
+// RetryPolicy applies to idempotent methods only (GET, HEAD, PUT, DELETE, OPTIONS).
+// Network errors, 429, 502, 503 and 504 are retried.  A Retry-After header is honored, up to MaxDelay.
+type RetryPolicy struct {
+	MaxAttempts int           // including the first.  0 or 1 means no retries
+	BaseDelay   time.Duration // doubled after every attempt
+	MaxDelay    time.Duration
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   100 * time.Millisecond,
+		MaxDelay:    5 * time.Second,
+	}
+}
+
+type Client struct {
+	BaseURL    string // scheme, host and the router's BasePath, eg "https://api.example.com/api"
+	HTTPClient *http.Client
+
+	// optional.  if both are set, every request is signed w/ grunway.SignRequest
+	PublicKey string
+	SecretKey string
+
+	Retry     RetryPolicy
+	UserAgent string
+
+	mu           sync.RWMutex
+	payloadTypes map[string]reflect.Type // key is PayloadType()
+
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// New returns a client w/ DefaultRetryPolicy.  payloadTypes are registered for decoding, see RegisterPayloads.
+func New(baseURL string, payloadTypes ...grunway.Payload) *Client {
+	client := new(Client)
+	client.BaseURL = strings.TrimRight(baseURL, "/")
+	client.HTTPClient = http.DefaultClient
+	client.Retry = DefaultRetryPolicy()
+	client.UserAgent = "grunwayclient/" + grunway.Version()
+	client.payloadTypes = make(map[string]reflect.Type)
+	client.now = time.Now
+	client.sleep = sleepContext
+	client.RegisterPayloads(payloadTypes...)
+	return client
+}
+
+// RegisterPayloads tells the client which Go type to decode each PayloadType into.
+// Values or pointers are fine, either way decoded payloads are pointers (eg *BookPayload).
+func (client *Client) RegisterPayloads(payloadTypes ...grunway.Payload) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	for _, payload := range payloadTypes {
+		payloadType := reflect.TypeOf(payload)
+		for payloadType.Kind() == reflect.Ptr {
+			payloadType = payloadType.Elem()
+		}
+		client.payloadTypes[payload.PayloadType()] = payloadType
+	}
+}
+
+// #     #
+// #     # #####  #
+// #     # #    # #
+// #     # #    # #
+// #     # #####  #
+// #     # #   #  #
+//  #####  #    # ######
+//
+
+// Request builds /v<version>/<entity>[/<key>][/<action>][/<extras>...]
+type Request struct {
+	client  *Client
+	version int
+	entity  string
+	key     int64
+	hasKey  bool
+	action  string
+	extras  []string
+	query   url.Values
+	headers http.Header
+}
+
+func (client *Client) Entity(version int, entity string) *Request {
+	req := new(Request)
+	req.client = client
+	req.version = version
+	req.entity = entity
+	req.query = url.Values{}
+	req.headers = http.Header{}
+	return req
+}
+
+func (req *Request) Key(pkey int64) *Request {
+	req.key = pkey
+	req.hasKey = true
+	return req
+}
+
+func (req *Request) Action(action string) *Request {
+	req.action = action
+	return req
+}
+
+// Extra appends raw path components after the action.
+func (req *Request) Extra(components ...string) *Request {
+	req.extras = append(req.extras, components...)
+	return req
+}
+
+func (req *Request) Query(key, value string) *Request {
+	req.query.Add(key, value)
+	return req
+}
+
+func (req *Request) Header(key, value string) *Request {
+	req.headers.Add(key, value)
+	return req
+}
+
+// Path is relative to BaseURL, eg /v1/book/12/popular
+func (req *Request) Path() string {
+	components := []string{"v" + strconv.Itoa(req.version), url.PathEscape(req.entity)}
+	if req.hasKey {
+		components = append(components, strconv.FormatInt(req.key, 10))
+	}
+	if req.action != "" {
+		components = append(components, url.PathEscape(req.action))
+	}
+	for _, extra := range req.extras {
+		components = append(components, url.PathEscape(extra))
+	}
+	path := "/" + strings.Join(components, "/")
+	if req.hasKey == false && req.action == "" && len(req.extras) == 0 {
+		// grunway entity routes end w/ a slash, eg POST /v1/book/
+		path += "/"
+	}
+	return path
+}
+
+func (req *Request) URL() string {
+	u := req.client.BaseURL + req.Path()
+	if len(req.query) > 0 {
+		u += "?" + req.query.Encode()
+	}
+	return u
+}
+
+func (req *Request) Get(ctx context.Context) (*Response, error) {
+	return req.Do(ctx, http.MethodGet, nil)
+}
+func (req *Request) Delete(ctx context.Context) (*Response, error) {
+	return req.Do(ctx, http.MethodDelete, nil)
+}
+func (req *Request) Post(ctx context.Context, body interface{}) (*Response, error) {
+	return req.Do(ctx, http.MethodPost, body)
+}
+func (req *Request) Put(ctx context.Context, body interface{}) (*Response, error) {
+	return req.Do(ctx, http.MethodPut, body)
+}
+func (req *Request) Patch(ctx context.Context, body interface{}) (*Response, error) {
+	return req.Do(ctx, http.MethodPatch, body)
+}
+
+// Do sends the request.  body is encoded as JSON unless it is nil, a []byte or an io.Reader.
+//
+// Responses w/ a status >= 400 return both the decoded *Response and an *Error.
+func (req *Request) Do(ctx context.Context, method string, body interface{}) (*Response, error) {
+	bodyBytes, err := encodeBody(body)
+	if err != nil {
+		return nil, err
+	}
+
+	client := req.client
+	attempts := 1
+	if isIdempotent(method) && client.Retry.MaxAttempts > 1 {
+		attempts = client.Retry.MaxAttempts
+	}
+
+	var resp *Response
+	for attempt := 1; ; attempt++ {
+		var retryAfter time.Duration
+		resp, retryAfter, err = req.doOnce(ctx, method, bodyBytes)
+		if attempt >= attempts || shouldRetry(resp, err) == false || ctx.Err() != nil {
+			break
+		}
+
+		delay := client.Retry.backoff(attempt)
+		if retryAfter > delay {
+			delay = retryAfter
+		}
+		if client.Retry.MaxDelay > 0 && delay > client.Retry.MaxDelay {
+			delay = client.Retry.MaxDelay
+		}
+		if sleepErr := client.sleep(ctx, delay); sleepErr != nil {
+			return resp, err
+		}
+	}
+	return resp, err
+}
+
+func (req *Request) doOnce(ctx context.Context, method string, bodyBytes []byte) (*Response, time.Duration, error) {
+	client := req.client
+
+	var bodyReader io.Reader
+	if bodyBytes != nil {
+		bodyReader = bytes.NewReader(bodyBytes)
+	}
+	httpReq, err := http.NewRequest(method, req.URL(), bodyReader)
+	if err != nil {
+		return nil, 0, fmt.Errorf("2473660101 cannot build request: %v", err)
+	}
+	httpReq = httpReq.WithContext(ctx)
+	for key, values := range req.headers {
+		for _, value := range values {
+			httpReq.Header.Add(key, value)
+		}
+	}
+	httpReq.Header.Set("Accept", contentTypeJSON)
+	if bodyBytes != nil && httpReq.Header.Get("Content-Type") == "" {
+		httpReq.Header.Set("Content-Type", contentTypeJSON)
+	}
+	if client.UserAgent != "" {
+		httpReq.Header.Set("User-Agent", client.UserAgent)
+	}
+	if client.PublicKey != "" && client.SecretKey != "" {
+		// re-signed every attempt, the timestamp has to be fresh
+		grunway.SignRequest(httpReq, client.PublicKey, client.SecretKey, bodyBytes, client.now())
+	}
+
+	httpResp, err := client.HTTPClient.Do(httpReq)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer httpResp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("2473660102 cannot read response body: %v", err)
+	}
+
+	resp, err := client.decodeResponse(httpResp, respBody)
+	retryAfter := parseRetryAfter(httpResp.Header.Get("Retry-After"), client.now())
+	return resp, retryAfter, err
+}
+
+func encodeBody(body interface{}) ([]byte, error) {
+	switch typed := body.(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		return typed, nil
+	case io.Reader:
+		bodyBytes, err := ioutil.ReadAll(typed)
+		if err != nil {
+			return nil, fmt.Errorf("2473660103 cannot read request body: %v", err)
+		}
+		return bodyBytes, nil
+	default:
+		bodyBytes, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("2473660104 cannot encode request body: %v", err)
+		}
+		return bodyBytes, nil
+	}
+}
+
+// ######
+// #     # ######  ####  #####   ####  #    #  ####  ######
+// #     # #      #      #    # #    # ##   # #      #
+// ######  #####   ####  #    # #    # # #  #  ####  #####
+// #   #   #           # #####  #    # #  # #      # #
+// #    #  #      #    # #      #    # #   ## #    # #
+// #     # ######  ####  #       ####  #    #  ####  ######
+//
+
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+
+	grunway.ErrorInfo
+	Alert string
+	Meta  map[string]json.RawMessage // decode values w/ json.Unmarshal
+	Links grunway.PayloadLinks
+
+	// decoded w/ the registered types, key is PayloadType().  Unregistered types are only in RawPayloads.
+	Payloads    grunway.PayloadsMap
+	RawPayloads map[string][]json.RawMessage
+}
+
+// DecodePayloads decodes every payload of payloadType into slicePtr, eg *[]BookPayload.  No registration needed.
+func (resp *Response) DecodePayloads(payloadType string, slicePtr interface{}) error {
+	raws := resp.RawPayloads[payloadType]
+	rawList, err := json.Marshal(raws)
+	if err != nil {
+		return fmt.Errorf("2473660110 %v", err)
+	}
+	err = json.Unmarshal(rawList, slicePtr)
+	if err != nil {
+		return fmt.Errorf("2473660111 cannot decode %s payloads: %v", payloadType, err)
+	}
+	return nil
+}
+
+// First returns the first decoded payload of payloadType, or nil.
+func (resp *Response) First(payloadType string) grunway.Payload {
+	payloads := resp.Payloads[payloadType]
+	if len(payloads) == 0 {
+		return nil
+	}
+	return payloads[0]
+}
+
+// Error is returned for responses w/ a status >= 400.
+type Error struct {
+	StatusCode int
+	grunway.ErrorInfo
+	Alert string
+}
+
+func (err *Error) Error() string {
+	msg := err.ErrorMessage
+	if msg == "" {
+		msg = http.StatusText(err.StatusCode)
+	}
+	return fmt.Sprintf("grunway: %d %s (errorNumber %d)", err.StatusCode, msg, err.ErrorNumber)
+}
+
+type envelope struct {
+	Payloads     map[string][]json.RawMessage
+	ErrorNumber  int64
+	ErrorMessage string
+	DebugNumber  int64
+	DebugMessage string
+	Alert        string
+	Meta         map[string]json.RawMessage
+	Links        *grunway.PayloadLinks
+}
+
+func (client *Client) decodeResponse(httpResp *http.Response, body []byte) (*Response, error) {
+	resp := new(Response)
+	resp.StatusCode = httpResp.StatusCode
+	resp.Header = httpResp.Header
+	resp.Body = body
+	resp.Payloads = make(grunway.PayloadsMap)
+	resp.RawPayloads = make(map[string][]json.RawMessage)
+
+	var env envelope
+	var decodeErr error
+	if len(bytes.TrimSpace(body)) > 0 {
+		decodeErr = json.Unmarshal(body, &env)
+	}
+
+	// the envelope is authoritative, the headers are a fallback for proxies that mangle bodies
+	resp.ErrorInfo = grunway.ErrorInfo{
+		ErrorNumber:  env.ErrorNumber,
+		ErrorMessage: env.ErrorMessage,
+		DebugNumber:  env.DebugNumber,
+		DebugMessage: env.DebugMessage,
+	}
+	resp.Alert = env.Alert
+	resp.Meta = env.Meta
+	if env.Links != nil {
+		resp.Links = *env.Links
+	}
+	if resp.ErrorNumber == 0 {
+		resp.ErrorNumber, _ = strconv.ParseInt(httpResp.Header.Get(headerErrorNumber), 10, 64)
+	}
+	if resp.ErrorMessage == "" {
+		resp.ErrorMessage = httpResp.Header.Get(headerErrorMessage)
+	}
+	if resp.DebugNumber == 0 {
+		resp.DebugNumber, _ = strconv.ParseInt(httpResp.Header.Get(headerDebugNumber), 10, 64)
+	}
+	if resp.DebugMessage == "" {
+		resp.DebugMessage = httpResp.Header.Get(headerDebugMessage)
+	}
+	if resp.Alert == "" {
+		resp.Alert = httpResp.Header.Get(headerAlert)
+	}
+
+	if resp.StatusCode >= 400 {
+		return resp, &Error{StatusCode: resp.StatusCode, ErrorInfo: resp.ErrorInfo, Alert: resp.Alert}
+	}
+	if decodeErr != nil {
+		return resp, fmt.Errorf("2473660120 cannot decode response envelope: %v", decodeErr)
+	}
+
+	client.mu.RLock()
+	defer client.mu.RUnlock()
+	for payloadType, raws := range env.Payloads {
+		resp.RawPayloads[payloadType] = raws
+		goType, registered := client.payloadTypes[payloadType]
+		if registered == false {
+			continue
+		}
+		payloads := make([]grunway.Payload, 0, len(raws))
+		for _, raw := range raws {
+			ptr := reflect.New(goType).Interface()
+			err := json.Unmarshal(raw, ptr)
+			if err != nil {
+				return resp, fmt.Errorf("2473660121 cannot decode %s payload: %v", payloadType, err)
+			}
+			payloads = append(payloads, ptr.(grunway.Payload))
+		}
+		resp.Payloads[payloadType] = payloads
+	}
+	return resp, nil
+}
+
+// ######
+// #     # ###### ##### #####  #   #
+// #     # #        #   #    #  # #
+// ######  #####    #   #    #   #
+// #   #   #        #   #####    #
+// #    #  #        #   #   #    #
+// #     # ######   #   #    #   #
+//
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+func shouldRetry(resp *Response, err error) bool {
+	if resp == nil {
+		// network error.  context errors are checked by the caller
+		return err != nil
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// exponential w/ up to 50% jitter, so a fleet of clients doesn't retry in lockstep
+func (policy RetryPolicy) backoff(attempt int) time.Duration {
+	delay := policy.BaseDelay
+	for i := 1; i < attempt && (policy.MaxDelay <= 0 || delay < policy.MaxDelay); i++ {
+		delay *= 2
+	}
+	if delay > 0 {
+		delay += time.Duration(rand.Int63n(int64(delay)/2 + 1))
+	}
+	return delay
+}
+
+// seconds or an HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if when, err := http.ParseTime(value); err == nil && when.After(now) {
+		return when.Sub(now)
+	}
+	return 0
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,167 @@
+package grunwayclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/amattn/grunway"
+)
+
+type widgetPayload struct {
+	PKey int64
+	Name string
+}
+
+func (payload widgetPayload) PayloadType() string {
+	return "widget"
+}
+
+type widgetController struct{}
+
+func (controller *widgetController) GetSecretKey(publicKey string) (string, int) {
+	if publicKey == "pub" {
+		return "secret", 0
+	}
+	return "", grunway.SignatureUnknownKeyErrorNumber
+}
+func (controller *widgetController) PerformAuth(routePtr *grunway.Route, ctx *grunway.Context) (bool, int) {
+	publicKey, ok, errNum := grunway.VerifyRequestSignature(ctx, controller.GetSecretKey, grunway.DefaultSignatureMaxSkew, time.Now())
+	ctx.PublicKey = publicKey
+	return ok, errNum
+}
+
+func (controller *widgetController) GetHandlerV1(ctx *grunway.Context) grunway.RouteHandlerResult {
+	if ctx.End.PrimaryKey == 404 {
+		return ctx.MakeRouteHandlerResultNotFound(2473660190)
+	}
+	return ctx.MakeRouteHandlerResultPayloads(widgetPayload{PKey: ctx.End.PrimaryKey, Name: "sprocket"})
+}
+func (controller *widgetController) GetHandlerV1Popular(ctx *grunway.Context) grunway.RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultPayloads(widgetPayload{PKey: 1}, widgetPayload{PKey: 2})
+}
+func (controller *widgetController) AuthPostHandlerV1(ctx *grunway.Context) grunway.RouteHandlerResult {
+	var payload widgetPayload
+	body, _ := ctx.RequestBody()
+	if len(body) == 0 {
+		return ctx.MakeRouteHandlerResultError(http.StatusBadRequest, 2473660191, "empty")
+	}
+	payload.Name = ctx.PublicKey
+	return ctx.MakeRouteHandlerResultPayloads(payload)
+}
+
+func newTestServer() *httptest.Server {
+	router := grunway.NewRouter()
+	router.BasePath = "/api/"
+	router.PostProcessors = nil
+	router.RegisterEntity("widget", &widgetController{})
+	return httptest.NewServer(router)
+}
+
+func TestClientRoundTrip(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+	client := New(server.URL+"/api", widgetPayload{})
+	ctx := context.Background()
+
+	resp, err := client.Entity(1, "widget").Key(12).Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	widget, ok := resp.First("widget").(*widgetPayload)
+	if ok == false || widget.PKey != 12 || widget.Name != "sprocket" {
+		t.Error("2473660150 unexpected payload", resp.First("widget"))
+	}
+
+	resp, err = client.Entity(1, "widget").Action("popular").Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var widgets []widgetPayload
+	if err := resp.DecodePayloads("widget", &widgets); err != nil || len(widgets) != 2 {
+		t.Error("2473660151 unexpected payloads", widgets, err)
+	}
+
+	_, err = client.Entity(1, "widget").Key(404).Get(ctx)
+	apiErr, ok := err.(*Error)
+	if ok == false || apiErr.StatusCode != http.StatusNotFound || apiErr.ErrorNumber != 2473660190 {
+		t.Error("2473660152 expected a 404 *Error", err)
+	}
+}
+
+func TestClientSigning(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+	client := New(server.URL+"/api", widgetPayload{})
+	ctx := context.Background()
+
+	_, err := client.Entity(1, "widget").Post(ctx, widgetPayload{Name: "x"})
+	if apiErr, ok := err.(*Error); ok == false || apiErr.StatusCode != http.StatusForbidden {
+		t.Error("2473660153 unsigned request should be forbidden", err)
+	}
+
+	client.PublicKey, client.SecretKey = "pub", "secret"
+	resp, err := client.Entity(1, "widget").Post(ctx, widgetPayload{Name: "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if widget := resp.First("widget").(*widgetPayload); widget.Name != "pub" {
+		t.Error("2473660154 expected auth'd public key", widget)
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"Payloads":{"widget":[{"PKey":7}]}}`))
+	}))
+	defer server.Close()
+
+	client := New(server.URL, widgetPayload{})
+	var slept []time.Duration
+	client.sleep = func(ctx context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		return nil
+	}
+
+	resp, err := client.Entity(1, "widget").Key(7).Get(context.Background())
+	if err != nil || resp.First("widget").(*widgetPayload).PKey != 7 {
+		t.Fatal("2473660155 expected success after retries", resp, err)
+	}
+	if len(slept) != 2 || slept[0] != time.Second {
+		t.Error("2473660156 expected two Retry-After sleeps", slept)
+	}
+
+	// POST is not idempotent
+	atomic.StoreInt32(&calls, 0)
+	_, err = client.Entity(1, "widget").Post(context.Background(), widgetPayload{})
+	if apiErr, ok := err.(*Error); ok == false || apiErr.StatusCode != http.StatusServiceUnavailable || atomic.LoadInt32(&calls) != 1 {
+		t.Error("2473660157 POST should not be retried", err, calls)
+	}
+}
+
+func TestRequestPath(t *testing.T) {
+	client := New("http://localhost/api/")
+	cases := map[string]*Request{
+		"/v1/book/":             client.Entity(1, "book"),
+		"/v1/book/12":           client.Entity(1, "book").Key(12),
+		"/v2/book/12/popular":   client.Entity(2, "book").Key(12).Action("popular"),
+		"/v1/book/search/a%20b": client.Entity(1, "book").Action("search").Extra("a b"),
+	}
+	for expected, req := range cases {
+		if req.Path() != expected {
+			t.Error("2473660158 expected", expected, "got", req.Path())
+		}
+	}
+	if u := client.Entity(1, "book").Query("q", "x").URL(); u != "http://localhost/api/v1/book/?q=x" {
+		t.Error("2473660159 unexpected URL", u)
+	}
+}
@@ -0,0 +1,426 @@
+// Package grunwaytest is an in-process test harness for grunway routers.
+//
+//	func TestBooks(t *testing.T) {
+//		h := grunwaytest.New(t, router)
+//
+//		var books []BookPayload
+//		h.GET(1, "book", 12).Expect(200).Payloads("book", &books)
+//
+//		h.GET(1, "book", 999).Expect(404).ExpectErrorNumber(grunway.NotFoundErrorNumber)
+//
+//		h.POST(1, "book").WithAuth(grunwaytest.AllowAuth("pub")).WithJSON(book).Expect(200)
+//		h.POST(1, "book").WithAuth(grunwaytest.DenyAuth(1234)).Expect(403).ExpectErrorNumber(1234)
+//	}
+package grunwaytest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/amattn/grunway"
+	"github.com/amattn/grunway/internal/testhooks"
+)
+
+// Harness sends requests straight to Router.ServeHTTP, no network involved.
+//
+// The router itself is never modified, so several harnesses can share one, and parallel tests are fine.
+// Each request carries its own WithAuth authenticator and access log output, see AccessLog.
+type Harness struct {
+	T      testing.TB
+	Router *grunway.Router
+
+	mu        sync.Mutex // guards accessLog
+	accessLog *bytes.Buffer
+}
+
+func New(t testing.TB, routerPtr *grunway.Router) *Harness {
+	h := new(Harness)
+	h.T = t
+	h.Router = routerPtr
+	h.accessLog = new(bytes.Buffer)
+	testhooks.Enable()
+	return h
+}
+
+// AccessLog returns the lines written by the router's CommonLoggers for this harness's requests so far.
+func (h *Harness) AccessLog() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	trimmed := strings.TrimRight(h.accessLog.String(), " \n")
+	if trimmed == "" {
+		return []string{}
+	}
+	lines := strings.Split(trimmed, "\n")
+	for i := range lines {
+		lines[i] = strings.TrimSpace(lines[i])
+	}
+	return lines
+}
+
+type accessLogWriter struct {
+	h *Harness
+}
+
+func (writer *accessLogWriter) Write(p []byte) (int, error) {
+	writer.h.mu.Lock()
+	defer writer.h.mu.Unlock()
+	return writer.h.accessLog.Write(p)
+}
+
+// ######
+// #     # ######  ####  #    # ######  ####  #####
+// #     # #      #    # #    # #      #        #
+// ######  #####  #    # #    # #####   ####    #
+// #   #   #      #  # # #    # #           #   #
+// #    #  #      #   #  #    # #      #    #   #
+// #     # ######  ### #  ####  ######  ####    #
+//
+
+// pathParts are an int/int64 primary key and/or string action and extras, in url order, eg (12, "popular").
+func (h *Harness) GET(version int, entity string, pathParts ...interface{}) *Request {
+	return h.Request("GET", version, entity, pathParts...)
+}
+func (h *Harness) POST(version int, entity string, pathParts ...interface{}) *Request {
+	return h.Request("POST", version, entity, pathParts...)
+}
+func (h *Harness) PUT(version int, entity string, pathParts ...interface{}) *Request {
+	return h.Request("PUT", version, entity, pathParts...)
+}
+func (h *Harness) PATCH(version int, entity string, pathParts ...interface{}) *Request {
+	return h.Request("PATCH", version, entity, pathParts...)
+}
+func (h *Harness) DELETE(version int, entity string, pathParts ...interface{}) *Request {
+	return h.Request("DELETE", version, entity, pathParts...)
+}
+func (h *Harness) HEAD(version int, entity string, pathParts ...interface{}) *Request {
+	return h.Request("HEAD", version, entity, pathParts...)
+}
+
+func (h *Harness) Request(method string, version int, entity string, pathParts ...interface{}) *Request {
+	components := []string{strings.Trim(h.Router.BasePath, "/"), "v" + strconv.Itoa(version), url.PathEscape(entity)}
+	for _, part := range pathParts {
+		switch typed := part.(type) {
+		case string:
+			components = append(components, url.PathEscape(typed))
+		case int, int64, int32, uint, uint64, uint32:
+			components = append(components, fmt.Sprint(typed))
+		default:
+			h.T.Fatalf("grunwaytest: unsupported path part %#v, use an integer key or a string", part)
+		}
+	}
+	path := "/" + strings.TrimLeft(strings.Join(components, "/"), "/")
+	if len(pathParts) == 0 {
+		path += "/"
+	}
+	return h.RawRequest(method, path)
+}
+
+// RawRequest is for paths the builders can't express, eg malformed ones.
+func (h *Harness) RawRequest(method, path string) *Request {
+	req := new(Request)
+	req.h = h
+	req.method = method
+	req.path = path
+	req.query = url.Values{}
+	req.header = http.Header{}
+	return req
+}
+
+type Request struct {
+	h      *Harness
+	method string
+	path   string
+	query  url.Values
+	header http.Header
+	body   []byte
+	auth   grunway.AuthHandler
+
+	signPublicKey string
+	signSecretKey string
+}
+
+func (req *Request) WithHeader(key, value string) *Request {
+	req.header.Add(key, value)
+	return req
+}
+
+func (req *Request) WithQuery(key, value string) *Request {
+	req.query.Add(key, value)
+	return req
+}
+
+func (req *Request) WithBody(body []byte) *Request {
+	req.body = body
+	return req
+}
+
+// WithJSON encodes v as the request body.
+func (req *Request) WithJSON(v interface{}) *Request {
+	body, err := json.Marshal(v)
+	if err != nil {
+		req.h.T.Fatalf("grunwaytest: cannot encode request body: %v", err)
+	}
+	req.header.Set("Content-Type", "application/json")
+	req.body = body
+	return req
+}
+
+// WithAuth replaces the authenticator of every auth'd route, for this request only.
+// See AllowAuth and DenyAuth.
+func (req *Request) WithAuth(auth grunway.AuthHandler) *Request {
+	req.auth = auth
+	return req
+}
+
+// WithSignature signs the request w/ grunway.SignRequest, for testing the real AuthHandlers.
+func (req *Request) WithSignature(publicKey, secretKey string) *Request {
+	req.signPublicKey = publicKey
+	req.signSecretKey = secretKey
+	return req
+}
+
+// Do sends the request.  Expect is usually more convenient.
+func (req *Request) Do() *Response {
+	h := req.h
+	h.T.Helper()
+
+	target := req.path
+	if len(req.query) > 0 {
+		target += "?" + req.query.Encode()
+	}
+	var body io.Reader
+	if req.body != nil {
+		body = bytes.NewReader(req.body)
+	}
+	httpReq := httptest.NewRequest(req.method, target, body)
+	for key, values := range req.header {
+		for _, value := range values {
+			httpReq.Header.Add(key, value)
+		}
+	}
+	if req.signPublicKey != "" {
+		grunway.SignRequest(httpReq, req.signPublicKey, req.signSecretKey, req.body, time.Now())
+	}
+	if req.auth != nil {
+		httpReq = testhooks.WithAuthHandler(httpReq, req.auth)
+	}
+	httpReq = testhooks.WithAccessLogOutput(httpReq, &accessLogWriter{h: h})
+
+	recorder := httptest.NewRecorder()
+	h.Router.ServeHTTP(recorder, httpReq)
+	return newResponse(h, req, recorder)
+}
+
+// Expect sends the request and asserts the status code.
+func (req *Request) Expect(statusCode int) *Response {
+	req.h.T.Helper()
+	resp := req.Do()
+	return resp.ExpectStatus(statusCode)
+}
+
+// ######
+// #     # ######  ####  #####   ####  #    #  ####  ######
+// #     # #      #      #    # #    # ##   # #      #
+// ######  #####   ####  #    # #    # # #  #  ####  #####
+// #   #   #           # #####  #    # #  # #      # #
+// #    #  #      #    # #      #    # #   ## #    # #
+// #     # ######  ####  #       ####  #    #  ####  ######
+//
+
+type Response struct {
+	h        *Harness
+	label    string // eg "GET /api/v1/book/12", for failure messages
+	Recorder *httptest.ResponseRecorder
+
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+
+	grunway.ErrorInfo
+	Alert       string
+	RawPayloads map[string][]json.RawMessage
+
+	decodeErr error
+}
+
+type envelope struct {
+	Payloads     map[string][]json.RawMessage
+	ErrorNumber  int64
+	ErrorMessage string
+	DebugNumber  int64
+	DebugMessage string
+	Alert        string
+}
+
+func newResponse(h *Harness, req *Request, recorder *httptest.ResponseRecorder) *Response {
+	resp := new(Response)
+	resp.h = h
+	resp.label = req.method + " " + req.path
+	resp.Recorder = recorder
+	resp.StatusCode = recorder.Code
+	resp.Header = recorder.Header()
+	resp.Body = recorder.Body.Bytes()
+
+	var env envelope
+	if len(bytes.TrimSpace(resp.Body)) > 0 {
+		resp.decodeErr = json.Unmarshal(resp.Body, &env)
+	}
+	resp.ErrorInfo = grunway.ErrorInfo{
+		ErrorNumber:  env.ErrorNumber,
+		ErrorMessage: env.ErrorMessage,
+		DebugNumber:  env.DebugNumber,
+		DebugMessage: env.DebugMessage,
+	}
+	resp.Alert = env.Alert
+	resp.RawPayloads = env.Payloads
+	if resp.RawPayloads == nil {
+		resp.RawPayloads = make(map[string][]json.RawMessage)
+	}
+	return resp
+}
+
+func (resp *Response) ExpectStatus(statusCode int) *Response {
+	resp.h.T.Helper()
+	if resp.StatusCode != statusCode {
+		resp.h.T.Errorf("%s: expected status %d, got %d.  body: %s", resp.label, statusCode, resp.StatusCode, resp.Body)
+	}
+	return resp
+}
+
+func (resp *Response) ExpectErrorNumber(errorNumber int64) *Response {
+	resp.h.T.Helper()
+	if resp.ErrorNumber != errorNumber {
+		resp.h.T.Errorf("%s: expected errorNumber %d, got %d (%q)", resp.label, errorNumber, resp.ErrorNumber, resp.ErrorMessage)
+	}
+	return resp
+}
+
+func (resp *Response) ExpectErrorMessage(errorMessage string) *Response {
+	resp.h.T.Helper()
+	if resp.ErrorMessage != errorMessage {
+		resp.h.T.Errorf("%s: expected errorMessage %q, got %q", resp.label, errorMessage, resp.ErrorMessage)
+	}
+	return resp
+}
+
+func (resp *Response) ExpectAlert(alert string) *Response {
+	resp.h.T.Helper()
+	if resp.Alert != alert {
+		resp.h.T.Errorf("%s: expected alert %q, got %q", resp.label, alert, resp.Alert)
+	}
+	return resp
+}
+
+func (resp *Response) ExpectHeader(key, value string) *Response {
+	resp.h.T.Helper()
+	if got := resp.Header.Get(key); got != value {
+		resp.h.T.Errorf("%s: expected header %s: %q, got %q", resp.label, key, value, got)
+	}
+	return resp
+}
+
+// ExpectPayloadCount asserts the number of payloads of payloadType.
+func (resp *Response) ExpectPayloadCount(payloadType string, count int) *Response {
+	resp.h.T.Helper()
+	if got := len(resp.RawPayloads[payloadType]); got != count {
+		resp.h.T.Errorf("%s: expected %d %s payloads, got %d", resp.label, count, payloadType, got)
+	}
+	return resp
+}
+
+// Payloads decodes the payloads of payloadType into slicePtr, eg &[]BookPayload{}.  A missing type is an error.
+func (resp *Response) Payloads(payloadType string, slicePtr interface{}) *Response {
+	resp.h.T.Helper()
+	if resp.decodeErr != nil {
+		resp.h.T.Fatalf("%s: response is not a PayloadWrapper: %v.  body: %s", resp.label, resp.decodeErr, resp.Body)
+	}
+	raws, exists := resp.RawPayloads[payloadType]
+	if exists == false {
+		resp.h.T.Fatalf("%s: no %s payloads.  body: %s", resp.label, payloadType, resp.Body)
+	}
+	rawList, _ := json.Marshal(raws)
+	if err := json.Unmarshal(rawList, slicePtr); err != nil {
+		resp.h.T.Fatalf("%s: cannot decode %s payloads into %T: %v", resp.label, payloadType, slicePtr, err)
+	}
+	return resp
+}
+
+// JSON decodes the whole body into v, for handlers that don't return a PayloadWrapper.
+func (resp *Response) JSON(v interface{}) *Response {
+	resp.h.T.Helper()
+	if err := json.Unmarshal(resp.Body, v); err != nil {
+		resp.h.T.Fatalf("%s: cannot decode body into %T: %v.  body: %s", resp.label, v, err, resp.Body)
+	}
+	return resp
+}
+
+// #######
+// #         ##   #    # ######  ####
+// #        #  #  #   #  #      #
+// #####   #    # ####   #####   ####
+// #       ###### #  #   #           #
+// #       #    # #   #  #      #    #
+// #       #    # #    # ######  ####
+//
+
+// FakeAuth is an AuthHandler for tests.  See AllowAuth and DenyAuth.
+type FakeAuth struct {
+	Allow          bool
+	PublicKey      string // set on ctx.PublicKey when allowed
+	AccountPKey    int64  // set on ctx.AccountPKey when allowed
+	ErrorNumber    int    // returned when denied
+	SecretKeys     map[string]string
+	AuthenticateFn func(routePtr *grunway.Route, ctx *grunway.Context) (bool, int) // optional, overrides everything above
+
+	mu    sync.Mutex
+	calls int
+}
+
+func AllowAuth(publicKey string) *FakeAuth {
+	return &FakeAuth{Allow: true, PublicKey: publicKey}
+}
+
+func DenyAuth(errorNumber int) *FakeAuth {
+	return &FakeAuth{Allow: false, ErrorNumber: errorNumber}
+}
+
+func (auth *FakeAuth) PerformAuth(routePtr *grunway.Route, ctx *grunway.Context) (bool, int) {
+	auth.mu.Lock()
+	auth.calls++
+	auth.mu.Unlock()
+
+	if auth.AuthenticateFn != nil {
+		return auth.AuthenticateFn(routePtr, ctx)
+	}
+	if auth.Allow == false {
+		return false, auth.ErrorNumber
+	}
+	ctx.PublicKey = auth.PublicKey
+	ctx.AccountPKey = auth.AccountPKey
+	return true, 0
+}
+
+func (auth *FakeAuth) GetSecretKey(publicKey string) (string, int) {
+	secretKey, exists := auth.SecretKeys[publicKey]
+	if exists == false {
+		return "", grunway.SignatureUnknownKeyErrorNumber
+	}
+	return secretKey, 0
+}
+
+// Calls is the number of times PerformAuth was called.
+func (auth *FakeAuth) Calls() int {
+	auth.mu.Lock()
+	defer auth.mu.Unlock()
+	return auth.calls
+}
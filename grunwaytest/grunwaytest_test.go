@@ -0,0 +1,151 @@
+package grunwaytest
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/amattn/grunway"
+)
+
+type gadgetPayload struct {
+	PKey int64
+	Name string
+}
+
+func (payload gadgetPayload) PayloadType() string {
+	return "gadget"
+}
+
+type gadgetController struct{}
+
+func (controller *gadgetController) PerformAuth(routePtr *grunway.Route, ctx *grunway.Context) (bool, int) {
+	return false, 1111
+}
+func (controller *gadgetController) GetSecretKey(publicKey string) (string, int) {
+	return "", 1112
+}
+
+func (controller *gadgetController) GetHandlerV1(ctx *grunway.Context) grunway.RouteHandlerResult {
+	if ctx.End.PrimaryKey == 404 {
+		return ctx.MakeRouteHandlerResultNotFound(4041111)
+	}
+	ctx.SetHeader("X-Gadget", "yes")
+	return ctx.MakeRouteHandlerResultPayloads(gadgetPayload{PKey: ctx.End.PrimaryKey, Name: "gizmo"})
+}
+func (controller *gadgetController) GetHandlerV1Maint(ctx *grunway.Context) grunway.RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultAlert(http.StatusServiceUnavailable, 5031111, "maintenance")
+}
+func (controller *gadgetController) AuthPostHandlerV1(ctx *grunway.Context) grunway.RouteHandlerResult {
+	var payload gadgetPayload
+	body, _ := ctx.RequestBody()
+	if len(body) == 0 {
+		return ctx.MakeRouteHandlerResultError(http.StatusBadRequest, 4001111, "empty")
+	}
+	payload.Name = ctx.PublicKey
+	return ctx.MakeRouteHandlerResultPayloads(payload)
+}
+
+func newHarness(t *testing.T) *Harness {
+	router := grunway.NewRouter()
+	router.BasePath = "/api/"
+	router.RegisterEntity("gadget", &gadgetController{})
+	return New(t, router)
+}
+
+func TestHarness(t *testing.T) {
+	h := newHarness(t)
+
+	var gadgets []gadgetPayload
+	h.GET(1, "gadget", 12).Expect(200).ExpectHeader("X-Gadget", "yes").Payloads("gadget", &gadgets)
+	if len(gadgets) != 1 || gadgets[0].PKey != 12 {
+		t.Error("1309955401 unexpected payloads", gadgets)
+	}
+
+	h.GET(1, "gadget", 404).Expect(404).ExpectErrorNumber(4041111)
+	h.GET(1, "gadget", "maint").Expect(503).ExpectAlert("maintenance").ExpectErrorNumber(5031111)
+	h.GET(1, "gadget").Expect(400).ExpectErrorNumber(grunway.BadRequestMissingPrimaryKeyErrorNumber)
+
+	// the controller's own auth always fails
+	h.POST(1, "gadget").WithJSON(gadgetPayload{}).Expect(403).ExpectErrorNumber(1111)
+
+	allow := AllowAuth("pub")
+	h.POST(1, "gadget").WithAuth(allow).WithJSON(gadgetPayload{}).Expect(200).Payloads("gadget", &gadgets)
+	if gadgets[0].Name != "pub" || allow.Calls() != 1 {
+		t.Error("1309955402 fake auth not used", gadgets, allow.Calls())
+	}
+	h.POST(1, "gadget").WithAuth(DenyAuth(2222)).WithJSON(gadgetPayload{}).Expect(403).ExpectErrorNumber(2222)
+
+	// authenticators are restored afterwards
+	h.POST(1, "gadget").WithJSON(gadgetPayload{}).Expect(403).ExpectErrorNumber(1111)
+
+	log := h.AccessLog()
+	if len(log) != 8 || strings.Contains(log[0], `"GET /api/v1/gadget/12 HTTP/1.1" 200`) == false {
+		t.Error("1309955403 unexpected access log", len(log), log)
+	}
+}
+
+// harnesses share the router w/o touching it
+func TestHarnessesShareRouter(t *testing.T) {
+	router := grunway.NewRouter()
+	router.BasePath = "/api/"
+	router.RegisterEntity("gadget", &gadgetController{})
+	logger := router.PostProcessors[0].(*grunway.CommonLogger)
+	authenticators := make(map[*grunway.Route]grunway.AuthHandler)
+	for _, routePtr := range router.RouteMap {
+		authenticators[routePtr] = routePtr.Authenticator
+	}
+
+	for _, name := range []string{"allowed", "denied"} {
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			h := New(t, router)
+			for i := 0; i < 50; i++ {
+				if name == "allowed" {
+					h.POST(1, "gadget").WithAuth(AllowAuth("pub")).WithJSON(gadgetPayload{}).Expect(200)
+				} else {
+					h.POST(1, "gadget").WithJSON(gadgetPayload{}).Expect(403).ExpectErrorNumber(1111)
+				}
+			}
+			if log := h.AccessLog(); len(log) != 50 {
+				t.Error("1309955405 expected only this harness's requests, got", len(log))
+			}
+		})
+	}
+	t.Cleanup(func() {
+		if logger.Output != nil {
+			t.Error("1309955406 logger output changed", logger.Output)
+		}
+		for routePtr, authenticator := range authenticators {
+			if routePtr.Authenticator != authenticator {
+				t.Error("1309955407 authenticator changed", routePtr.HandlerName)
+			}
+		}
+	})
+}
+
+// assertions report failures on the TB they were given
+func TestHarnessReportsFailures(t *testing.T) {
+	recorder := &recordingTB{TB: t}
+	router := grunway.NewRouter()
+	router.BasePath = "/api/"
+	router.PostProcessors = nil
+	router.RegisterEntity("gadget", &gadgetController{})
+	h := New(recorder, router)
+
+	h.GET(1, "gadget", 12).Expect(404).ExpectErrorNumber(1).ExpectHeader("X-Gadget", "no")
+	if len(recorder.errors) != 3 {
+		t.Error("1309955404 expected 3 failures, got", recorder.errors)
+	}
+}
+
+type recordingTB struct {
+	testing.TB
+	errors []string
+}
+
+func (tb *recordingTB) Helper() {}
+func (tb *recordingTB) Errorf(format string, args ...interface{}) {
+	tb.errors = append(tb.errors, format)
+}
@@ -0,0 +1,146 @@
+package grunwaytest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"os"
+	"strings"
+
+	"github.com/amattn/grunway"
+)
+
+// ######
+// #     # ###### #####  #        ##   #   #
+// #     # #      #    # #       #  #   # #
+// ######  #####  #    # #      #    #   #
+// #   #   #      #####  #      ######   #
+// #    #  #      #      #      #    #   #
+// #     # ###### #      ###### #    #   #
+//
+
+type ReplayOptions struct {
+	// values at these JSON pointers are ignored when comparing response bodies, eg "/Payloads/book/*/Updated"
+	IgnorePointers []string
+
+	// extra headers added to every replayed request, eg fresh credentials
+	Headers map[string]string
+
+	MaxDifferencesPerExchange int // default 10
+}
+
+type ReplayDifference struct {
+	Line   int // 1 based line in the recording
+	Method string
+	URL    string
+	grunway.ExchangeDifference
+}
+
+func (difference ReplayDifference) String() string {
+	where := difference.Field
+	if difference.Pointer != "" {
+		where += " " + difference.Pointer
+	}
+	return fmt.Sprintf("line %d %s %s: %s expected %s, got %s", difference.Line, difference.Method, difference.URL, where, difference.Expected, difference.Actual)
+}
+
+type ReplayReport struct {
+	Total       int
+	Passed      int
+	Skipped     int // exchanges whose request body was too big to record, they can't be replayed
+	Differences []ReplayDifference
+}
+
+func (report *ReplayReport) OK() bool {
+	return len(report.Differences) == 0
+}
+
+func (report *ReplayReport) String() string {
+	lines := []string{fmt.Sprintf("%d/%d exchanges matched", report.Passed, report.Total)}
+	if report.Skipped > 0 {
+		lines[0] += fmt.Sprintf(", %d skipped", report.Skipped)
+	}
+	for _, difference := range report.Differences {
+		lines = append(lines, difference.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ReplayTrafficFile is ReplayTraffic for a recording on disk.
+func ReplayTrafficFile(routerPtr *grunway.Router, path string, options ReplayOptions) (*ReplayReport, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("2590157120 cannot open traffic recording: %v", err)
+	}
+	defer file.Close()
+	return ReplayTraffic(routerPtr, file, options)
+}
+
+// ReplayTraffic feeds every request recorded by a grunway.TrafficRecorder through routerPtr, in process,
+// and compares the responses against the recording, see grunway.RecordedExchange.Compare.
+// An error is only returned if the recording can't be read.
+func ReplayTraffic(routerPtr *grunway.Router, recording io.Reader, options ReplayOptions) (*ReplayReport, error) {
+	report := new(ReplayReport)
+
+	scanner := bufio.NewScanner(recording)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var exchange grunway.RecordedExchange
+		err := json.Unmarshal(line, &exchange)
+		if err != nil {
+			return report, fmt.Errorf("2590157121 line %d is not a RecordedExchange: %v", lineNumber, err)
+		}
+		if exchange.RequestTruncated {
+			report.Skipped++
+			continue
+		}
+
+		recorder := replayExchange(routerPtr, exchange, options)
+		differences := exchange.Compare(recorder.Code, recorder.Header(), recorder.Body.Bytes(), options.IgnorePointers, options.MaxDifferencesPerExchange)
+		report.Total++
+		if len(differences) == 0 {
+			report.Passed++
+		}
+		for _, difference := range differences {
+			report.Differences = append(report.Differences, ReplayDifference{
+				Line:               lineNumber,
+				Method:             exchange.Method,
+				URL:                exchange.URL,
+				ExchangeDifference: difference,
+			})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return report, fmt.Errorf("2590157122 cannot read traffic recording: %v", err)
+	}
+	return report, nil
+}
+
+func replayExchange(routerPtr *grunway.Router, exchange grunway.RecordedExchange, options ReplayOptions) *httptest.ResponseRecorder {
+	var body io.Reader
+	if len(exchange.RequestBody) > 0 {
+		body = bytes.NewReader(exchange.RequestBody)
+	} else if exchange.RequestText != "" {
+		body = strings.NewReader(exchange.RequestText)
+	}
+	req := httptest.NewRequest(exchange.Method, exchange.URL, body)
+	for key, value := range exchange.RequestHeaders {
+		req.Header.Set(key, value)
+	}
+	for key, value := range options.Headers {
+		req.Header.Set(key, value)
+	}
+
+	recorder := httptest.NewRecorder()
+	routerPtr.ServeHTTP(recorder, req)
+	return recorder
+}
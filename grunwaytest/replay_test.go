@@ -0,0 +1,78 @@
+package grunwaytest
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/amattn/grunway"
+)
+
+type sprocketPayload struct {
+	PKey   int64
+	Name   string
+	Issued int64
+}
+
+func (payload sprocketPayload) PayloadType() string {
+	return "sprocket"
+}
+
+type sprocketController struct {
+	name   string
+	issued int64
+}
+
+func (controller *sprocketController) GetHandlerV1(ctx *grunway.Context) grunway.RouteHandlerResult {
+	controller.issued++
+	return ctx.MakeRouteHandlerResultPayloads(sprocketPayload{PKey: ctx.End.PrimaryKey, Name: controller.name, Issued: controller.issued})
+}
+func (controller *sprocketController) PostHandlerV1(ctx *grunway.Context) grunway.RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultOk()
+}
+
+func makeSprocketRouter(name string, postProcessors ...grunway.PostProcessor) *grunway.Router {
+	router := grunway.NewRouter()
+	router.BasePath = "/api/"
+	router.PostProcessors = postProcessors
+	router.RegisterEntity("sprocket", &sprocketController{name: name})
+	return router
+}
+
+func TestReplayTraffic(t *testing.T) {
+	recording := new(bytes.Buffer)
+	router := makeSprocketRouter("cog", grunway.NewTrafficRecorder(recording, grunway.TrafficRecorderOptions{MaxBodyBytes: 256}))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://localhost/api/v1/sprocket/7", nil))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://localhost/api/v1/nothing/1", nil))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "http://localhost/api/v1/sprocket/", strings.NewReader(strings.Repeat("x", 300))))
+
+	// Issued changes on every request, so it has to be ignored
+	ignore := []string{"/Payloads/sprocket/*/Issued"}
+	report, err := ReplayTraffic(makeSprocketRouter("cog"), strings.NewReader(recording.String()), ReplayOptions{IgnorePointers: ignore})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.OK() == false || report.Total != 2 || report.Passed != 2 || report.Skipped != 1 {
+		t.Error("1309955420 expected a clean replay w/ the oversized request skipped", report)
+	}
+
+	report, _ = ReplayTraffic(makeSprocketRouter("gear"), strings.NewReader(recording.String()), ReplayOptions{IgnorePointers: ignore})
+	if len(report.Differences) != 1 || report.Differences[0].Pointer != "/Payloads/sprocket/0/Name" || report.Differences[0].Line != 1 {
+		t.Fatal("1309955421 expected one payload difference", report)
+	}
+	if report.Differences[0].Expected != `"cog"` || report.Differences[0].Actual != `"gear"` || strings.Contains(report.String(), "line 1 GET /api/v1/sprocket/7") == false {
+		t.Error("1309955422 unexpected difference", report)
+	}
+
+	busyRouter := makeSprocketRouter("cog")
+	busyRouter.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://localhost/api/v1/sprocket/1", nil))
+	report, _ = ReplayTraffic(busyRouter, strings.NewReader(recording.String()), ReplayOptions{})
+	if report.Passed != 1 || report.Differences[0].Pointer != "/Payloads/sprocket/0/Issued" {
+		t.Error("1309955423 expected Issued to differ without IgnorePointers", report)
+	}
+
+	if _, err := ReplayTraffic(busyRouter, strings.NewReader("not json\n"), ReplayOptions{}); err == nil {
+		t.Error("1309955424 expected an error for a bad recording")
+	}
+}
@@ -0,0 +1,78 @@
+// Package grunwaytsgen is the command line wrapper around grunway.Router.TypeScript.
+//
+// The routes only exist inside your program, so the generator runs from a tiny main in your module,
+// built from the same router setup your server uses:
+//
+//	// cmd/tsgen/main.go
+//	func main() {
+//		router := myapp.NewRouter()
+//		grunwaytsgen.Main(router, grunway.TypeScriptOptions{})
+//	}
+//
+// then
+//
+//	go run ./cmd/tsgen -out web/src/api.gen.ts
+//	go run ./cmd/tsgen -out web/src/api.gen.ts -check   # in CI, fails if the checked in file is stale
+package grunwaytsgen
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/amattn/grunway"
+)
+
+// Main calls Run w/ the process arguments and exits 1 on error.
+func Main(routerPtr *grunway.Router, options grunway.TypeScriptOptions) {
+	err := Run(routerPtr, options, os.Args[1:], os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// Run parses args (-out, -check, -client, -types-only) and writes the generated TypeScript.
+// w/o -out, the output goes to stdout.
+func Run(routerPtr *grunway.Router, options grunway.TypeScriptOptions, args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("grunwaytsgen", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	out := flags.String("out", "", "file to write, default stdout")
+	check := flags.Bool("check", false, "don't write, fail if -out is missing or differs from the generated output")
+	clientName := flags.String("client", options.ClientName, "name of the generated client class")
+	typesOnly := flags.Bool("types-only", options.SkipClient, "only generate the interfaces, no client")
+	if err := flags.Parse(args); err != nil {
+		return fmt.Errorf("1894075501 %v", err)
+	}
+	options.ClientName = *clientName
+	options.SkipClient = *typesOnly
+
+	generated := routerPtr.TypeScript(options)
+
+	if *check {
+		if *out == "" {
+			return fmt.Errorf("1894075502 -check requires -out")
+		}
+		existing, err := ioutil.ReadFile(*out)
+		if err != nil {
+			return fmt.Errorf("1894075503 cannot read %s: %v", *out, err)
+		}
+		if bytes.Equal(existing, generated) == false {
+			return fmt.Errorf("1894075504 %s is out of date, regenerate it", *out)
+		}
+		return nil
+	}
+
+	if *out == "" {
+		_, err := stdout.Write(generated)
+		return err
+	}
+	err := ioutil.WriteFile(*out, generated, 0644)
+	if err != nil {
+		return fmt.Errorf("1894075505 cannot write %s: %v", *out, err)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package grunwaytsgen
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/amattn/grunway"
+)
+
+func TestRunCheck(t *testing.T) {
+	router := grunway.NewRouter()
+	router.BasePath = "/api/"
+	router.EnableSystemRoutes(grunway.SystemRoutesOptions{})
+
+	dir, err := ioutil.TempDir("", "grunwaytsgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "api.gen.ts")
+
+	if err := Run(router, grunway.TypeScriptOptions{}, []string{"-out", out, "-check"}, nil); err == nil {
+		t.Error("1894075590 -check should fail when the file is missing")
+	}
+	if err := Run(router, grunway.TypeScriptOptions{}, []string{"-out", out}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := Run(router, grunway.TypeScriptOptions{}, []string{"-out", out, "-check"}, nil); err != nil {
+		t.Error("1894075591 freshly generated file should pass -check", err)
+	}
+
+	ioutil.WriteFile(out, []byte("// stale\n"), 0644)
+	if err := Run(router, grunway.TypeScriptOptions{}, []string{"-out", out, "-check"}, nil); err == nil {
+		t.Error("1894075592 -check should fail for a stale file")
+	}
+
+	stdout := new(bytes.Buffer)
+	if err := Run(router, grunway.TypeScriptOptions{}, []string{"-types-only"}, stdout); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(stdout.String(), "class GrunwayClient") {
+		t.Error("1894075593 -types-only should not generate the client")
+	}
+}
@@ -1,7 +1,6 @@
 package grunway
 
 import (
-	"encoding/json"
 	"net/http"
 
 	"github.com/amattn/deeperror"
@@ -13,24 +12,13 @@ type CreatePerformer interface {
 }
 
 func StandardCreateHandler(controller CreatePerformer, ctx *Context, createRequestPayload Payload) RouteHandlerResult {
-	// Get the request
-	requestBody := ctx.Req.Body
-	if requestBody == nil {
-		return ctx.MakeRouteHandlerResultError(http.StatusBadRequest, 3370318075, BadRequestPrefix+"Expected non-empty body")
-	}
-	defer requestBody.Close()
-
 	if ctx.End.PrimaryKey != 0 {
 		return ctx.MakeRouteHandlerResultError(http.StatusBadRequest, BadRequestExtraneousPrimaryKeyErrorNumber, BadRequestSyntaxErrorPrefix+" Cannot set primary key")
 	}
 
-	// parse the json
-	decoder := json.NewDecoder(requestBody)
-	err := decoder.Decode(createRequestPayload)
-
-	if err != nil {
-		errStr := BadRequestPrefix + ": Cannot parse body"
-		return ctx.MakeRouteHandlerResultError(http.StatusBadRequest, 3540227685, errStr)
+	// parse the request
+	if rerr := ctx.decodeRequest(createRequestPayload, 3370318075, 3540227685); rerr != nil {
+		return RouteHandlerResult{rerr, nil, nil}
 	}
 
 	// validate json
@@ -77,20 +65,9 @@ type UpdatePerformer interface {
 }
 
 func StandardUpdateHandler(controller UpdatePerformer, ctx *Context, updateRequestPayload Payload) RouteHandlerResult {
-	// Get the request
-	requestBody := ctx.Req.Body
-	if requestBody == nil {
-		return ctx.MakeRouteHandlerResultError(http.StatusBadRequest, 3851489100, BadRequestPrefix+"Expected non-empty body")
-	}
-	defer requestBody.Close()
-
-	// parse the json
-	decoder := json.NewDecoder(requestBody)
-	err := decoder.Decode(updateRequestPayload)
-
-	if err != nil {
-		errStr := BadRequestPrefix + ": Cannot parse body"
-		return ctx.MakeRouteHandlerResultError(http.StatusBadRequest, 1858602328, errStr)
+	// parse the request
+	if rerr := ctx.decodeRequest(updateRequestPayload, 3851489100, 1858602328); rerr != nil {
+		return RouteHandlerResult{rerr, nil, nil}
 	}
 
 	// validate json
@@ -162,3 +139,20 @@ func StandardDeleteHandler(ctx *Context, controller DeletePerformer) {
 
 	sendOkPayload(ctx)
 }
+
+// #     #
+// #     # ##### # #      # ##### # ######  ####
+// #     #   #   # #      #   #   # #      #
+// #     #   #   # #      #   #   # #####   ####
+// #     #   #   # #      #   #   # #           #
+// #     #   #   # #      #   #   # #      #    #
+//  #####    #   # ###### #   #   # ######  ####
+//
+
+// decodes the request body into payloadReference.  On failure, returns a 400 (or 415) result and true.
+func decodeRequestBody(ctx *Context, payloadReference interface{}, errNo int64) (RouteHandlerResult, bool) {
+	if rerr := ctx.decodeRequest(payloadReference, errNo, errNo); rerr != nil {
+		return RouteHandlerResult{rerr, nil, nil}, true
+	}
+	return RouteHandlerResult{}, false
+}
@@ -0,0 +1,47 @@
+// Package testhooks lets grunwaytest change how one request is handled w/o modifying the router.
+// It is internal, so only grunway and its subpackages can set the hooks.  They are ignored until Enable is called.
+package testhooks
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+var enabled atomic.Bool
+
+// Enable turns the hooks on for the rest of the process.  Called by grunwaytest.New.
+func Enable() {
+	enabled.Store(true)
+}
+
+type authHandlerKey struct{}
+type accessLogOutputKey struct{}
+
+// WithAuthHandler returns a copy of req for which every auth'd route uses authHandler, a grunway.AuthHandler.
+func WithAuthHandler(req *http.Request, authHandler interface{}) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), authHandlerKey{}, authHandler))
+}
+
+// AuthHandler returns the authHandler set by WithAuthHandler, or nil.
+func AuthHandler(req *http.Request) interface{} {
+	if enabled.Load() == false {
+		return nil
+	}
+	return req.Context().Value(authHandlerKey{})
+}
+
+// WithAccessLogOutput returns a copy of req that CommonLoggers write to output instead of their own Output.
+func WithAccessLogOutput(req *http.Request, output io.Writer) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), accessLogOutputKey{}, output))
+}
+
+// AccessLogOutput returns the output set by WithAccessLogOutput, or nil.
+func AccessLogOutput(req *http.Request) io.Writer {
+	if enabled.Load() == false {
+		return nil
+	}
+	output, _ := req.Context().Value(accessLogOutputKey{}).(io.Writer)
+	return output
+}
@@ -0,0 +1,24 @@
+package testhooks
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHooksNeedEnable(t *testing.T) {
+	output := new(bytes.Buffer)
+	req := httptest.NewRequest("GET", "/", nil)
+	req = WithAccessLogOutput(WithAuthHandler(req, "auth"), output)
+
+	if AuthHandler(req) != nil || AccessLogOutput(req) != nil {
+		t.Error("3178250641 hooks should be ignored until Enable")
+	}
+	Enable()
+	if AuthHandler(req) != "auth" || AccessLogOutput(req) != output {
+		t.Error("3178250642 hooks not applied after Enable")
+	}
+	if plain := httptest.NewRequest("GET", "/", nil); AuthHandler(plain) != nil || AccessLogOutput(plain) != nil {
+		t.Error("3178250643 unexpected hooks on a plain request")
+	}
+}
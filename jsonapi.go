@@ -0,0 +1,207 @@
+package grunway
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const httpHeaderContentTypeJSONAPI = "application/vnd.api+json"
+
+// JSONAPIIdentifier lets a payload supply its own JSON:API id.  Otherwise the id comes from the payload's
+// "id" field (any case), then "PKey".
+type JSONAPIIdentifier interface {
+	JSONAPIID() string
+}
+
+// JSONAPIEncoder renders PayloadWrappers as JSON:API documents (https://jsonapi.org):
+//
+//   - payloads of the route's entity type are the primary data, everything else is included.
+//     Responses w/ one payload type use it as the primary data whatever it's called.
+//   - each payload is a resource object: type is PayloadType(), id comes from the payload (see JSONAPIIdentifier),
+//     and the remaining fields are its attributes.
+//   - requests for one entity (a primary key and no action) get a single resource as data, everything else a list.
+//   - ErrorInfo becomes an errors object, w/ the error number as code and debug info in meta.
+//   - Meta and Alert go in the top level meta, Links in the top level links.
+//
+// Registered by default, but only used when the Accept header names application/vnd.api+json.
+// Use router.SetDefaultEncoder(JSONAPIEncoder{}) to send JSON:API to every client that doesn't ask for something else.
+type JSONAPIEncoder struct {
+	// set per response, see forResponse
+	primaryType string
+	single      bool
+	status      int
+}
+
+type jsonAPIDocument struct {
+	Data     interface{}            `json:"data,omitempty"`
+	Included []jsonAPIResource      `json:"included,omitempty"`
+	Errors   []jsonAPIError         `json:"errors,omitempty"`
+	Meta     map[string]interface{} `json:"meta,omitempty"`
+	Links    *PayloadLinks          `json:"links,omitempty"`
+}
+
+type jsonAPIResource struct {
+	Type       string                 `json:"type"`
+	ID         string                 `json:"id,omitempty"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+type jsonAPIError struct {
+	Status string                 `json:"status,omitempty"`
+	Code   string                 `json:"code,omitempty"`
+	Title  string                 `json:"title,omitempty"`
+	Meta   map[string]interface{} `json:"meta,omitempty"`
+}
+
+func (encoder JSONAPIEncoder) MediaType() string {
+	return httpHeaderContentTypeJSONAPI
+}
+func (encoder JSONAPIEncoder) namedOnly() {}
+func (encoder JSONAPIEncoder) forResponse(ctx *Context, code int) Encoder {
+	encoder.primaryType = ctx.End.EntityName
+	encoder.single = ctx.End.PrimaryKey != 0 && ctx.End.Action == ""
+	encoder.status = code
+	return encoder
+}
+func (encoder JSONAPIEncoder) Encode(v interface{}) ([]byte, error) {
+	pw, isPayloadWrapper := v.(*PayloadWrapper)
+	if isPayloadWrapper == false {
+		return json.Marshal(v)
+	}
+	doc, err := encoder.document(pw)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
+
+func (encoder JSONAPIEncoder) document(pw *PayloadWrapper) (*jsonAPIDocument, error) {
+	doc := new(jsonAPIDocument)
+	doc.Links = pw.Links
+	if pw.Alert != "" || len(pw.Meta) > 0 {
+		doc.Meta = make(map[string]interface{}, len(pw.Meta)+1)
+		for key, value := range pw.Meta {
+			doc.Meta[key] = value
+		}
+		if pw.Alert != "" {
+			doc.Meta["alert"] = pw.Alert
+		}
+	}
+	if pw.ErrorNumber != 0 {
+		// data and errors can't both be present
+		doc.Errors = []jsonAPIError{encoder.errorObject(pw.ErrorInfo)}
+		return doc, nil
+	}
+
+	payloadTypes := make([]string, 0, len(pw.Payloads))
+	for payloadType := range pw.Payloads {
+		payloadTypes = append(payloadTypes, payloadType)
+	}
+	sort.Strings(payloadTypes)
+
+	isPrimary := func(payloadType string) bool {
+		if _, exists := pw.Payloads[encoder.primaryType]; exists {
+			return payloadType == encoder.primaryType
+		}
+		// w/ no obvious primary type, everything is data
+		return true
+	}
+
+	data := []jsonAPIResource{}
+	for _, payloadType := range payloadTypes {
+		for _, payload := range pw.Payloads[payloadType] {
+			if payload == nil {
+				continue
+			}
+			resource, err := jsonAPIResourceFor(payload)
+			if err != nil {
+				return nil, err
+			}
+			if isPrimary(payloadType) {
+				data = append(data, resource)
+			} else {
+				doc.Included = append(doc.Included, resource)
+			}
+		}
+	}
+
+	switch {
+	case encoder.single && len(data) == 1:
+		doc.Data = data[0]
+	case encoder.single && len(data) == 0:
+		doc.Data = json.RawMessage("null")
+	default:
+		doc.Data = data
+	}
+	return doc, nil
+}
+
+func (encoder JSONAPIEncoder) errorObject(errorInfo ErrorInfo) jsonAPIError {
+	errorObject := jsonAPIError{
+		Code:  strconv.FormatInt(errorInfo.ErrorNumber, 10),
+		Title: errorInfo.ErrorMessage,
+	}
+	if encoder.status >= 400 {
+		errorObject.Status = strconv.Itoa(encoder.status)
+	}
+	if errorInfo.DebugNumber != 0 || errorInfo.DebugMessage != "" {
+		errorObject.Meta = map[string]interface{}{}
+		if errorInfo.DebugNumber != 0 {
+			errorObject.Meta["debugNumber"] = errorInfo.DebugNumber
+		}
+		if errorInfo.DebugMessage != "" {
+			errorObject.Meta["debugMessage"] = errorInfo.DebugMessage
+		}
+	}
+	return errorObject
+}
+
+func jsonAPIResourceFor(payload Payload) (jsonAPIResource, error) {
+	resource := jsonAPIResource{Type: payload.PayloadType()}
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return resource, err
+	}
+	doc, err := decodeJSONDocument(encoded)
+	if err != nil {
+		return resource, err
+	}
+	attributes, isObject := doc.(map[string]interface{})
+	if isObject == false {
+		return resource, fmt.Errorf("3840213101 %s payload is not a JSON object", resource.Type)
+	}
+
+	if identifier, hasID := payload.(JSONAPIIdentifier); hasID {
+		resource.ID = identifier.JSONAPIID()
+	} else if idKey := jsonAPIIDKey(attributes); idKey != "" {
+		resource.ID = fmt.Sprint(attributes[idKey])
+		delete(attributes, idKey)
+	}
+	if len(attributes) > 0 {
+		resource.Attributes = attributes
+	}
+	return resource, nil
+}
+
+// "id", then any casing of it, then "PKey" (any casing)
+func jsonAPIIDKey(attributes map[string]interface{}) string {
+	if _, exists := attributes["id"]; exists {
+		return "id"
+	}
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, candidate := range []string{"id", "pkey"} {
+		for _, key := range keys {
+			if strings.EqualFold(key, candidate) {
+				return key
+			}
+		}
+	}
+	return ""
+}
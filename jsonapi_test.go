@@ -0,0 +1,85 @@
+package grunway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type libraryController struct{}
+
+func (controller *libraryController) GetHandlerV1(ctx *Context) RouteHandlerResult {
+	if ctx.End.PrimaryKey == 404 {
+		return ctx.MakeRouteHandlerResultDebugError(http.StatusNotFound, 3840213150, "no such book", 7, "shelf empty")
+	}
+	return ctx.MakeRouteHandlerResultPayloads(BookPayload{ctx.End.PrimaryKey, "Dune", 2}, AuthorPayload{2, "Frank"})
+}
+
+func (controller *libraryController) GetHandlerV1All(ctx *Context) RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultPayloads(BookPayload{1, "Dune", 2}, BookPayload{3, "Emma", 4})
+}
+
+func TestJSONAPIEncoder(t *testing.T) {
+	router := NewRouter()
+	router.BasePath = "/api/"
+	router.RegisterEntity("book", &libraryController{})
+
+	get := func(path, accept string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://localhost/api/v1/"+path, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		router.ServeHTTP(w, req)
+		doc := map[string]interface{}{}
+		json.Unmarshal(w.Body.Bytes(), &doc)
+		return w, doc
+	}
+
+	w, doc := get("book/1", httpHeaderContentTypeJSONAPI)
+	expected := `{"data":{"type":"book","id":"1","attributes":{"AuthorId":2,"Name":"Dune"}},"included":[{"type":"author","id":"2","attributes":{"Name":"Frank"}}]}`
+	if w.Header().Get(httpHeaderContentType) != httpHeaderContentTypeJSONAPI || w.Body.String() != expected {
+		t.Error("3840213151 unexpected single resource document", w.Header(), w.Body.String())
+	}
+
+	w, doc = get("book/all", httpHeaderContentTypeJSONAPI)
+	if data, isList := doc["data"].([]interface{}); isList == false || len(data) != 2 {
+		t.Error("3840213152 expected a list of resources", w.Body.String())
+	}
+
+	w, doc = get("book/404", httpHeaderContentTypeJSONAPI)
+	expected = `{"errors":[{"status":"404","code":"3840213150","title":"no such book","meta":{"debugMessage":"shelf empty","debugNumber":7}}]}`
+	if w.Code != http.StatusNotFound || w.Body.String() != expected {
+		t.Error("3840213153 unexpected error document", w.Code, w.Body.String())
+	}
+
+	// wildcards still get plain JSON...
+	if w, _ = get("book/1", "*/*"); w.Header().Get(httpHeaderContentType) != httpHeaderContentTypeJSON {
+		t.Error("3840213154 expected JSON for */*", w.Header())
+	}
+
+	// ...unless JSON:API is the router's default
+	router.SetDefaultEncoder(JSONAPIEncoder{})
+	if len(router.Encoders) != 5 {
+		t.Error("3840213155 expected the encoder to move, not be added twice", router.Encoders)
+	}
+	for _, accept := range []string{"", "*/*"} {
+		if w, _ = get("book/1", accept); w.Header().Get(httpHeaderContentType) != httpHeaderContentTypeJSONAPI {
+			t.Error("3840213156 expected JSON:API by default", accept, w.Header())
+		}
+	}
+	if w, _ = get("book/1", "application/json"); w.Header().Get(httpHeaderContentType) != httpHeaderContentTypeJSON {
+		t.Error("3840213157 expected JSON when asked for", w.Header())
+	}
+
+	// routing errors too, alerts go in meta
+	w, doc = get("nope/1", "")
+	if errors, isList := doc["errors"].([]interface{}); w.Code != http.StatusNotFound || isList == false || len(errors) != 1 {
+		t.Error("3840213158 expected a not found error document", w.Code, w.Body.String())
+	}
+	encoded, _ := JSONAPIEncoder{}.Encode(&PayloadWrapper{Alert: "maintenance at noon"})
+	if string(encoded) != `{"data":[],"meta":{"alert":"maintenance at noon"}}` {
+		t.Error("3840213159 unexpected alert document", string(encoded))
+	}
+}
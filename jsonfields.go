@@ -0,0 +1,56 @@
+package grunway
+
+import (
+	"reflect"
+	"strings"
+)
+
+// jsonField is a struct field as encoding/json sees it.  Used by the OpenAPI and TypeScript generators.
+type jsonField struct {
+	Name      string
+	Type      reflect.Type
+	OmitEmpty bool
+	AsString  bool // the ",string" option
+}
+
+// structJSONFields follows the encoding/json rules for names, omitempty, "-", unexported and embedded structs.
+// Fields are in declaration order, w/ embedded fields inlined where they appear.
+func structJSONFields(t reflect.Type) []jsonField {
+	fields := []jsonField{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		tagName, tagOptions := tag, ""
+		if comma := strings.Index(tag, ","); comma >= 0 {
+			tagName, tagOptions = tag[:comma], tag[comma:]
+		}
+
+		fieldType := field.Type
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous && tagName == "" && fieldType.Kind() == reflect.Struct {
+			fields = append(fields, structJSONFields(fieldType)...)
+			continue
+		}
+		if field.PkgPath != "" {
+			// unexported
+			continue
+		}
+
+		name := field.Name
+		if tagName != "" {
+			name = tagName
+		}
+		fields = append(fields, jsonField{
+			Name:      name,
+			Type:      field.Type,
+			OmitEmpty: strings.Contains(tagOptions, ",omitempty"),
+			AsString:  strings.Contains(tagOptions, ",string"),
+		})
+	}
+	return fields
+}
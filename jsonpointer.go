@@ -0,0 +1,147 @@
+package grunway
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// JSON pointers (RFC 6901) w/ one extension: a "*" segment matches every key of an object or element of an array,
+// eg "/Payloads/book/*/Updated".
+
+const jsonPointerWildcard = "*"
+
+func splitJSONPointer(pointer string) []string {
+	if pointer == "" || pointer == "/" {
+		return []string{}
+	}
+	segments := strings.Split(strings.TrimPrefix(pointer, "/"), "/")
+	for i, segment := range segments {
+		segments[i] = strings.Replace(strings.Replace(segment, "~1", "/", -1), "~0", "~", -1)
+	}
+	return segments
+}
+
+func escapeJSONPointerSegment(segment string) string {
+	return strings.Replace(strings.Replace(segment, "~", "~0", -1), "/", "~1", -1)
+}
+
+// rewriteJSONPointer calls fn for every value pointer matches and stores what fn returns.
+// if fn returns remove, object keys are deleted and array elements are set to nil (so indexes don't shift).
+func rewriteJSONPointer(node interface{}, segments []string, fn func(value interface{}) (newValue interface{}, remove bool)) interface{} {
+	if len(segments) == 0 {
+		newValue, _ := fn(node)
+		return newValue
+	}
+	segment, rest := segments[0], segments[1:]
+
+	switch typed := node.(type) {
+	case map[string]interface{}:
+		keys := []string{segment}
+		if segment == jsonPointerWildcard {
+			keys = make([]string, 0, len(typed))
+			for key := range typed {
+				keys = append(keys, key)
+			}
+		}
+		for _, key := range keys {
+			value, exists := typed[key]
+			if exists == false {
+				continue
+			}
+			if len(rest) == 0 {
+				newValue, remove := fn(value)
+				if remove {
+					delete(typed, key)
+				} else {
+					typed[key] = newValue
+				}
+				continue
+			}
+			typed[key] = rewriteJSONPointer(value, rest, fn)
+		}
+	case []interface{}:
+		indexes := []int{}
+		if segment == jsonPointerWildcard {
+			for i := range typed {
+				indexes = append(indexes, i)
+			}
+		} else if index, err := strconv.Atoi(segment); err == nil && index >= 0 && index < len(typed) {
+			indexes = append(indexes, index)
+		}
+		for _, index := range indexes {
+			if len(rest) == 0 {
+				newValue, remove := fn(typed[index])
+				if remove {
+					newValue = nil
+				}
+				typed[index] = newValue
+				continue
+			}
+			typed[index] = rewriteJSONPointer(typed[index], rest, fn)
+		}
+	}
+	return node
+}
+
+// decodeJSONDocument keeps numbers as json.Number, so int64 error numbers and keys compare exactly.
+func decodeJSONDocument(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var doc interface{}
+	err := decoder.Decode(&doc)
+	return doc, err
+}
+
+// jsonDifferences returns the pointers where expected and actual differ, at most max of them.
+func jsonDifferences(pointer string, expected, actual interface{}, max int) []string {
+	differences := []string{}
+	var walk func(pointer string, expected, actual interface{})
+	walk = func(pointer string, expected, actual interface{}) {
+		if len(differences) >= max {
+			return
+		}
+		expectedMap, expectedIsMap := expected.(map[string]interface{})
+		actualMap, actualIsMap := actual.(map[string]interface{})
+		if expectedIsMap && actualIsMap {
+			keys := map[string]bool{}
+			for key := range expectedMap {
+				keys[key] = true
+			}
+			for key := range actualMap {
+				keys[key] = true
+			}
+			sortedKeys := make([]string, 0, len(keys))
+			for key := range keys {
+				sortedKeys = append(sortedKeys, key)
+			}
+			sort.Strings(sortedKeys)
+			for _, key := range sortedKeys {
+				walk(pointer+"/"+escapeJSONPointerSegment(key), expectedMap[key], actualMap[key])
+			}
+			return
+		}
+
+		expectedList, expectedIsList := expected.([]interface{})
+		actualList, actualIsList := actual.([]interface{})
+		if expectedIsList && actualIsList && len(expectedList) == len(actualList) {
+			for i := range expectedList {
+				walk(fmt.Sprintf("%s/%d", pointer, i), expectedList[i], actualList[i])
+			}
+			return
+		}
+
+		if reflect.DeepEqual(expected, actual) == false {
+			if pointer == "" {
+				pointer = "/"
+			}
+			differences = append(differences, pointer)
+		}
+	}
+	walk(pointer, expected, actual)
+	return differences
+}
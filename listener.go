@@ -0,0 +1,251 @@
+package grunway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultUnixSocketMode is used by ListenUnix when mode is 0.  Owner and group (eg the reverse proxy) can connect.
+const DefaultUnixSocketMode os.FileMode = 0660
+
+// the first fd passed by socket activation supervisors (systemd, s6, etc.).  0, 1 and 2 are stdio.
+const listenFDsStart = 3
+
+// #     #
+// #     # #    # #  #    #
+// #     # ##   # #   #  #
+// #     # # #  # #    ##
+// #     # #  # # #    ##
+// #     # #   ## #   #  #
+//  #####  #    # #  #    #
+//
+
+// ListenUnix listens on a unix domain socket at path and chmods it to mode (DefaultUnixSocketMode if 0).
+//
+// If something already exists at path:
+//   - a socket that refuses connections (left behind by a crash) is removed
+//   - a socket that is still accepting connections is an error, another process owns it
+//   - a socket we can't tell about (eg busy, or not ours to connect to) is an error, and is left alone
+//   - anything that isn't a socket is an error, we never delete regular files
+//
+// The socket is bound in a private (0700) directory next to path, chmod'ed, and only then moved to path,
+// so it is never reachable w/ the umask's permissions.  The socket file is removed when the listener is closed.
+func ListenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if mode == 0 {
+		mode = DefaultUnixSocketMode
+	}
+
+	err := removeStaleUnixSocket(path)
+	if err != nil {
+		return nil, err
+	}
+
+	privateDir, err := ioutil.TempDir(filepath.Dir(path), ".grunway")
+	if err != nil {
+		return nil, fmt.Errorf("4186620707 cannot create a directory for unix socket %s: %v", path, err)
+	}
+	defer os.RemoveAll(privateDir)
+	privatePath := filepath.Join(privateDir, "s")
+
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: privatePath, Net: "unix"})
+	if err != nil {
+		return nil, fmt.Errorf("4186620701 net.Listen unix returned error: %v", err)
+	}
+	// removing the socket is up to unixSocketListener, it won't be at privatePath
+	listener.SetUnlinkOnClose(false)
+
+	err = os.Chmod(privatePath, mode)
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("4186620702 cannot chmod unix socket %s: %v", path, err)
+	}
+	err = os.Rename(privatePath, path)
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("4186620708 cannot move unix socket into place at %s: %v", path, err)
+	}
+	return &unixSocketListener{UnixListener: listener, path: path}, nil
+}
+
+// a UnixListener that was bound somewhere else and then moved to path
+type unixSocketListener struct {
+	*net.UnixListener
+	path string
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func (listener *unixSocketListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: listener.path, Net: "unix"}
+}
+
+func (listener *unixSocketListener) Close() error {
+	listener.closeOnce.Do(func() {
+		os.Remove(listener.path)
+		listener.closeErr = listener.UnixListener.Close()
+	})
+	return listener.closeErr
+}
+
+func removeStaleUnixSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("4186620703 cannot stat unix socket path %s: %v", path, err)
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("4186620704 %s exists and is not a socket", path)
+	}
+
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("4186620705 unix socket %s is already in use", path)
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		// removed in the meantime
+		return nil
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) == false {
+		// eg EAGAIN from a full backlog, EACCES or a timeout.  someone may well still be listening.
+		return fmt.Errorf("4186620709 cannot tell whether unix socket %s is stale, not removing it: %v", path, err)
+	}
+
+	err = os.Remove(path)
+	if err != nil && os.IsNotExist(err) == false {
+		return fmt.Errorf("4186620706 cannot remove stale unix socket %s: %v", path, err)
+	}
+	return nil
+}
+
+// ###
+//  #  #    # #    # ###### #####  # ##### ###### #####
+//  #  ##   # #    # #      #    # #   #   #      #    #
+//  #  # #  # ###### #####  #    # #   #   #####  #    #
+//  #  #  # # #    # #      #####  #   #   #      #    #
+//  #  #   ## #    # #      #   #  #   #   #      #    #
+// ### #    # #    # ###### #    # #   #   ###### #####
+//
+
+// ListenerFromFD wraps an already bound and listening socket fd, eg one passed by a supervisor on exec.
+// The fd is dup'ed (close-on-exec), so the original is closed and the returned listener owns the socket.
+func ListenerFromFD(fd uintptr, name string) (net.Listener, error) {
+	file := os.NewFile(fd, name)
+	if file == nil {
+		return nil, fmt.Errorf("4186620710 invalid fd %d", fd)
+	}
+	defer file.Close()
+
+	listener, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("4186620711 fd %d (%s) is not a listening socket: %v", fd, name, err)
+	}
+	return listener, nil
+}
+
+// InheritedListeners returns the listeners passed via the socket activation protocol
+// (LISTEN_PID, LISTEN_FDS and optionally LISTEN_FDNAMES, starting at fd 3).
+//
+// Returns an empty slice if no fds were passed to this process.
+// The environment variables are unset so child processes don't also try to use the fds.
+func InheritedListeners() ([]net.Listener, error) {
+	listenPID := os.Getenv("LISTEN_PID")
+	listenFDs := os.Getenv("LISTEN_FDS")
+	listenFDNames := os.Getenv("LISTEN_FDNAMES")
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	if listenFDs == "" {
+		return []net.Listener{}, nil
+	}
+	// LISTEN_PID is optional for supervisors that don't know our pid before exec
+	if listenPID != "" {
+		pid, err := strconv.Atoi(listenPID)
+		if err != nil {
+			return nil, fmt.Errorf("4186620720 invalid LISTEN_PID %q", listenPID)
+		}
+		if pid != os.Getpid() {
+			// meant for some other process
+			return []net.Listener{}, nil
+		}
+	}
+
+	count, err := strconv.Atoi(listenFDs)
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("4186620721 invalid LISTEN_FDS %q", listenFDs)
+	}
+
+	var names []string
+	if listenFDNames != "" {
+		names = strings.Split(listenFDNames, ":")
+	}
+
+	listeners := make([]net.Listener, 0, count)
+	for i := 0; i < count; i++ {
+		name := "LISTEN_FD_" + strconv.Itoa(listenFDsStart+i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		listener, err := ListenerFromFD(uintptr(listenFDsStart+i), name)
+		if err != nil {
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+//  #####
+// #     # ###### #####  #    # ######
+// #       #      #    # #    # #
+//  #####  #####  #    # #    # #####
+//       # #      #####  #    # #
+// #     # #      #   #   #  #  #
+//  #####  ###### #    #   ##   ######
+//
+
+// ListenAndServeUnix listens on a unix domain socket (see ListenUnix) and then calls Serve.
+func (server *Server) ListenAndServeUnix(ctx context.Context, path string, mode os.FileMode) error {
+	if err := validateRouterForStart(server.Router); err != nil {
+		return err
+	}
+	listener, err := ListenUnix(path, mode)
+	if err != nil {
+		return err
+	}
+	return server.Serve(ctx, listener)
+}
+
+// ServeInherited serves on all listeners passed by a socket activation supervisor (see InheritedListeners).
+func (server *Server) ServeInherited(ctx context.Context) error {
+	if err := validateRouterForStart(server.Router); err != nil {
+		return err
+	}
+	listeners, err := InheritedListeners()
+	if err != nil {
+		return err
+	}
+	if len(listeners) == 0 {
+		return fmt.Errorf("4186620730 no inherited listeners (LISTEN_FDS not set for this process)")
+	}
+	return server.ServeListeners(ctx, listeners...)
+}
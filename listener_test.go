@@ -0,0 +1,146 @@
+package grunway
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type PingController struct{}
+
+func (pc *PingController) GetHandlerV1(ctx *Context) RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultOk()
+}
+
+func TestServeUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grunway_unix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socketPath := filepath.Join(dir, "grunway.sock")
+
+	// a stale socket from a previous run: bound, then closed without unlinking
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: socketPath, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+
+	router := NewRouter()
+	router.BasePath = "/api/"
+	router.PostProcessors = nil
+	router.RegisterEntity("ping", &PingController{})
+
+	options := DefaultServerOptions()
+	options.DisableSignalHandling = true
+	server := NewServer(router, options)
+
+	listener, err := ListenUnix(socketPath, 0600)
+	if err != nil {
+		t.Fatal("4186620790 stale socket should have been removed:", err)
+	}
+	info, err := os.Stat(socketPath)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Error("4186620791 expected mode 0600, got", info, err)
+	}
+	// bound elsewhere and moved into place, w/o leaving anything behind
+	if entries, _ := ioutil.ReadDir(dir); len(entries) != 1 || listener.Addr().String() != socketPath {
+		t.Error("4186620780 unexpected socket dir or address", len(entries), listener.Addr())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(ctx, listener) }()
+
+	// a live socket must not be stolen
+	if _, err := ListenUnix(socketPath, 0); err == nil {
+		t.Error("4186620792 expected error for a socket that is in use")
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return net.Dial("unix", socketPath)
+		},
+	}}
+	response, err := client.Get("http://unix/api/v1/ping/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Error("4186620793 expected 200, got", response.StatusCode)
+	}
+
+	cancel()
+	if err := <-served; err != nil {
+		t.Error("4186620794 expected clean shutdown, got", err)
+	}
+	if _, err := os.Stat(socketPath); os.IsNotExist(err) == false {
+		t.Error("4186620795 socket file should be removed on shutdown", err)
+	}
+}
+
+// only a refused connection means stale, anything else leaves the socket alone
+func TestListenUnixKeepsUnknownSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grunway_unix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socketPath := filepath.Join(dir, "grunway.sock")
+
+	// a live datagram socket: dialing it as a stream fails, but not w/ ECONNREFUSED
+	datagram, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer datagram.Close()
+
+	if listener, err := ListenUnix(socketPath, 0); err == nil {
+		listener.Close()
+		t.Error("4186620781 expected an error for a socket that isn't refusing connections")
+	}
+	if _, err := os.Lstat(socketPath); err != nil {
+		t.Error("4186620782 socket should not be removed", err)
+	}
+}
+
+func TestListenUnixRefusesRegularFile(t *testing.T) {
+	file, err := ioutil.TempFile("", "grunway_notasocket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+
+	if _, err := ListenUnix(file.Name(), 0); err == nil {
+		t.Error("4186620796 expected error for a regular file")
+	}
+	if _, err := os.Stat(file.Name()); err != nil {
+		t.Error("4186620797 regular file should not be removed", err)
+	}
+}
+
+func TestInheritedListenersNotSet(t *testing.T) {
+	os.Unsetenv("LISTEN_FDS")
+	listeners, err := InheritedListeners()
+	if err != nil || len(listeners) != 0 {
+		t.Error("4186620798 expected no listeners", listeners, err)
+	}
+
+	os.Setenv("LISTEN_PID", "1")
+	os.Setenv("LISTEN_FDS", "1")
+	listeners, err = InheritedListeners()
+	if err != nil || len(listeners) != 0 {
+		t.Error("4186620799 fds for another pid should be ignored", listeners, err)
+	}
+	if os.Getenv("LISTEN_FDS") != "" {
+		t.Error("4186620800 LISTEN_FDS should be unset")
+	}
+}
@@ -0,0 +1,76 @@
+package grunway
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amattn/deeperror"
+)
+
+// Mailer delivers plain text email.  Wrap your SMTP server or mail service of choice.
+type Mailer interface {
+	SendMail(to, subject, body string) error
+}
+
+// LogMailer just logs every message.  Handy for local development.
+type LogMailer struct {
+	Logger *log.Logger // optional, defaults to the standard logger
+}
+
+func (mailer *LogMailer) SendMail(to, subject, body string) error {
+	msg := fmt.Sprintf("LogMailer To: %s Subject: %s\n%s", to, subject, body)
+	if mailer.Logger != nil {
+		mailer.Logger.Println(msg)
+	} else {
+		log.Println(msg)
+	}
+	return nil
+}
+
+// FileMailer writes each message to its own .eml file in Dir.  Handy for local testing.
+type FileMailer struct {
+	Dir string
+
+	mu    sync.Mutex
+	count int
+}
+
+func NewFileMailer(dir string) *FileMailer {
+	mailer := new(FileMailer)
+	mailer.Dir = dir
+	return mailer
+}
+
+func (mailer *FileMailer) SendMail(to, subject, body string) error {
+	mailer.mu.Lock()
+	mailer.count++
+	count := mailer.count
+	mailer.mu.Unlock()
+
+	err := os.MkdirAll(mailer.Dir, 0700)
+	if err != nil {
+		return deeperror.New(3137460221, "Cannot create mail directory", err)
+	}
+
+	now := time.Now().UTC()
+	filename := fmt.Sprintf("%s-%06d.eml", now.Format("20060102T150405.000000000"), count)
+	message := strings.Join([]string{
+		"To: " + to,
+		"Subject: " + subject,
+		"Date: " + now.Format(time.RFC1123Z),
+		"",
+		body,
+	}, "\r\n")
+
+	err = ioutil.WriteFile(filepath.Join(mailer.Dir, filename), []byte(message), 0600)
+	if err != nil {
+		return deeperror.New(3137460222, "Cannot write mail file", err)
+	}
+	return nil
+}
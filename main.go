@@ -1,30 +1,41 @@
 package grunway
 
 import (
-	"fmt"
-	"log"
-	"net/http"
-	"runtime"
-	"time"
+	"context"
+	"net"
+	"os"
 )
 
+// Start serves routerPtr on host with DefaultServerOptions, until SIGINT or SIGTERM.
+// For timeouts, TLS, or shutdown hooks, use NewServer directly.
 func Start(routerPtr *Router, host string) error {
-	log.Printf("Starting Grunway Server (%v, grunway v%v(%v))", runtime.Version(), Version(), BuildNumber())
-
-	if routerPtr.AllRoutesCount() == 0 {
-		return fmt.Errorf("3621140790 Router has no valid routes defined")
-	}
+	server := NewServer(routerPtr, DefaultServerOptions())
+	return server.ListenAndServe(context.Background(), host)
+}
 
-	log.Println("All Routes:")
-	routerPtr.LogAllRoutes("↳ Route:")
+// StartTLS is Start over HTTPS.  The cert and key files are reloaded when they change on disk.
+func StartTLS(routerPtr *Router, host, certFile, keyFile string) error {
+	options := DefaultServerOptions()
+	options.TLS = &TLSOptions{CertFile: certFile, KeyFile: keyFile}
+	server := NewServer(routerPtr, options)
+	return server.ListenAndServe(context.Background(), host)
+}
 
-	log.Println("Listening from ", host)
-	time.Sleep(100 * time.Millisecond) // give the log statements time to print...
+// StartListener is Start on a listener you have already opened.  The listener is closed on shutdown.
+func StartListener(routerPtr *Router, listener net.Listener) error {
+	server := NewServer(routerPtr, DefaultServerOptions())
+	return server.Serve(context.Background(), listener)
+}
 
-	err := http.ListenAndServe(host, routerPtr)
-	if err != nil {
-		return fmt.Errorf("3621140792 http.ListenAndServe returned error:", err)
-	}
+// StartUnix is Start on a unix domain socket.  mode 0 means DefaultUnixSocketMode.
+// A stale socket left behind by a previous run is removed.
+func StartUnix(routerPtr *Router, socketPath string, mode os.FileMode) error {
+	server := NewServer(routerPtr, DefaultServerOptions())
+	return server.ListenAndServeUnix(context.Background(), socketPath, mode)
+}
 
-	return nil
+// StartInherited is Start on the listener fds passed by a socket activation supervisor (LISTEN_FDS).
+func StartInherited(routerPtr *Router) error {
+	server := NewServer(routerPtr, DefaultServerOptions())
+	return server.ServeInherited(context.Background())
 }
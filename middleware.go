@@ -2,11 +2,14 @@ package grunway
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/amattn/deeperror"
+	"github.com/amattn/grunway/internal/testhooks"
 )
 
 type MiddlewareProcessor interface {
@@ -18,14 +21,23 @@ type PostProcessor interface {
 }
 
 type CommonLogger struct {
+	Output io.Writer // optional, defaults to os.Stdout
 }
 
 func (logger *CommonLogger) Process(ctx *Context) (terminateEarly bool, derr *deeperror.DeepError) {
-	commonLogFormat(ctx)
+	if ctx.excludedFromAccessLog {
+		return false, nil
+	}
+	output := logger.Output
+	if override := testhooks.AccessLogOutput(ctx.Req); override != nil {
+		// grunwaytest collects the access log per harness
+		output = override
+	}
+	commonLogFormat(ctx, output)
 	return false, nil
 }
 
-func commonLogFormat(ctx *Context) {
+func commonLogFormat(ctx *Context, output io.Writer) {
 	// http://en.wikipedia.org/wiki/Common_Log_Format
 	// example 127.0.0.1 user-identifier frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326
 
@@ -45,6 +57,9 @@ func commonLogFormat(ctx *Context) {
 		strconv.FormatInt(int64(ctx.ContentLength), 10),
 		"\n",
 	}
-	fmt.Print(strings.Join(common_log_format_parts, " "))
+	if output == nil {
+		output = os.Stdout
+	}
+	fmt.Fprint(output, strings.Join(common_log_format_parts, " "))
 
 }
@@ -0,0 +1,493 @@
+package grunway
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	OpenAPIVersion                = "3.0.3"
+	DefaultOpenAPIEntityName      = "docs"
+	openAPISignatureSchemeName    = "grunwaySignature"
+	openAPIPayloadWrapperSchema   = "PayloadWrapper"
+	openAPIComponentSchemasPrefix = "#/components/schemas/"
+)
+
+// HandlerDoc describes a handler for OpenAPI generation.
+// Payload values are only used for their type, zero values are fine:
+//
+//	"PostHandlerV1": {Summary: "Create a book", Request: BookPayload{}, Responses: []Payload{BookPayload{}}}
+type HandlerDoc struct {
+	Summary     string
+	Description string
+
+	Request   Payload   // optional, the type decoded from the request body
+	Responses []Payload // optional, the types that can appear in the Payloads of a successful response
+
+	Errors []HandlerErrorDoc // optional, the error responses this handler can return
+}
+
+type HandlerErrorDoc struct {
+	StatusCode  int
+	ErrorNumber int64
+	Description string
+}
+
+// Controllers can implement RouteDocumenter to add detail to the generated OpenAPI document.
+// The key is the handler method name, eg "AuthGetHandlerV1".
+// (The method is deliberately not named HandlerDocs, RegisterEntity treats any method containing "Handler" as a route.)
+type RouteDocumenter interface {
+	RouteDocs() map[string]HandlerDoc
+}
+
+type OpenAPIInfo struct {
+	Title       string
+	Version     string
+	Description string
+	Servers     []string // optional, eg "https://api.example.com"
+}
+
+// A subset of the OpenAPI 3 object model, enough to describe grunway routes.
+
+type OpenAPIDocument struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       OpenAPIDocumentInfo                     `json:"info"`
+	Servers    []OpenAPIServer                         `json:"servers,omitempty"`
+	Paths      map[string]map[string]*OpenAPIOperation `json:"paths"`
+	Components OpenAPIComponents                       `json:"components"`
+}
+
+type OpenAPIDocumentInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type OpenAPIServer struct {
+	URL string `json:"url"`
+}
+
+type OpenAPIOperation struct {
+	OperationID string                      `json:"operationId"`
+	Summary     string                      `json:"summary,omitempty"`
+	Description string                      `json:"description,omitempty"`
+	Tags        []string                    `json:"tags,omitempty"`
+	Deprecated  bool                        `json:"deprecated,omitempty"`
+	Parameters  []OpenAPIParameter          `json:"parameters,omitempty"`
+	RequestBody *OpenAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*OpenAPIResponse `json:"responses"`
+	Security    []map[string][]string       `json:"security,omitempty"`
+}
+
+type OpenAPIParameter struct {
+	Name     string        `json:"name"`
+	In       string        `json:"in"`
+	Required bool          `json:"required"`
+	Schema   OpenAPISchema `json:"schema"`
+}
+
+type OpenAPIRequestBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]OpenAPIMediaType `json:"content"`
+}
+
+type OpenAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]OpenAPIMediaType `json:"content,omitempty"`
+}
+
+type OpenAPIMediaType struct {
+	Schema OpenAPISchema `json:"schema"`
+}
+
+type OpenAPIComponents struct {
+	Schemas         map[string]OpenAPISchema         `json:"schemas"`
+	SecuritySchemes map[string]OpenAPISecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type OpenAPISecurityScheme struct {
+	Type        string `json:"type"`
+	In          string `json:"in,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// OpenAPISchema is a JSON Schema object, kept loose since the schema vocabulary is large.
+type OpenAPISchema map[string]interface{}
+
+//  #####
+// #     # ###### #    # ###### #####    ##   ##### ######
+// #       #      ##   # #      #    #  #  #    #   #
+// #  #### #####  # #  # #####  #    # #    #   #   #####
+// #     # #      #  # # #      #####  ######   #   #
+// #     # #      #   ## #      #   #  #    #   #   #
+//  #####  ###### #    # ###### #    # #    #   #   ######
+//
+
+// OpenAPI generates an OpenAPI 3 document describing every registered route.
+// Successful responses are described as the PayloadWrapper envelope, with typed Payloads arrays
+// if the controller documents its handlers (see RouteDocumenter).
+func (router *Router) OpenAPI(info OpenAPIInfo) *OpenAPIDocument {
+	doc := new(OpenAPIDocument)
+	doc.OpenAPI = OpenAPIVersion
+	doc.Info = OpenAPIDocumentInfo{Title: info.Title, Version: info.Version, Description: info.Description}
+	if doc.Info.Title == "" {
+		doc.Info.Title = "grunway API"
+	}
+	if doc.Info.Version == "" {
+		doc.Info.Version = "1"
+	}
+	for _, server := range info.Servers {
+		doc.Servers = append(doc.Servers, OpenAPIServer{URL: server})
+	}
+	doc.Paths = make(map[string]map[string]*OpenAPIOperation)
+
+	schemas := newOpenAPISchemaBuilder()
+	schemas.components[openAPIPayloadWrapperSchema] = payloadWrapperSchema()
+
+	usesAuth := false
+	for _, routeInfo := range router.AllRoutes() {
+		controller := router.Controllers[routeInfo.EntityName]
+		if _, isDocsController := controller.(*OpenAPIController); isDocsController {
+			// serves raw JSON, not a PayloadWrapper
+			continue
+		}
+		if routeInfo.WebSocket {
+			// not request/response
+			continue
+		}
+
+		if routeInfo.RequiresAuth {
+			usesAuth = true
+		}
+
+		// action routes are described twice, w/ and w/o the optional id
+		pathTemplates := []string{routeInfo.PathTemplate}
+		if routeInfo.KeyedPathTemplate != "" {
+			pathTemplates = append(pathTemplates, routeInfo.KeyedPathTemplate)
+		}
+		for _, pathTemplate := range pathTemplates {
+			operation := makeOpenAPIOperation(routeInfo, pathTemplate, router.handlerDoc(routeInfo), schemas)
+			pathItem, exists := doc.Paths[pathTemplate]
+			if exists == false {
+				pathItem = make(map[string]*OpenAPIOperation)
+				doc.Paths[pathTemplate] = pathItem
+			}
+			pathItem[strings.ToLower(routeInfo.Method)] = operation
+		}
+	}
+
+	doc.Components.Schemas = schemas.components
+	if usesAuth {
+		doc.Components.SecuritySchemes = map[string]OpenAPISecurityScheme{
+			openAPISignatureSchemeName: {
+				Type:        "apiKey",
+				In:          "header",
+				Name:        HTTPHeaderPublicKey,
+				Description: fmt.Sprintf("Requests are signed w/ HMAC-SHA256, see the %s and %s headers.  Other AuthHandlers may use different schemes.", HTTPHeaderTimestamp, HTTPHeaderSignature),
+			},
+		}
+	}
+	return doc
+}
+
+// the controller's RouteDocs entry for the route, or a zero HandlerDoc
+func (router *Router) handlerDoc(routeInfo RouteInfo) HandlerDoc {
+	if documenter, ok := router.Controllers[routeInfo.EntityName].(RouteDocumenter); ok {
+		return documenter.RouteDocs()[routeInfo.Handler]
+	}
+	return HandlerDoc{}
+}
+
+// pathTemplate is routeInfo's PathTemplate or KeyedPathTemplate
+func makeOpenAPIOperation(routeInfo RouteInfo, pathTemplate string, handlerDoc HandlerDoc, schemas *openAPISchemaBuilder) *OpenAPIOperation {
+	operation := new(OpenAPIOperation)
+	operation.OperationID = routeInfo.EntityName + routeInfo.Handler
+	if pathTemplate == routeInfo.KeyedPathTemplate {
+		// operation ids are unique per document
+		operation.OperationID += "WithID"
+	}
+	operation.Summary = handlerDoc.Summary
+	operation.Description = handlerDoc.Description
+	operation.Tags = []string{routeInfo.EntityName}
+	operation.Deprecated = routeInfo.Deprecated
+	operation.Responses = make(map[string]*OpenAPIResponse)
+
+	if strings.Contains(pathTemplate, "{id}") {
+		operation.Parameters = append(operation.Parameters, OpenAPIParameter{
+			Name:     "id",
+			In:       "path",
+			Required: true,
+			Schema:   OpenAPISchema{"type": "integer", "format": "int64"},
+		})
+	}
+
+	if handlerDoc.Request != nil {
+		operation.RequestBody = &OpenAPIRequestBody{
+			Required: true,
+			Content: map[string]OpenAPIMediaType{
+				httpHeaderContentTypeJSON: {Schema: schemas.ref(reflect.TypeOf(handlerDoc.Request))},
+			},
+		}
+	}
+
+	operation.Responses["200"] = &OpenAPIResponse{
+		Description: "OK",
+		Content: map[string]OpenAPIMediaType{
+			httpHeaderContentTypeJSON: {Schema: successEnvelopeSchema(handlerDoc.Responses, schemas)},
+		},
+	}
+
+	// errors every route can return, then the documented ones
+	errorDocs := []HandlerErrorDoc{{StatusCode: http.StatusNotFound, ErrorNumber: NotFoundErrorNumber}}
+	if routeInfo.RequiresPrimaryKey {
+		errorDocs = append(errorDocs, HandlerErrorDoc{StatusCode: http.StatusBadRequest, ErrorNumber: BadRequestMissingPrimaryKeyErrorNumber})
+	}
+	if routeInfo.RequiresAuth {
+		errorDocs = append(errorDocs, HandlerErrorDoc{StatusCode: http.StatusForbidden, Description: "auth failed"})
+		operation.Security = []map[string][]string{{openAPISignatureSchemeName: {}}}
+	}
+	errorDocs = append(errorDocs, handlerDoc.Errors...)
+
+	for _, errorDoc := range errorDocs {
+		code := fmt.Sprintf("%d", errorDoc.StatusCode)
+		response, exists := operation.Responses[code]
+		if exists == false {
+			response = &OpenAPIResponse{
+				Description: http.StatusText(errorDoc.StatusCode),
+				Content: map[string]OpenAPIMediaType{
+					httpHeaderContentTypeJSON: {Schema: OpenAPISchema{"$ref": openAPIComponentSchemasPrefix + openAPIPayloadWrapperSchema}},
+				},
+			}
+			operation.Responses[code] = response
+		}
+		line := errorDoc.Description
+		if errorDoc.ErrorNumber != 0 {
+			line = strings.TrimSpace(fmt.Sprintf("errorNumber %d %s", errorDoc.ErrorNumber, errorDoc.Description))
+		}
+		if line != "" {
+			response.Description += "\n\n- " + line
+		}
+	}
+	return operation
+}
+
+// the envelope, w/ Payloads narrowed to the documented types
+func successEnvelopeSchema(responses []Payload, schemas *openAPISchemaBuilder) OpenAPISchema {
+	base := OpenAPISchema{"$ref": openAPIComponentSchemasPrefix + openAPIPayloadWrapperSchema}
+	if len(responses) == 0 {
+		return base
+	}
+
+	payloadProperties := OpenAPISchema{}
+	for _, payload := range responses {
+		payloadProperties[payload.PayloadType()] = OpenAPISchema{
+			"type":  "array",
+			"items": schemas.ref(reflect.TypeOf(payload)),
+		}
+	}
+	return OpenAPISchema{
+		"allOf": []OpenAPISchema{
+			base,
+			{
+				"type": "object",
+				"properties": OpenAPISchema{
+					"Payloads": OpenAPISchema{"type": "object", "properties": payloadProperties},
+				},
+			},
+		},
+	}
+}
+
+// mirrors the json encoding of PayloadWrapper, which has a polymorphic Payloads map
+func payloadWrapperSchema() OpenAPISchema {
+	return OpenAPISchema{
+		"type":        "object",
+		"description": "Every grunway response is wrapped in this envelope.  Payloads is keyed by PayloadType.",
+		"properties": OpenAPISchema{
+			"Payloads": OpenAPISchema{
+				"type":                 "object",
+				"additionalProperties": OpenAPISchema{"type": "array", "items": OpenAPISchema{"type": "object"}},
+			},
+			"errorNumber":  OpenAPISchema{"type": "integer", "format": "int64", "description": "0 or absent on success"},
+			"errorMessage": OpenAPISchema{"type": "string", "description": "end-user appropriate error message"},
+			"debugNumber":  OpenAPISchema{"type": "integer", "format": "int64"},
+			"debugMessage": OpenAPISchema{"type": "string"},
+			"Alert":        OpenAPISchema{"type": "string", "description": "eg maintenance mode, required update"},
+			"Meta":         OpenAPISchema{"type": "object", "description": "about the response, eg total counts or request ids"},
+			"Links": OpenAPISchema{
+				"type": "object",
+				"properties": OpenAPISchema{
+					"self": OpenAPISchema{"type": "string"},
+					"next": OpenAPISchema{"type": "string"},
+					"prev": OpenAPISchema{"type": "string"},
+				},
+			},
+		},
+	}
+}
+
+//  #####
+// #     #  ####  #    # ###### #    #   ##    ####
+// #       #    # #    # #      ##  ##  #  #  #
+//  #####  #      ###### #####  # ## # #    #  ####
+//       # #      #    # #      #    # ######      #
+// #     # #    # #    # #      #    # #    # #    #
+//  #####   ####  #    # ###### #    # #    #  ####
+//
+
+var (
+	timeType           = reflect.TypeOf(time.Time{})
+	jsonRawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+type openAPISchemaBuilder struct {
+	components map[string]OpenAPISchema
+	names      map[reflect.Type]string
+}
+
+func newOpenAPISchemaBuilder() *openAPISchemaBuilder {
+	builder := new(openAPISchemaBuilder)
+	builder.components = make(map[string]OpenAPISchema)
+	builder.names = make(map[reflect.Type]string)
+	return builder
+}
+
+// ref returns a $ref for named struct types (adding them to components), an inline schema otherwise.
+func (builder *openAPISchemaBuilder) ref(t reflect.Type) OpenAPISchema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t.Name() == "" || t == timeType {
+		return builder.schema(t)
+	}
+
+	name, exists := builder.names[t]
+	if exists == false {
+		name = builder.componentName(t)
+		builder.names[t] = name
+		builder.components[name] = OpenAPISchema{} // placeholder, in case of recursive types
+		builder.components[name] = builder.schema(t)
+	}
+	return OpenAPISchema{"$ref": openAPIComponentSchemasPrefix + name}
+}
+
+func (builder *openAPISchemaBuilder) componentName(t reflect.Type) string {
+	name := t.Name()
+	if _, taken := builder.components[name]; taken {
+		// same name, different package
+		name = strings.NewReplacer("/", "_", ".", "_").Replace(t.PkgPath() + "." + t.Name())
+	}
+	return name
+}
+
+func (builder *openAPISchemaBuilder) schema(t reflect.Type) OpenAPISchema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return OpenAPISchema{"type": "string", "format": "date-time"}
+	}
+	if t == jsonRawMessageType {
+		return OpenAPISchema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return OpenAPISchema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return OpenAPISchema{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint, reflect.Uint64, reflect.Uintptr:
+		return OpenAPISchema{"type": "integer", "format": "int64"}
+	case reflect.Float32:
+		return OpenAPISchema{"type": "number", "format": "float"}
+	case reflect.Float64:
+		return OpenAPISchema{"type": "number", "format": "double"}
+	case reflect.String:
+		return OpenAPISchema{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return OpenAPISchema{"type": "string", "format": "byte"}
+		}
+		return OpenAPISchema{"type": "array", "items": builder.ref(t.Elem())}
+	case reflect.Map:
+		return OpenAPISchema{"type": "object", "additionalProperties": builder.ref(t.Elem())}
+	case reflect.Struct:
+		return builder.structSchema(t)
+	default:
+		// interfaces, funcs, etc.  anything goes.
+		return OpenAPISchema{}
+	}
+}
+
+func (builder *openAPISchemaBuilder) structSchema(t reflect.Type) OpenAPISchema {
+	properties := OpenAPISchema{}
+	required := []string{}
+	builder.addStructFields(t, properties, &required)
+
+	schema := OpenAPISchema{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		sort.Strings(required)
+		schema["required"] = required
+	}
+	return schema
+}
+
+func (builder *openAPISchemaBuilder) addStructFields(t reflect.Type, properties OpenAPISchema, required *[]string) {
+	for _, field := range structJSONFields(t) {
+		if field.AsString {
+			properties[field.Name] = OpenAPISchema{"type": "string"}
+		} else {
+			properties[field.Name] = builder.ref(field.Type)
+		}
+		if field.OmitEmpty == false && field.Type.Kind() != reflect.Ptr {
+			*required = append(*required, field.Name)
+		}
+	}
+}
+
+// ######
+// #     #  ####   ####   ####
+// #     # #    # #    # #
+// #     # #    # #       ####
+// #     # #    # #           #
+// #     # #    # #    # #    #
+// ######   ####   ####   ####
+//
+
+// OpenAPIController serves the generated document.  Use Router.EnableOpenAPIEndpoint to get one.
+type OpenAPIController struct {
+	Info   OpenAPIInfo
+	router *Router
+}
+
+// EnableOpenAPIEndpoint registers GET /<BasePath>/v1/<entityName>/openapi, which serves the document as plain JSON
+// (not wrapped in a PayloadWrapper, so standard tooling can read it).  entityName "" means DefaultOpenAPIEntityName.
+// The document is regenerated on every request.
+func (router *Router) EnableOpenAPIEndpoint(entityName string, info OpenAPIInfo) *OpenAPIController {
+	if entityName == "" {
+		entityName = DefaultOpenAPIEntityName
+	}
+	controller := new(OpenAPIController)
+	controller.Info = info
+	controller.router = router
+	router.RegisterEntity(entityName, controller)
+	return controller
+}
+
+// always JSON, whatever encoding the client negotiated
+func (controller *OpenAPIController) GetHandlerV1Openapi(ctx *Context) RouteHandlerResult {
+	document, err := json.Marshal(controller.router.OpenAPI(controller.Info))
+	if err != nil {
+		return ctx.MakeRouteHandlerResultError(http.StatusInternalServerError, 3347013490, "Internal Server Error")
+	}
+	return ctx.MakeRouteHandlerResultCustom(func(innerCtx *Context) {
+		innerCtx.written = true
+		writeEncodedBytes(innerCtx, http.StatusOK, httpHeaderContentTypeJSON, document)
+	})
+}
@@ -0,0 +1,135 @@
+package grunway
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+type openAPITestController struct{}
+
+func (controller *openAPITestController) RouteDocs() map[string]HandlerDoc {
+	return map[string]HandlerDoc{
+		"PostHandlerV1": {
+			Summary:   "Create a book",
+			Request:   BookPayload{},
+			Responses: []Payload{BookPayload{}},
+			Errors:    []HandlerErrorDoc{{StatusCode: http.StatusConflict, ErrorNumber: 1234, Description: "duplicate"}},
+		},
+	}
+}
+func (controller *openAPITestController) GetHandlerV1(ctx *Context) RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultOk()
+}
+func (controller *openAPITestController) PostHandlerV1(ctx *Context) RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultOk()
+}
+func (controller *openAPITestController) PostHandlerV1Checkout(ctx *Context) RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultOk()
+}
+
+func TestOpenAPI(t *testing.T) {
+	router := NewRouter()
+	router.BasePath = "/api/"
+	router.PostProcessors = nil
+	router.RegisterEntity("book", &openAPITestController{})
+	router.EnableOpenAPIEndpoint("", OpenAPIInfo{Title: "Books", Version: "2"})
+
+	doc := router.OpenAPI(OpenAPIInfo{Title: "Books"})
+	if doc.OpenAPI != OpenAPIVersion || doc.Info.Title != "Books" {
+		t.Error("3347013501 unexpected header", doc.OpenAPI, doc.Info)
+	}
+	if _, exists := doc.Paths["/api/v1/docs/openapi"]; exists {
+		t.Error("3347013502 the docs route itself should not be described")
+	}
+
+	get := doc.Paths["/api/v1/book/{id}"]["get"]
+	if get == nil || len(get.Parameters) != 1 || get.Parameters[0].Name != "id" {
+		t.Fatal("3347013503 expected GET w/ an id parameter", doc.Paths)
+	}
+	if _, exists := get.Responses["400"]; exists == false {
+		t.Error("3347013504 expected a 400 for the missing primary key")
+	}
+
+	post := doc.Paths["/api/v1/book/"]["post"]
+	if post == nil || post.Summary != "Create a book" || post.RequestBody == nil {
+		t.Fatal("3347013505 expected documented POST", post)
+	}
+	if _, exists := post.Responses["409"]; exists == false {
+		t.Error("3347013506 expected documented 409", post.Responses)
+	}
+
+	// actions, w/ and w/o the optional id
+	checkout := doc.Paths["/api/v1/book/checkout"]["post"]
+	keyedCheckout := doc.Paths["/api/v1/book/{id}/checkout"]["post"]
+	if checkout == nil || len(checkout.Parameters) != 0 || keyedCheckout == nil || len(keyedCheckout.Parameters) != 1 || keyedCheckout.Parameters[0].Name != "id" {
+		t.Fatal("3347013514 expected both action paths, only the keyed one w/ an id parameter", doc.Paths)
+	}
+	if checkout.OperationID == keyedCheckout.OperationID {
+		t.Error("3347013515 operation ids must be unique", checkout.OperationID)
+	}
+
+	bookSchema := doc.Components.Schemas["BookPayload"]
+	properties, _ := bookSchema["properties"].(OpenAPISchema)
+	if reflect.DeepEqual(properties["PKey"], OpenAPISchema{"type": "integer", "format": "int64"}) == false {
+		t.Error("3347013507 unexpected BookPayload schema", bookSchema)
+	}
+	if _, exists := doc.Components.Schemas[openAPIPayloadWrapperSchema]; exists == false {
+		t.Error("3347013508 missing PayloadWrapper schema")
+	}
+
+	// served as plain JSON, whatever the client asks for
+	router.RegisterEncoder(XMLEncoder{})
+	for _, accept := range []string{"", httpHeaderContentTypeJSON, "application/xml", httpHeaderContentTypeEventStream, "application/x-ndjson", "application/vnd.api+json"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://localhost/api/v1/docs/openapi", nil)
+		req.Header.Set("Accept", accept)
+		router.ServeHTTP(w, req)
+		body, _ := ioutil.ReadAll(w.Body)
+		var served map[string]interface{}
+		if err := json.Unmarshal(body, &served); err != nil || served["openapi"] != OpenAPIVersion {
+			t.Error("3347013509 unexpected served document", accept, w.Code, err, string(body))
+		}
+		if w.Header().Get(httpHeaderContentType) != httpHeaderContentTypeJSON {
+			t.Error("3347013489 expected plain JSON for", accept, w.Header())
+		}
+	}
+}
+
+type openAPIRecursive struct {
+	Name     string
+	Children []openAPIRecursive `json:"children,omitempty"`
+	Embedded
+	ignored string
+	Skip    string `json:"-"`
+}
+
+type Embedded struct {
+	Inner int32 `json:"inner"`
+}
+
+func TestOpenAPISchemaBuilder(t *testing.T) {
+	builder := newOpenAPISchemaBuilder()
+	ref := builder.ref(reflect.TypeOf(openAPIRecursive{}))
+	if ref["$ref"] != openAPIComponentSchemasPrefix+"openAPIRecursive" {
+		t.Fatal("3347013510 unexpected ref", ref)
+	}
+	schema := builder.components["openAPIRecursive"]
+	properties := schema["properties"].(OpenAPISchema)
+	for _, name := range []string{"Name", "children", "inner"} {
+		if _, exists := properties[name]; exists == false {
+			t.Error("3347013511 missing property", name, properties)
+		}
+	}
+	for _, name := range []string{"ignored", "Skip", "-", "Embedded"} {
+		if _, exists := properties[name]; exists {
+			t.Error("3347013512 unexpected property", name)
+		}
+	}
+	if reflect.DeepEqual(schema["required"], []string{"Name", "inner"}) == false {
+		t.Error("3347013513 unexpected required", schema["required"])
+	}
+}
@@ -1,11 +1,15 @@
 package grunway
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/amattn/deeperror"
 )
@@ -29,10 +33,20 @@ type ErrorInfo struct {
 
 // This will typically be serialized into a JSON formatted string
 type PayloadWrapper struct {
-	Payloads PayloadsMap `json:",omitempty"` // key is type, value is list of payloads of that type
+	Payloads PayloadsMap `json:",omitempty"` // key is type, value is list of payloads of that type.  must stay the first field, see JSONEncoder.EncodeTo
 
 	ErrorInfo
 	Alert string `json:",omitempty"` // used when the client end user needs to be alerted of something: (eg, maintenance mode, downtime, sercurity, required update, etc.)
+
+	Meta  map[string]interface{} `json:",omitempty"` // optional, about the response rather than any payload: total counts, cursors, request ids, etc.
+	Links *PayloadLinks          `json:",omitempty"` // optional, eg for pagination
+}
+
+// PayloadLinks are absolute or relative URLs.  For paged lists, Next and Prev fetch the neighbouring pages.
+type PayloadLinks struct {
+	Self string `json:"self,omitempty" xml:",omitempty"`
+	Next string `json:"next,omitempty" xml:",omitempty"`
+	Prev string `json:"prev,omitempty" xml:",omitempty"`
 }
 
 //  #####
@@ -137,44 +151,13 @@ func writePayloadWrapper(ctx *Context, code int, payloadWrapper *PayloadWrapper)
 	}
 
 	ctx.written = true
-	ctx.StatusCode = code
-	ctx.SetHeader(httpHeaderContentType, httpHeaderContentTypeJSON)
-
-	// This is the old way.  it doesn't give us status info.
-	// enc := json.NewEncoder(ctx.w)
-	// jsonErr := enc.Encode(payloadWrapper)
-
-	// two ways to get status info, a counting buffer or just encode to bytes first.
-	// counting buffer is more efficient, but I don't need the docs to implement the eoncode to bytes.
-	// consider a counting buffer if memory usage or garbage collection pauses start hurting.
-
-	jsonBytes, jsonErr := MarshallPayloadWrapper(payloadWrapper)
-
-	if jsonErr != nil {
-		derr := deeperror.NewHTTPError(3589720731, "Fatal Internal Output Error", jsonErr, http.StatusInternalServerError)
-		responseWriter, ok := ctx.w.(http.ResponseWriter)
-		if ok {
-			ctx.AddHeader("X-ErrorNum", fmt.Sprintf("%d", derr.Num))
-			ctx.AddHeader("X-ErrorStr", fmt.Sprintf("%s", derr.EndUserMsg))
-			responseWriter.WriteHeader(derr.StatusCode)
-			fmt.Fprintf(responseWriter, "{\"ErrorNumber\":%d,\"ErrorMessage\":%s}", derr.Num, derr.EndUserMsg)
-		}
-		log.Println(derr)
-	} else {
-		// At this point, everything is a-ok...  just write out.
-		if rw, isResponseWriter := ctx.w.(http.ResponseWriter); isResponseWriter {
-			rw.WriteHeader(code)
-			if len(jsonBytes) == 0 {
-				log.Println("jsonBytes", jsonBytes, ctx.Req.URL)
-			}
-			bytesWritten, err := rw.Write(jsonBytes)
-			if err != nil {
-				log.Println("3952513088 WRITE ERROR", err)
-			}
-			ctx.ContentLength = bytesWritten
-		}
-	}
+	ctx.applyResponseSections(payloadWrapper)
+	writeEncoded(ctx, code, payloadWrapper)
+
+	runPostProcessors(ctx)
+}
 
+func runPostProcessors(ctx *Context) {
 	for _, postproc := range ctx.router.PostProcessors {
 		postproc.Process(ctx)
 	}
@@ -207,10 +190,70 @@ type unmarshallingPayloadWrapper struct {
 	Payloads     map[string][]json.RawMessage `json:",omitempty"` // key is type, value is list of payloads of that type
 	ErrorNumber  int64                        `json:",omitempty"` // will be 0 on successful responses, non-zero otherwise
 	ErrorMessage string                       `json:",omitempty"` // end-user appropriate error message
+	DebugNumber  int64                        `json:",omitempty"`
+	DebugMessage string                       `json:",omitempty"`
 	Alert        string                       `json:",omitempty"` // used when the client end user needs to be alerted of something: (eg, maintenance mode, downtime, sercurity, required update, etc.)
+	Meta         map[string]json.RawMessage   `json:",omitempty"`
+	Links        *PayloadLinks                `json:",omitempty"`
+}
+
+type UnknownPayloadPolicy int
+
+const (
+	UnknownPayloadsKeepRaw UnknownPayloadPolicy = iota // unregistered payload types are kept as RawPayloads
+	UnknownPayloadsReject                              // unregistered payload types are an *UnknownPayloadTypeError
+)
+
+type UnmarshalOptions struct {
+	UnknownPayloads UnknownPayloadPolicy
+
+	// fields that don't match the PayloadWrapper or the registered payload's struct are an *UnknownFieldsError.
+	// Without Strict they're silently dropped, as encoding/json does.
+	Strict bool
+}
+
+// RawPayload is a payload of a type UnmarshalPayloadWrapper didn't know.  It marshals back to the same JSON.
+type RawPayload struct {
+	Type string
+	JSON json.RawMessage
+}
+
+func (payload RawPayload) PayloadType() string {
+	return payload.Type
+}
+func (payload RawPayload) MarshalJSON() ([]byte, error) {
+	if len(payload.JSON) == 0 {
+		return []byte("null"), nil
+	}
+	return payload.JSON, nil
+}
+
+// UnknownPayloadTypeError is returned for unregistered payload types w/ UnknownPayloadsReject.
+type UnknownPayloadTypeError struct {
+	PayloadType string
+}
+
+func (err *UnknownPayloadTypeError) Error() string {
+	return fmt.Sprintf("3679523799 Parse Error, unknown payload type %q", err.PayloadType)
+}
+
+// UnknownFieldsError is returned in Strict mode.  Pointers are JSON pointers, eg /Payloads/book/0/Colour
+type UnknownFieldsError struct {
+	Pointers []string
 }
 
+func (err *UnknownFieldsError) Error() string {
+	return fmt.Sprintf("3679523800 Parse Error, unknown fields: %s", strings.Join(err.Pointers, ", "))
+}
+
+// UnmarshalPayloadWrapper decodes payloads into pointers to the registered types, whether the registration
+// was a value or a pointer: BookPayload{} and &BookPayload{} both produce *BookPayload.
+// Unregistered payload types are kept as RawPayloads, see UnmarshalPayloadWrapperWithOptions.
 func UnmarshalPayloadWrapper(jsonBytes []byte, supportedPayloads ...Payload) (*PayloadWrapper, error) {
+	return UnmarshalPayloadWrapperWithOptions(jsonBytes, UnmarshalOptions{}, supportedPayloads...)
+}
+
+func UnmarshalPayloadWrapperWithOptions(jsonBytes []byte, options UnmarshalOptions, supportedPayloads ...Payload) (*PayloadWrapper, error) {
 
 	if len(supportedPayloads) == 0 {
 		return nil, deeperror.New(2911466683, "must supply supportedPayloads", nil)
@@ -222,29 +265,72 @@ func UnmarshalPayloadWrapper(jsonBytes []byte, supportedPayloads ...Payload) (*P
 		return nil, deeperror.New(3679523795, "Parse Error, Unexpectd format", err)
 	}
 
+	unknownFields := []string{}
+	if options.Strict {
+		unknownFields = unknownEnvelopeFields(jsonBytes)
+	}
+
 	// do the easy stuff first
 	pw.ErrorNumber = upw.ErrorNumber
 	pw.ErrorMessage = upw.ErrorMessage
+	pw.DebugNumber = upw.DebugNumber
+	pw.DebugMessage = upw.DebugMessage
 	pw.Alert = upw.Alert
+	pw.Links = upw.Links
 	pw.Payloads = make(PayloadsMap)
 
+	if upw.Meta != nil {
+		// numbers are json.Numbers, so ids and counts survive intact
+		pw.Meta = make(map[string]interface{}, len(upw.Meta))
+		for key, rawJson := range upw.Meta {
+			value, err := decodeJSONDocument(rawJson)
+			if err != nil {
+				return nil, deeperror.New(3679523798, "Parse Error, Unexpectd meta", err)
+			}
+			pw.Meta[key] = value
+		}
+	}
+
 	payloadTypeReflecMap := make(map[string]reflect.Type)
 	for _, payload := range supportedPayloads {
-		payloadTypeReflecMap[payload.PayloadType()] = reflect.TypeOf(payload)
+		pTypeReflect := reflect.TypeOf(payload)
+		if pTypeReflect.Kind() == reflect.Ptr {
+			pTypeReflect = pTypeReflect.Elem()
+		}
+		payloadTypeReflecMap[payload.PayloadType()] = pTypeReflect
 	}
 
 	for pTypeString, rawJsonList := range upw.Payloads {
-		pTypeReflect := payloadTypeReflecMap[pTypeString]
+		pTypeReflect, isSupported := payloadTypeReflecMap[pTypeString]
 		payloadList := make([]Payload, 0, len(rawJsonList))
-		for _, rawJson := range rawJsonList {
+		if isSupported == false {
+			if options.UnknownPayloads == UnknownPayloadsReject {
+				return nil, &UnknownPayloadTypeError{pTypeString}
+			}
+			for _, rawJson := range rawJsonList {
+				payloadList = append(payloadList, RawPayload{pTypeString, rawJson})
+			}
+			pw.Payloads[pTypeString] = payloadList
+			continue
+		}
+
+		for i, rawJson := range rawJsonList {
 			pReflectValue := reflect.New(pTypeReflect)
 			structPointer := pReflectValue.Interface()
 			pld, ok := structPointer.(Payload)
 			if ok == false {
-				return nil, deeperror.New(3679523796, "Parse Error, Unexpectd payload", err)
+				return nil, deeperror.New(3679523796, "Parse Error, Unexpectd payload", fmt.Errorf("*%s is not a Payload", pTypeReflect))
 			}
 
-			err := json.Unmarshal(rawJson, pld)
+			decoder := json.NewDecoder(bytes.NewReader(rawJson))
+			if options.Strict {
+				decoder.DisallowUnknownFields()
+			}
+			err := decoder.Decode(pld)
+			if field, isUnknownField := unknownFieldName(err); isUnknownField {
+				unknownFields = append(unknownFields, "/Payloads/"+escapeJSONPointerSegment(pTypeString)+"/"+strconv.Itoa(i)+"/"+field)
+				continue
+			}
 			if err != nil {
 				return nil, deeperror.New(3679523797, "Parse Error, Unexpectd payload", err)
 			}
@@ -255,5 +341,40 @@ func UnmarshalPayloadWrapper(jsonBytes []byte, supportedPayloads ...Payload) (*P
 		pw.Payloads[pTypeString] = payloadList
 	}
 
+	if len(unknownFields) > 0 {
+		sort.Strings(unknownFields)
+		return nil, &UnknownFieldsError{unknownFields}
+	}
 	return &pw, nil
 }
+
+// top level keys PayloadWrapper doesn't have.  matched case insensitively, like encoding/json.
+func unknownEnvelopeFields(jsonBytes []byte) []string {
+	var envelope map[string]json.RawMessage
+	if json.Unmarshal(jsonBytes, &envelope) != nil {
+		return nil
+	}
+	unknownFields := []string{}
+	for key := range envelope {
+		known := false
+		for _, field := range structJSONFields(reflect.TypeOf(PayloadWrapper{})) {
+			if strings.EqualFold(key, field.Name) {
+				known = true
+				break
+			}
+		}
+		if known == false {
+			unknownFields = append(unknownFields, "/"+escapeJSONPointerSegment(key))
+		}
+	}
+	return unknownFields
+}
+
+// encoding/json has no typed error for DisallowUnknownFields.  only the first unknown field of a payload is reported.
+func unknownFieldName(err error) (string, bool) {
+	const prefix = "json: unknown field \""
+	if err == nil || strings.HasPrefix(err.Error(), prefix) == false {
+		return "", false
+	}
+	return escapeJSONPointerSegment(strings.TrimSuffix(strings.TrimPrefix(err.Error(), prefix), "\"")), true
+}
@@ -1,7 +1,14 @@
 package grunway
 
 import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"github.com/amattn/deeperror"
 )
 
 func TestUnmarshallBad(t *testing.T) {
@@ -85,3 +92,128 @@ func TestUnmarshall(t *testing.T) {
 		}
 	}
 }
+
+func TestPayloadWrapperMetaAndLinks(t *testing.T) {
+	pw := NewPayloadWrapper(BookPayload{1, "Dune", 2})
+	pw.Meta = map[string]interface{}{"total": int64(9007199254740993), "requestId": "r-1"}
+	pw.Links = &PayloadLinks{Self: "/api/v1/book/all", Next: "/api/v1/book/all?cursor=2"}
+	jsonBytes, _ := MarshallPayloadWrapper(pw)
+	expected := `{"Payloads":{"book":[{"PKey":1,"Name":"Dune","AuthorId":2}]},"Meta":{"requestId":"r-1","total":9007199254740993},"Links":{"self":"/api/v1/book/all","next":"/api/v1/book/all?cursor=2"}}`
+	if string(jsonBytes) != expected {
+		t.Error("1402266801 unexpected json", string(jsonBytes))
+	}
+
+	upw, err := UnmarshalPayloadWrapper(jsonBytes, BookPayload{})
+	if err != nil {
+		t.Fatal("1402266802", err)
+	}
+	if total, _ := upw.Meta["total"].(json.Number); total.String() != "9007199254740993" || upw.Meta["requestId"] != "r-1" {
+		t.Error("1402266803 expected meta to survive intact", upw.Meta)
+	}
+	if upw.Links == nil || *upw.Links != *pw.Links {
+		t.Error("1402266804 expected links to survive", upw.Links)
+	}
+}
+
+type pagedController struct{}
+
+func (controller *pagedController) GetHandlerV1All(ctx *Context) RouteHandlerResult {
+	links := PayloadLinks{Self: ctx.LinkWithQuery("cursor", ctx.Req.URL.Query().Get("cursor")), Next: ctx.LinkWithQuery("cursor", "2")}
+	return ctx.MakeRouteHandlerResultPage(links, map[string]interface{}{"total": 3}, BookPayload{1, "Dune", 2})
+}
+
+func (controller *pagedController) GetHandlerV1Stream(ctx *Context) RouteHandlerResult {
+	ctx.SetResponseMeta("total", 1)
+	sent := false
+	return ctx.MakeRouteHandlerResultStream("book", func() (Payload, error) {
+		if sent {
+			return nil, io.EOF
+		}
+		sent = true
+		return BookPayload{1, "Dune", 2}, nil
+	})
+}
+
+func (controller *pagedController) GetHandlerV1Broken(ctx *Context) RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultError(http.StatusConflict, 1402266808, "broken")
+}
+
+func TestResponseMetaAndLinks(t *testing.T) {
+	router := NewRouter()
+	router.BasePath = "/api/"
+	router.RegisterEntity("book", &pagedController{})
+	router.MiddlewareProcessors = []MiddlewareProcessor{requestIDMiddleware{}}
+
+	get := func(path string) string {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://localhost/api/v1/"+path, nil)
+		router.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+
+	body := get("book/all?cursor=1&size=10")
+	expected := `{"Payloads":{"book":[{"PKey":1,"Name":"Dune","AuthorId":2}]},"Meta":{"requestId":"r-7","total":3},"Links":{"self":"/api/v1/book/all?cursor=1\u0026size=10","next":"/api/v1/book/all?cursor=2\u0026size=10"}}`
+	if body != expected {
+		t.Error("1402266805 unexpected page", body)
+	}
+
+	// streamed envelopes end w/ the same sections
+	expected = `{"Payloads":{"book":[{"PKey":1,"Name":"Dune","AuthorId":2}]},"Meta":{"requestId":"r-7","total":1}}`
+	if body = get("book/stream"); body != expected {
+		t.Error("1402266806 unexpected stream", body)
+	}
+
+	// errors too
+	if body = get("book/broken"); strings.Contains(body, `"Meta":{"requestId":"r-7"}`) == false {
+		t.Error("1402266807 expected meta on errors", body)
+	}
+}
+
+type requestIDMiddleware struct{}
+
+func (middleware requestIDMiddleware) Process(routePtr *Route, ctx *Context) (bool, *deeperror.DeepError) {
+	ctx.SetResponseMeta("requestId", "r-7")
+	return false, nil
+}
+
+func TestUnmarshallFidelity(t *testing.T) {
+	jsonBytes := []byte(`{"Payloads":{"book":[{"PKey":1,"Name":"Dune","AuthorId":2}],"scroll":[{"Length":12}]},"errorNumber":5,"errorMessage":"partial","debugNumber":6,"debugMessage":"shard 3 down"}`)
+
+	// pointer registrations work too, and debug info survives
+	upw, err := UnmarshalPayloadWrapper(jsonBytes, &BookPayload{})
+	if err != nil {
+		t.Fatal("1402266820", err)
+	}
+	if book, isBook := upw.Payloads["book"][0].(*BookPayload); isBook == false || book.Name != "Dune" {
+		t.Error("1402266821 expected a *BookPayload", upw.Payloads["book"])
+	}
+	if upw.DebugNumber != 6 || upw.DebugMessage != "shard 3 down" || upw.ErrorNumber != 5 {
+		t.Error("1402266822 expected the debug fields", upw.ErrorInfo)
+	}
+
+	// unknown types are kept, and marshal back to what came in
+	raw, isRaw := upw.Payloads["scroll"][0].(RawPayload)
+	if isRaw == false || string(raw.JSON) != `{"Length":12}` {
+		t.Error("1402266823 expected a RawPayload", upw.Payloads["scroll"])
+	}
+	if remarshalled, _ := MarshallPayloadWrapper(upw); string(remarshalled) != string(jsonBytes) {
+		t.Error("1402266824 expected a lossless round trip", string(remarshalled))
+	}
+
+	// or rejected
+	_, err = UnmarshalPayloadWrapperWithOptions(jsonBytes, UnmarshalOptions{UnknownPayloads: UnknownPayloadsReject}, BookPayload{})
+	if typeErr, isTypeErr := err.(*UnknownPayloadTypeError); isTypeErr == false || typeErr.PayloadType != "scroll" {
+		t.Error("1402266825 expected an UnknownPayloadTypeError", err)
+	}
+
+	// strict mode reports unknown fields everywhere
+	jsonBytes = []byte(`{"Payloads":{"book":[{"PKey":1},{"PKey":2,"Colour":"red"}]},"Extra":true,"alert":"fine"}`)
+	if _, err = UnmarshalPayloadWrapper(jsonBytes, BookPayload{}); err != nil {
+		t.Error("1402266826 unknown fields are ignored by default", err)
+	}
+	_, err = UnmarshalPayloadWrapperWithOptions(jsonBytes, UnmarshalOptions{Strict: true}, BookPayload{})
+	fieldsErr, isFieldsErr := err.(*UnknownFieldsError)
+	if isFieldsErr == false || strings.Join(fieldsErr.Pointers, " ") != "/Extra /Payloads/book/1/Colour" {
+		t.Error("1402266827 expected an UnknownFieldsError", err)
+	}
+}
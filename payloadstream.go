@@ -0,0 +1,272 @@
+package grunway
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/amattn/deeperror"
+)
+
+const (
+	httpHeaderContentTypeNDJSON = "application/x-ndjson"
+
+	StreamPayloadTypeErrorNumber = 5000000501 // a streamed payload didn't match the stream's payload type
+
+	streamFlushEvery    = 100                    // payloads
+	streamFlushInterval = 250 * time.Millisecond // or this long since the last flush
+)
+
+// NDJSONEncoder writes newline delimited JSON.  Ordinary responses are one line, a PayloadWrapper.
+// Streams (see MakeRouteHandlerResultStream) are one payload per line.  Registered by default, but only
+// used when the Accept header names application/x-ndjson.
+type NDJSONEncoder struct{}
+
+func (encoder NDJSONEncoder) MediaType() string {
+	return httpHeaderContentTypeNDJSON
+}
+func (encoder NDJSONEncoder) namedOnly() {}
+func (encoder NDJSONEncoder) Encode(v interface{}) ([]byte, error) {
+	encoded, err := JSONEncoder{}.Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	return append(encoded, '\n'), nil
+}
+func (encoder NDJSONEncoder) EncodeTo(w io.Writer, v interface{}) error {
+	err := JSONEncoder{}.EncodeTo(w, v)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// PayloadIterator returns the next payload of a stream, or io.EOF when there are no more.
+// Any other error ends the stream w/ an error marker.  DeepErrors supply the ErrorNumber and message.
+type PayloadIterator func() (Payload, error)
+
+// ChannelPayloadIterator reads payloads until the channel is closed.
+// The stream stops reading if the client goes away, so producers should also select on ctx.Req.Context().Done().
+func ChannelPayloadIterator(payloads <-chan Payload) PayloadIterator {
+	return func() (Payload, error) {
+		payload, open := <-payloads
+		if open == false {
+			return nil, io.EOF
+		}
+		return payload, nil
+	}
+}
+
+// Server.WriteTimeout is sized for ordinary responses, streams run for as long as there's something to send.
+// Writers that can't set deadlines (eg httptest.ResponseRecorder) are fine, there's nothing to clear.
+func (ctx *Context) clearWriteDeadline() {
+	err := http.NewResponseController(ctx.w).SetWriteDeadline(time.Time{})
+	if err != nil && errors.Is(err, http.ErrNotSupported) == false {
+		log.Println("2035519566 cannot clear write deadline", err)
+	}
+}
+
+// MakeRouteHandlerResultStream streams payloads of one type w/o building a PayloadsMap.
+//
+// JSON clients get the usual envelope, {"Payloads":{"<payloadType>":[...]}}, written as the iterator runs.
+// Clients that accept application/x-ndjson get one payload per line.  Other encoders get an ordinary,
+// fully buffered response.
+//
+// An error from the first call to next produces a normal error response.  After that the 200 is committed,
+// so the stream ends w/ an error marker instead: the envelope gets errorNumber and errorMessage fields,
+// NDJSON gets a final {"errorNumber":...} line, and both set the Grunway-ErrorNumber trailer.
+// Meta and Links set w/ SetResponseMeta and SetResponseLinks close the envelope, NDJSON has nowhere to put them.
+// The stream stops early if the client goes away.
+func (ctx *Context) MakeRouteHandlerResultStream(payloadType string, next PayloadIterator) RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultCustom(func(innerCtx *Context) {
+		writePayloadStream(innerCtx, payloadType, next)
+	})
+}
+
+func streamErrorInfo(err error) ErrorInfo {
+	derr, isDeepError := err.(*deeperror.DeepError)
+	if isDeepError == false || derr == nil {
+		return ErrorInfo{ErrorNumber: 2035519561, ErrorMessage: InternalServerErrorPrefix}
+	}
+	return ErrorInfo{ErrorNumber: derr.Num, ErrorMessage: derr.EndUserMsg}
+}
+
+func nextStreamPayload(payloadType string, next PayloadIterator) (Payload, error) {
+	payload, err := next()
+	if err != nil {
+		return nil, err
+	}
+	if payload == nil || payload.PayloadType() != payloadType {
+		return nil, deeperror.New(StreamPayloadTypeErrorNumber, InternalServerErrorPrefix, fmt.Errorf("expected %s payload, got %T", payloadType, payload))
+	}
+	return payload, nil
+}
+
+func writePayloadStream(ctx *Context, payloadType string, next PayloadIterator) {
+	if ctx.written == true {
+		log.Println(deeperror.New(2035519562, "ERROR attempt to write multiple times to same writer", nil))
+		return
+	}
+
+	encoder := ctx.responseEncoder()
+	_, isJSON := encoder.(JSONEncoder)
+	_, isNDJSON := encoder.(NDJSONEncoder)
+	if isJSON == false && isNDJSON == false {
+		writeBufferedStream(ctx, payloadType, next)
+		return
+	}
+
+	first, err := nextStreamPayload(payloadType, next)
+	if err != nil && err != io.EOF {
+		rhr := ctx.MakeRouteHandlerResultFromError(err)
+		ctx.SendErrorInfoPayload(rhr.rerr.statusCode, rhr.rerr.errorInfo)
+		return
+	}
+
+	ctx.written = true
+	ctx.SetHeader(httpHeaderContentType, encoder.MediaType())
+	ctx.SetHeader("Trailer", "Grunway-ErrorNumber, Grunway-ErrorMessage")
+	ctx.StatusCode = http.StatusOK
+	ctx.clearWriteDeadline()
+	ctx.w.WriteHeader(http.StatusOK)
+
+	stream := &payloadStream{ctx: ctx, ndjson: isNDJSON, lastFlush: time.Now()}
+	stream.open(payloadType)
+	payload := first
+	for err == nil && stream.writeErr == nil {
+		err = stream.write(payload)
+		if err != nil {
+			break
+		}
+		if ctxErr := ctx.Req.Context().Err(); ctxErr != nil {
+			log.Println("2035519563 stream cancelled by client after", stream.count, "payloads", ctx.Req.URL)
+			break
+		}
+		payload, err = nextStreamPayload(payloadType, next)
+	}
+	if err != nil && err != io.EOF && stream.writeErr == nil {
+		log.Println("2035519564 stream failed after", stream.count, "payloads", ctx.Req.URL, err)
+		stream.closeWithError(streamErrorInfo(err))
+	} else {
+		stream.close()
+	}
+	if stream.writeErr != nil {
+		log.Println("3952513088 WRITE ERROR", stream.writeErr)
+	}
+	stream.flush()
+
+	runPostProcessors(ctx)
+}
+
+// for encoders that can't stream, eg XML
+func writeBufferedStream(ctx *Context, payloadType string, next PayloadIterator) {
+	payloads := []Payload{}
+	for {
+		payload, err := nextStreamPayload(payloadType, next)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			rhr := ctx.MakeRouteHandlerResultFromError(err)
+			ctx.SendErrorInfoPayload(rhr.rerr.statusCode, rhr.rerr.errorInfo)
+			return
+		}
+		payloads = append(payloads, payload)
+	}
+	payloadWrapper := NewPayloadWrapper()
+	payloadWrapper.Payloads[payloadType] = payloads
+	writePayloadWrapper(ctx, http.StatusOK, payloadWrapper)
+}
+
+type payloadStream struct {
+	ctx       *Context
+	ndjson    bool
+	count     int
+	lastFlush time.Time
+	writeErr  error
+}
+
+func (stream *payloadStream) writeBytes(p []byte) {
+	if stream.writeErr != nil {
+		return
+	}
+	n, err := stream.ctx.w.Write(p)
+	stream.ctx.ContentLength += n
+	stream.writeErr = err
+}
+
+func (stream *payloadStream) open(payloadType string) {
+	if stream.ndjson {
+		return
+	}
+	keyBytes, _ := json.Marshal(payloadType)
+	stream.writeBytes([]byte(`{"Payloads":{` + string(keyBytes) + `:[`))
+}
+
+func (stream *payloadStream) write(payload Payload) error {
+	if payload == nil {
+		// an empty stream
+		return nil
+	}
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return deeperror.New(3589720733, "Fatal Internal Output Error", err)
+	}
+	if stream.ndjson {
+		encoded = append(encoded, '\n')
+	} else if stream.count > 0 {
+		encoded = append([]byte(","), encoded...)
+	}
+	stream.writeBytes(encoded)
+	stream.count++
+
+	if stream.count%streamFlushEvery == 0 || time.Since(stream.lastFlush) > streamFlushInterval {
+		stream.flush()
+	}
+	return nil
+}
+
+func (stream *payloadStream) close() {
+	if stream.ndjson == false {
+		stream.closeEnvelope(ErrorInfo{})
+	}
+}
+
+// the error marker.  the status is already 200, so it's in the body and the trailers.
+func (stream *payloadStream) closeWithError(errorInfo ErrorInfo) {
+	if stream.ndjson {
+		errorBytes, _ := json.Marshal(errorInfo)
+		stream.writeBytes(append(errorBytes, '\n'))
+	} else {
+		stream.closeEnvelope(errorInfo)
+	}
+	stream.ctx.SetHeader("Grunway-ErrorNumber", fmt.Sprintf("%d", errorInfo.ErrorNumber))
+	stream.ctx.SetHeader("Grunway-ErrorMessage", errorInfo.ErrorMessage)
+}
+
+// everything after Payloads, as MarshallPayloadWrapper would write it: the error marker, Meta and Links
+func (stream *payloadStream) closeEnvelope(errorInfo ErrorInfo) {
+	rest := PayloadWrapper{ErrorInfo: errorInfo}
+	stream.ctx.applyResponseSections(&rest)
+	restBytes, err := MarshallPayloadWrapper(&rest)
+	if err != nil || len(restBytes) <= 2 {
+		if err != nil {
+			log.Println("2035519565 cannot encode the end of the stream", err)
+		}
+		stream.writeBytes([]byte("]}}"))
+		return
+	}
+	stream.writeBytes(append([]byte("]},"), restBytes[1:]...))
+}
+
+func (stream *payloadStream) flush() {
+	stream.lastFlush = time.Now()
+	if flusher, ok := stream.ctx.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
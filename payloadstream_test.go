@@ -0,0 +1,164 @@
+package grunway
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amattn/deeperror"
+)
+
+type catalogController struct {
+	count   int
+	failAt  int // 1 based, 0 never
+	failErr error
+	onNext  func(i int)
+}
+
+func (controller *catalogController) GetHandlerV1All(ctx *Context) RouteHandlerResult {
+	i := 0
+	return ctx.MakeRouteHandlerResultStream("book", func() (Payload, error) {
+		i++
+		if controller.onNext != nil {
+			controller.onNext(i)
+		}
+		if i == controller.failAt {
+			return nil, controller.failErr
+		}
+		if i > controller.count {
+			return nil, io.EOF
+		}
+		return BookPayload{int64(i), "Volume", 1}, nil
+	})
+}
+
+func (controller *catalogController) GetHandlerV1Channel(ctx *Context) RouteHandlerResult {
+	payloads := make(chan Payload)
+	go func() {
+		defer close(payloads)
+		for i := 1; i <= controller.count; i++ {
+			payloads <- BookPayload{int64(i), "Volume", 1}
+		}
+	}()
+	return ctx.MakeRouteHandlerResultStream("book", ChannelPayloadIterator(payloads))
+}
+
+func TestPayloadStream(t *testing.T) {
+	catalog := &catalogController{count: 250}
+	router := NewRouter()
+	router.BasePath = "/api/"
+	router.RegisterEncoder(XMLEncoder{})
+	router.RegisterEntity("catalog", catalog)
+
+	var logged *Context
+	router.PostProcessors = []PostProcessor{postProcessorFunc(func(ctx *Context) { logged = ctx })}
+	get := func(action, accept string, reqCtx context.Context) *httptest.ResponseRecorder {
+		logged = nil
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://localhost/api/v1/catalog/"+action, nil)
+		if reqCtx != nil {
+			req = req.WithContext(reqCtx)
+		}
+		req.Header.Set("Accept", accept)
+		router.ServeHTTP(w, req)
+		return w
+	}
+	books := func(n int) []Payload {
+		list := []Payload{}
+		for i := 1; i <= n; i++ {
+			list = append(list, BookPayload{int64(i), "Volume", 1})
+		}
+		return list
+	}
+
+	for _, action := range []string{"all", "channel"} {
+		w := get(action, "", nil)
+		expected, _ := MarshallPayloadWrapper(NewPayloadWrapper(books(250)...))
+		if w.Code != http.StatusOK || w.Body.String() != string(expected) {
+			t.Error("2035519590 unexpected envelope", action, w.Code, w.Body.String())
+		}
+		if logged == nil || logged.ContentLength != w.Body.Len() {
+			t.Error("2035519591 PostProcessors expected the streamed byte count", action, logged)
+		}
+	}
+
+	w := get("all", "application/x-ndjson", nil)
+	lines := strings.Split(strings.TrimSuffix(w.Body.String(), "\n"), "\n")
+	if w.Header().Get(httpHeaderContentType) != httpHeaderContentTypeNDJSON || len(lines) != 250 || lines[0] != `{"PKey":1,"Name":"Volume","AuthorId":1}` {
+		t.Error("2035519592 unexpected ndjson", w.Header(), len(lines), lines[0])
+	}
+
+	// buffered for encoders that can't stream
+	w = get("all", "application/xml", nil)
+	if w.Code != http.StatusOK || strings.Count(w.Body.String(), "<Payload>") != 250 {
+		t.Error("2035519593 unexpected xml", w.Code)
+	}
+
+	// mid-stream failure
+	catalog.failAt, catalog.failErr = 4, deeperror.New(2035519594, "shelf collapsed", nil)
+	w = get("all", "", nil)
+	pw, err := UnmarshalPayloadWrapper(w.Body.Bytes(), BookPayload{})
+	if err != nil || w.Code != http.StatusOK || pw.ErrorNumber != 2035519594 || len(pw.Payloads["book"]) != 3 {
+		t.Error("2035519595 expected a trailing error marker", w.Code, err, w.Body.String())
+	}
+	if w.Result().Trailer.Get("Grunway-ErrorNumber") != "2035519594" {
+		t.Error("2035519596 expected the error number trailer", w.Result().Trailer)
+	}
+	w = get("all", "application/x-ndjson", nil)
+	lines = strings.Split(strings.TrimSuffix(w.Body.String(), "\n"), "\n")
+	var marker ErrorInfo
+	json.Unmarshal([]byte(lines[len(lines)-1]), &marker)
+	if len(lines) != 4 || marker.ErrorNumber != 2035519594 {
+		t.Error("2035519597 expected an ndjson error marker", lines)
+	}
+
+	// failure before anything is written
+	catalog.failAt, catalog.failErr = 1, deeperror.NewHTTPError(2035519598, "gone", nil, http.StatusGone)
+	if w = get("all", "", nil); w.Code != http.StatusGone {
+		t.Error("2035519599 expected a normal error response", w.Code, w.Body.String())
+	}
+
+	// client goes away
+	reqCtx, cancel := context.WithCancel(context.Background())
+	catalog.failAt, catalog.onNext = 0, func(i int) {
+		if i == 10 {
+			cancel()
+		}
+	}
+	w = get("all", "application/x-ndjson", reqCtx)
+	if strings.Count(w.Body.String(), "\n") != 10 {
+		t.Error("2035519600 expected the stream to stop when cancelled", strings.Count(w.Body.String(), "\n"))
+	}
+}
+
+func TestPayloadStreamOutlivesWriteTimeout(t *testing.T) {
+	catalog := &catalogController{count: 5, onNext: func(i int) { time.Sleep(40 * time.Millisecond) }}
+	router := NewRouter()
+	router.BasePath = "/api/"
+	router.RegisterEntity("catalog", catalog)
+	// recording wraps the writer, the deadline still has to reach the connection
+	router.PostProcessors = []PostProcessor{NewTrafficRecorder(ioutil.Discard, TrafficRecorderOptions{})}
+
+	server := httptest.NewUnstartedServer(router)
+	server.Config.WriteTimeout = 100 * time.Millisecond
+	server.Start()
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL+"/api/v1/catalog/all", nil)
+	req.Header.Set("Accept", httpHeaderContentTypeNDJSON)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("2035519601", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil || strings.Count(string(body), "\n") != 5 {
+		t.Error("2035519602 expected the whole stream despite the write timeout", err, string(body))
+	}
+}
@@ -0,0 +1,107 @@
+package grunway
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+const (
+	httpHeaderContentTypeProblemJSON = "application/problem+json"
+
+	DefaultProblemTypeBaseURI = "urn:grunway:error:"
+)
+
+// ProblemDetails is an RFC 7807 problem document.  Error responses are sent as one when the router has
+// ProblemDetails set, or when the client's Accept header names application/problem+json.
+//
+// type is ProblemTypeBaseURI followed by the error number (about:blank w/o one), title is the HTTP status text,
+// and detail is the ErrorMessage.  The remaining ErrorInfo fields, Alert and Meta are extension members.
+type ProblemDetails struct {
+	Type     string `json:"type"`
+	Title    string `json:"title,omitempty"`
+	Status   int    `json:"status,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"` // the request path
+
+	ErrorNumber  int64  `json:"errorNumber,omitempty"`
+	DebugNumber  int64  `json:"debugNumber,omitempty"`
+	DebugMessage string `json:"debugMessage,omitempty"`
+	Alert        string `json:"alert,omitempty"`
+
+	Meta map[string]interface{} `json:"meta,omitempty"`
+}
+
+// ProblemEncoder writes error PayloadWrappers as ProblemDetails.  Successful responses are plain JSON.
+// Registered by default so clients that only accept application/problem+json aren't turned away,
+// but it's only used when the Accept header names it.
+type ProblemEncoder struct {
+	// set per response, see forResponse
+	typeBaseURI string
+	instance    string
+	status      int
+}
+
+func (encoder ProblemEncoder) MediaType() string {
+	return httpHeaderContentTypeProblemJSON
+}
+func (encoder ProblemEncoder) namedOnly() {}
+func (encoder ProblemEncoder) forResponse(ctx *Context, code int) Encoder {
+	if code < 400 {
+		return JSONEncoder{}
+	}
+	encoder.typeBaseURI = DefaultProblemTypeBaseURI
+	if ctx.router != nil && ctx.router.ProblemTypeBaseURI != "" {
+		encoder.typeBaseURI = ctx.router.ProblemTypeBaseURI
+	}
+	if ctx.Req != nil {
+		encoder.instance = ctx.Req.URL.Path
+	}
+	encoder.status = code
+	return encoder
+}
+func (encoder ProblemEncoder) Encode(v interface{}) ([]byte, error) {
+	pw, isPayloadWrapper := v.(*PayloadWrapper)
+	if isPayloadWrapper == false {
+		return json.Marshal(v)
+	}
+	return json.Marshal(encoder.problem(pw))
+}
+
+func (encoder ProblemEncoder) problem(pw *PayloadWrapper) ProblemDetails {
+	problem := ProblemDetails{
+		Type:         "about:blank",
+		Title:        http.StatusText(encoder.status),
+		Status:       encoder.status,
+		Detail:       pw.ErrorMessage,
+		Instance:     encoder.instance,
+		ErrorNumber:  pw.ErrorNumber,
+		DebugNumber:  pw.DebugNumber,
+		DebugMessage: pw.DebugMessage,
+		Alert:        pw.Alert,
+		Meta:         pw.Meta,
+	}
+	if pw.ErrorNumber != 0 {
+		typeBaseURI := encoder.typeBaseURI
+		if typeBaseURI == "" {
+			typeBaseURI = DefaultProblemTypeBaseURI
+		}
+		problem.Type = typeBaseURI + strconv.FormatInt(pw.ErrorNumber, 10)
+	}
+	return problem
+}
+
+// error responses are problems if the router says so, or the client asked for them by name
+func (ctx *Context) wantsProblemDetails(code int) bool {
+	if code < 400 {
+		return false
+	}
+	if ctx.router != nil && ctx.router.ProblemDetails {
+		return true
+	}
+	if ctx.Req == nil {
+		return false
+	}
+	quality, exact := acceptQuality(parseAccept(ctx.Req.Header.Get(httpHeaderAccept)), httpHeaderContentTypeProblemJSON)
+	return exact && quality > 0
+}
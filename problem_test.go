@@ -0,0 +1,61 @@
+package grunway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestProblemDetails(t *testing.T) {
+	router := NewRouter()
+	router.BasePath = "/api/"
+	router.RegisterEntity("book", &libraryController{})
+
+	get := func(path, accept string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://localhost/api/v1/"+path, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// opt in w/ Accept
+	w := get("book/404", "application/json, application/problem+json")
+	expected := `{"type":"urn:grunway:error:3840213150","title":"Not Found","status":404,"detail":"no such book","instance":"/api/v1/book/404","errorNumber":3840213150,"debugNumber":7,"debugMessage":"shelf empty"}`
+	if w.Code != http.StatusNotFound || w.Header().Get(httpHeaderContentType) != httpHeaderContentTypeProblemJSON || w.Body.String() != expected {
+		t.Error("2417039151 unexpected problem", w.Code, w.Header(), w.Body.String())
+	}
+	if w.Header().Get("Grunway-ErrorNumber") != "3840213150" {
+		t.Error("2417039152 expected the usual error headers too", w.Header())
+	}
+
+	// successful responses are unaffected, even for clients that only accept problems
+	w = get("book/1", "application/problem+json")
+	if w.Code != http.StatusOK || w.Header().Get(httpHeaderContentType) != httpHeaderContentTypeJSON {
+		t.Error("2417039153 expected plain JSON", w.Code, w.Header())
+	}
+
+	// not asked for, not sent
+	w = get("book/404", "")
+	if w.Header().Get(httpHeaderContentType) != httpHeaderContentTypeJSON || strings.Contains(w.Body.String(), `"errorNumber":3840213150`) == false {
+		t.Error("2417039154 expected a PayloadWrapper", w.Header(), w.Body.String())
+	}
+
+	// or for every client
+	router.ProblemDetails = true
+	router.ProblemTypeBaseURI = "https://errors.example.com/"
+	w = get("shelf/1", "")
+	problem := ProblemDetails{}
+	json.Unmarshal(w.Body.Bytes(), &problem)
+	if w.Code != http.StatusNotFound || w.Header().Get(httpHeaderContentType) != httpHeaderContentTypeProblemJSON {
+		t.Error("2417039155 expected a problem for an unknown route", w.Code, w.Header())
+	}
+	if problem.ErrorNumber == 0 || problem.Type != "https://errors.example.com/"+strconv.FormatInt(problem.ErrorNumber, 10) || problem.Status != http.StatusNotFound || problem.Instance != "/api/v1/shelf/1" {
+		t.Error("2417039156 unexpected problem", w.Header(), w.Body.String())
+	}
+}
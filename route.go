@@ -16,6 +16,7 @@ const (
 	MAGIC_PATCH_HANDLER_PREFIX  = "PatchHandler"  // CRUD: update (just a field or two)
 	MAGIC_DELETE_HANDLER_PREFIX = "DeleteHandler" // CRUD: delete (duh)
 	MAGIC_HEAD_HANDLER_PREFIX   = "HeadHandler"   // usually when you just want to check Etags or something.
+	// MAGIC_WEBSOCKET_HANDLER_PREFIX (websocket.go) is a GET that upgrades the connection
 )
 
 const VERSION_BIT_DEPTH = 16
@@ -34,6 +35,10 @@ type Route struct {
 	Handler        RouteHandler
 	HandlerName    string // not actually used except for logging and debugging
 	ControllerName string // not actually used except for logging and debugging
+
+	Deprecation *RouteDeprecation // nil unless set via Router.DeprecateRoute
+
+	WebSocket bool // Handler upgrades the connection.  see WebSocketHandler
 }
 
 func parseVersionFromPrefixlessHandlerName(versionActionHandlerName string) (vStr string, action string) {
@@ -0,0 +1,215 @@
+package grunway
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+const DefaultRouteTableEntityName = "admin"
+
+// RouteDeprecation marks a route as deprecated.  See Router.DeprecateRoute.
+// Deprecated routes still work, but responses carry Deprecation and (if set) Sunset headers.
+type RouteDeprecation struct {
+	Since       time.Time `json:",omitempty"`
+	Sunset      time.Time `json:",omitempty"` // when the route is expected to be removed
+	Message     string    `json:",omitempty"`
+	Replacement string    `json:",omitempty"` // eg "GET /api/v2/book/{id}"
+}
+
+// RouteInfo is the structured, machine readable version of a line from AllRoutesDescription.
+type RouteInfo struct {
+	Method       string
+	PathTemplate string // eg /api/v1/book/{id}.  {id} is the int64 primary key
+	// action routes also answer w/ a primary key before the action, eg /api/v1/book/{id}/checkout.  "" for other routes
+	KeyedPathTemplate string `json:",omitempty"`
+	VersionStr        string
+	EntityName        string
+	Action            string
+
+	// true for GET, PUT and PATCH routes w/o an action, which 400 if the primary key is missing
+	RequiresPrimaryKey bool
+
+	RequiresAuth  bool
+	Authenticator string `json:",omitempty"` // type name of the AuthHandler
+
+	Controller string
+	Handler    string
+
+	// type names of the router's MiddlewareProcessors, in order.  middleware applies to every route.
+	Middleware []string
+
+	Deprecated  bool
+	Deprecation *RouteDeprecation `json:",omitempty"`
+
+	WebSocket bool // a WebSocketHandler route
+}
+
+func (info RouteInfo) PayloadType() string {
+	return "route"
+}
+
+// ######
+// #     #  ####  #    # ##### ######  ####
+// #     # #    # #    #   #   #      #
+// ######  #    # #    #   #   #####   ####
+// #   #   #    # #    #   #   #           #
+// #    #  #    # #    #   #   #      #    #
+// #     #  ####   ####    #   ######  ####
+//
+
+// AllRoutes returns every registered route, in the same order as AllRoutesDescription.
+func (router *Router) AllRoutes() []RouteInfo {
+	routeKeys := make([]string, 0, len(router.RouteMap))
+	for routeKey := range router.RouteMap {
+		routeKeys = append(routeKeys, routeKey)
+	}
+	sort.Strings(routeKeys)
+
+	middleware := make([]string, 0, len(router.MiddlewareProcessors))
+	for _, processor := range router.MiddlewareProcessors {
+		middleware = append(middleware, reflect.TypeOf(processor).String())
+	}
+
+	infos := make([]RouteInfo, 0, len(routeKeys))
+	for _, routeKey := range routeKeys {
+		routePtr := router.RouteMap[routeKey]
+		info := RouteInfo{
+			Method:             routePtr.Method,
+			PathTemplate:       router.pathTemplate(routePtr),
+			KeyedPathTemplate:  router.keyedPathTemplate(routePtr),
+			VersionStr:         routePtr.VersionStr,
+			EntityName:         routePtr.EntityName,
+			Action:             routePtr.Action,
+			RequiresPrimaryKey: routeRequiresPrimaryKey(routePtr),
+			RequiresAuth:       routePtr.RequiresAuth,
+			Controller:         routePtr.ControllerName,
+			Handler:            routePtr.HandlerName,
+			Middleware:         middleware,
+			WebSocket:          routePtr.WebSocket,
+		}
+		if routePtr.Authenticator != nil {
+			info.Authenticator = reflect.TypeOf(routePtr.Authenticator).String()
+		}
+		if routePtr.Deprecation != nil {
+			deprecation := *routePtr.Deprecation
+			info.Deprecated = true
+			info.Deprecation = &deprecation
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// DeprecateRoute marks an already registered route as deprecated.  action is "" for the plain entity route.
+func (router *Router) DeprecateRoute(method, versionStr, entityName, action string, deprecation RouteDeprecation) error {
+	routePtr, _ := getRoute(router.RouteMap, method, versionStr, entityName, action)
+	if routePtr == nil {
+		return fmt.Errorf("1517384401 no route for %s v%s %s %s", method, versionStr, entityName, action)
+	}
+	routePtr.Deprecation = &deprecation
+	return nil
+}
+
+func (router *Router) pathTemplate(routePtr *Route) string {
+	path := router.entityPath(routePtr)
+	if routePtr.Action != "" {
+		return path + "/" + routePtr.Action
+	}
+	if routePtr.Method == "POST" {
+		return path + "/"
+	}
+	return path + "/{id}"
+}
+
+// <Entity>/<optionalID>/<Action>, the variant w/ the id
+func (router *Router) keyedPathTemplate(routePtr *Route) string {
+	if routePtr.Action == "" {
+		return ""
+	}
+	return router.entityPath(routePtr) + "/{id}/" + routePtr.Action
+}
+
+func (router *Router) entityPath(routePtr *Route) string {
+	basePath := "/" + strings.Trim(router.BasePath, "/")
+	if basePath == "/" {
+		basePath = ""
+	}
+	return fmt.Sprintf("%s/v%s/%s", basePath, routePtr.VersionStr, routePtr.EntityName)
+}
+
+// mirrors the validation in handleContext
+func routeRequiresPrimaryKey(routePtr *Route) bool {
+	if routePtr.Action != "" {
+		return false
+	}
+	return routePtr.Method == "GET" || routePtr.Method == "PATCH" || routePtr.Method == "PUT"
+}
+
+func setDeprecationHeaders(ctx *Context, deprecation *RouteDeprecation) {
+	if deprecation.Since.IsZero() {
+		ctx.SetHeader("Deprecation", "true")
+	} else {
+		ctx.SetHeader("Deprecation", fmt.Sprintf("@%d", deprecation.Since.Unix()))
+	}
+	if deprecation.Sunset.IsZero() == false {
+		ctx.SetHeader("Sunset", deprecation.Sunset.UTC().Format(http.TimeFormat))
+	}
+}
+
+//    #
+//   # #   #####  #    # # #    #
+//  #   #  #    # ##  ## # ##   #
+// #     # #    # # ## # # # #  #
+// ####### #    # #    # # #  # #
+// #     # #    # #    # # #   ##
+// #     # #####  #    # # #    #
+//
+
+// RouteTableController serves the route table as JSON.  Use Router.EnableRouteTableEndpoint to get one.
+type RouteTableController struct {
+	Authenticator AuthHandler
+	router        *Router
+}
+
+// EnableRouteTableEndpoint registers GET /<BasePath>/v1/<entityName>/routes, protected by authenticator.
+// entityName "" means DefaultRouteTableEntityName.
+func (router *Router) EnableRouteTableEndpoint(entityName string, authenticator AuthHandler) *RouteTableController {
+	if entityName == "" {
+		entityName = DefaultRouteTableEntityName
+	}
+	if authenticator == nil {
+		log.Fatalln("1517384410 the route table endpoint requires an authenticator")
+	}
+	controller := new(RouteTableController)
+	controller.Authenticator = authenticator
+	controller.router = router
+	router.RegisterEntity(entityName, controller)
+	return controller
+}
+
+func (controller *RouteTableController) PerformAuth(routePtr *Route, ctx *Context) (bool, int) {
+	return controller.Authenticator.PerformAuth(routePtr, ctx)
+}
+func (controller *RouteTableController) GetSecretKey(publicKey string) (string, int) {
+	return controller.Authenticator.GetSecretKey(publicKey)
+}
+
+func (controller *RouteTableController) RouteDocs() map[string]HandlerDoc {
+	return map[string]HandlerDoc{
+		"AuthGetHandlerV1Routes": {Summary: "All registered routes", Responses: []Payload{RouteInfo{}}},
+	}
+}
+
+func (controller *RouteTableController) AuthGetHandlerV1Routes(ctx *Context) RouteHandlerResult {
+	infos := controller.router.AllRoutes()
+	payloads := make([]Payload, 0, len(infos))
+	for _, info := range infos {
+		payloads = append(payloads, info)
+	}
+	return ctx.MakeRouteHandlerResultPayloads(payloads...)
+}
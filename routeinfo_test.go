@@ -0,0 +1,113 @@
+package grunway
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAllRoutes(t *testing.T) {
+	router := NewRouter()
+	router.BasePath = "/api/"
+	router.PostProcessors = nil
+	router.RegisterEntity("book", &BookController{})
+	router.RegisterEntity("author", &AuthorController{})
+
+	infos := router.AllRoutes()
+	if len(infos) != router.AllRoutesCount() {
+		t.Fatal("1517384490 expected one RouteInfo per route", len(infos), router.AllRoutesCount())
+	}
+
+	var authorGet *RouteInfo
+	for i := range infos {
+		if infos[i].EntityName == "author" && infos[i].Method == "GET" && infos[i].Action == "" {
+			authorGet = &infos[i]
+		}
+	}
+	if authorGet == nil {
+		t.Fatal("1517384491 missing GET author route", infos)
+	}
+	if authorGet.PathTemplate != "/api/v1/author/{id}" || authorGet.RequiresPrimaryKey == false || authorGet.RequiresAuth {
+		t.Error("1517384492 unexpected RouteInfo", authorGet)
+	}
+	if authorGet.Handler != "GetHandlerV1" || authorGet.Controller != "*grunway.AuthorController" {
+		t.Error("1517384493 unexpected handler info", authorGet)
+	}
+
+	// action routes take an optional primary key
+	var popular *RouteInfo
+	for i := range infos {
+		if infos[i].EntityName == "book" && infos[i].Action == "popular" {
+			popular = &infos[i]
+		}
+	}
+	if popular == nil || popular.PathTemplate != "/api/v1/book/popular" || popular.KeyedPathTemplate != "/api/v1/book/{id}/popular" || authorGet.KeyedPathTemplate != "" {
+		t.Error("1517384498 unexpected action path templates", popular, authorGet)
+	}
+	for _, path := range []string{"/api/v1/book/popular", "/api/v1/book/5/popular"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://localhost"+path, nil)
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Error("1517384499 both action templates should route", path, w.Code)
+		}
+	}
+
+	sunset := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := router.DeprecateRoute("GET", "1", "author", "", RouteDeprecation{Sunset: sunset}); err != nil {
+		t.Fatal(err)
+	}
+	if err := router.DeprecateRoute("GET", "9", "author", "", RouteDeprecation{}); err == nil {
+		t.Error("1517384494 expected error for unknown route")
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://localhost/api/v1/author/1", nil)
+	router.ServeHTTP(w, req)
+	if w.Header().Get("Deprecation") != "true" || w.Header().Get("Sunset") != "Tue, 01 Jan 2030 00:00:00 GMT" {
+		t.Error("1517384495 missing deprecation headers", w.Header())
+	}
+}
+
+func TestRouteTableEndpoint(t *testing.T) {
+	router := NewRouter()
+	router.BasePath = "/api/"
+	router.PostProcessors = nil
+	router.RegisterEntity("author", &AuthorController{})
+	router.EnableRouteTableEndpoint("", &BookController{}) // BookController always fails auth
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://localhost/api/v1/admin/routes", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Error("1517384496 expected 403, got", w.Code)
+	}
+
+	router = NewRouter()
+	router.BasePath = "/api/"
+	router.PostProcessors = nil
+	router.RegisterEntity("author", &AuthorController{})
+	router.EnableRouteTableEndpoint("", &allowAllAuthenticator{})
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	body, _ := ioutil.ReadAll(w.Body)
+	pw, err := UnmarshalPayloadWrapper(body, RouteInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || len(pw.Payloads["route"]) != router.AllRoutesCount() {
+		t.Error("1517384497 unexpected route table", w.Code, string(body))
+	}
+}
+
+type allowAllAuthenticator struct{}
+
+func (auth *allowAllAuthenticator) PerformAuth(routePtr *Route, ctx *Context) (bool, int) {
+	return true, 0
+}
+func (auth *allowAllAuthenticator) GetSecretKey(publicKey string) (string, int) {
+	return "", 0
+}
@@ -33,6 +33,16 @@ type Router struct {
 	MiddlewareProcessors []MiddlewareProcessor
 	PostProcessors       []PostProcessor
 
+	Encoders []Encoder // response encodings, in order of preference.  see RegisterEncoder
+	Decoders []Decoder // request body encodings, selected by Content-Type.  see RegisterDecoder
+
+	StreamThreshold int // responses larger than this are streamed.  0 means DefaultStreamThreshold, negative never streams
+
+	ProblemDetails     bool   // error responses are RFC 7807 application/problem+json for every client, not just those that ask
+	ProblemTypeBaseURI string // problem types are this followed by the error number.  "" means DefaultProblemTypeBaseURI
+
+	WebSocketOptions WebSocketOptions // for WebSocketHandler routes.  zero values mean the defaults
+
 	Controllers map[string]PayloadController // key is entity name
 	RouteMap    map[string]*Route            // key is entity name
 }
@@ -48,6 +58,8 @@ func NewRouter() *Router {
 	router.PostProcessors = []PostProcessor{
 		new(CommonLogger),
 	}
+	router.Encoders = []Encoder{JSONEncoder{}, NDJSONEncoder{}, SSEEncoder{}, JSONAPIEncoder{}, ProblemEncoder{}}
+	router.Decoders = []Decoder{JSONDecoder{}}
 	return router
 }
 
@@ -98,6 +110,10 @@ func (router *Router) AddEntityRoute(entityName, controllerName, handlerName str
 	}
 
 	isValid, reason, handler := ValidateHandler(unknownhandler)
+	wsHandler, isWebSocket := validateWebSocketHandler(unknownhandler)
+	if isWebSocket {
+		isValid, reason, handler = true, "", webSocketRouteHandler(wsHandler)
+	}
 	if isValid == false {
 		errNum := int64(3230075622)
 		errMsg := fmt.Sprintln(errNum, "Handler Validation Failure:", "entityName:", entityName, "controllerName:", controllerName, "Invalid Handler:", handlerName, "reason:", reason)
@@ -129,6 +145,13 @@ func (router *Router) AddEntityRoute(entityName, controllerName, handlerName str
 	// step 2 Find method
 	var versionActionHandlerName string
 	switch {
+	case isWebSocket != strings.HasPrefix(deauthedHandlerName, MAGIC_WEBSOCKET_HANDLER_PREFIX):
+		log.Println("2650341808 Skipping Route, WebSocketHandler prefix and signature must go together:", entityName, controllerName, handlerName)
+		return
+	case isWebSocket:
+		routePtr.Method = "GET"
+		routePtr.WebSocket = true
+		versionActionHandlerName = deauthedHandlerName[len(MAGIC_WEBSOCKET_HANDLER_PREFIX):]
 	case strings.HasPrefix(deauthedHandlerName, MAGIC_GET_HANDLER_PREFIX):
 		routePtr.Method = "GET"
 		versionActionHandlerName = deauthedHandlerName[len(MAGIC_GET_HANDLER_PREFIX):]
@@ -173,6 +196,11 @@ func (router *Router) AddEntityRoute(entityName, controllerName, handlerName str
 	routePtr.Path += action
 	routePtr.VersionStr = versionStr
 
+	// eg WebSocketHandlerV1Edit and GetHandlerV1Edit are both GET v1 <entity>/edit
+	if existing, _ := getRoute(router.RouteMap, routePtr.Method, routePtr.VersionStr, routePtr.EntityName, routePtr.Action); existing != nil {
+		log.Fatalln("1411397819 entity name:", routePtr.EntityName, "method:", routePtr.Method, "route:", routePtr.Path, "Handler:", handlerName, "conflicts with:", existing.HandlerName)
+	}
+
 	setRoute(router.RouteMap, routePtr.Method, routePtr.VersionStr, routePtr.Action, routePtr)
 }
 
@@ -277,13 +305,28 @@ func (router *Router) LogAllRoutes(addons ...string) {
 
 func (router *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	// 1. Any pre-handler stuff
-	// TODO
 
 	ctx := new(Context) // needs a leakybucket
 	ctx.w = w
 	ctx.Req = req
 	ctx.router = router
 
+	if captureLimit := router.captureLimit(); captureLimit > 0 {
+		// some PostProcessor needs the bodies, eg a TrafficRecorder.  the request body waits until after auth
+		ctx.captureResponse(captureLimit)
+	}
+
+	if len(router.Encoders) > 1 {
+		ctx.AddHeader("Vary", httpHeaderAccept)
+	}
+	ctx.encoder = router.negotiateEncoder(req.Header.Get(httpHeaderAccept))
+	if ctx.encoder == nil {
+		// nothing the client accepts, so the error goes out in the default encoding
+		ctx.encoder = router.defaultEncoder()
+		ctx.SendSimpleErrorPayload(http.StatusNotAcceptable, NotAcceptableErrorNumber, NotAcceptablePrefix)
+		return
+	}
+
 	// 2. parse the route
 	endpoint, clientDeepErr, serverDeepErr := parsePath(req.URL, router.BasePath)
 	ctx.End = endpoint
@@ -324,6 +367,10 @@ func (router *Router) handleContext(ctx *Context, req *http.Request) {
 		return
 	}
 
+	if routePtr.Deprecation != nil {
+		setDeprecationHeaders(ctx, routePtr.Deprecation)
+	}
+
 	// log.Println("req.Method", req.Method)
 	// log.Println("ctx.End.PrimaryKey", ctx.End.PrimaryKey)
 	// log.Println("ctx.End.Extras", ctx.End.Extras)
@@ -348,12 +395,15 @@ func (router *Router) handleContext(ctx *Context, req *http.Request) {
 
 	if routePtr.RequiresAuth {
 		// log.Println("RequiresAuth = true")
-		isAuthorized, failureToAuthErrorNum := routePtr.Authenticator.PerformAuth(routePtr, ctx)
+		isAuthorized, failureToAuthErrorNum := routeAuthHandler(routePtr, req).PerformAuth(routePtr, ctx)
 		if isAuthorized == false {
 			ctx.SendSimpleErrorPayload(http.StatusForbidden, int64(failureToAuthErrorNum), "Forbidden")
 			return
 		}
 	}
+	if ctx.capturedResponse != nil {
+		ctx.captureRequestBody()
+	}
 
 	// 6. Middleware
 
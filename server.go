@@ -0,0 +1,283 @@
+package grunway
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ServerOptions configures the underlying http.Server and the shutdown behavior.
+// Zero values mean "use the http.Server default" (ie no timeout), except where noted.
+type ServerOptions struct {
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+
+	// How long to wait for in-flight requests to finish once shutdown starts.  0 means wait forever.
+	DrainTimeout time.Duration
+
+	// Signals that trigger a graceful shutdown.  nil means SIGINT and SIGTERM.
+	ShutdownSignals       []os.Signal
+	DisableSignalHandling bool
+
+	ErrorLog *log.Logger // optional, passed through to http.Server
+
+	TLS *TLSOptions // optional.  if set, serve HTTPS (and optionally mTLS)
+}
+
+func DefaultServerOptions() ServerOptions {
+	return ServerOptions{
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      60 * time.Second,
+		IdleTimeout:       120 * time.Second,
+		MaxHeaderBytes:    1 << 20,
+		DrainTimeout:      30 * time.Second,
+	}
+}
+
+type Server struct {
+	Router  *Router
+	Options ServerOptions
+
+	mu            sync.Mutex
+	httpServer    *http.Server
+	shutdownFuncs []namedShutdownFunc
+	shuttingDown  bool // set by the first Shutdown, so a later ServeListeners doesn't start
+
+	shutdownOnce sync.Once
+	shutdownDone chan struct{}
+	shutdownErr  error
+}
+
+type namedShutdownFunc struct {
+	name string
+	fn   func() error
+}
+
+func NewServer(routerPtr *Router, options ServerOptions) *Server {
+	server := new(Server)
+	server.Router = routerPtr
+	server.Options = options
+	server.shutdownDone = make(chan struct{})
+	return server
+}
+
+// RegisterShutdownFunc adds a func to be called after in-flight requests have drained.
+// Funcs are called in the order they were registered, eg:
+//
+//	server.RegisterShutdownFunc("accountstore", accountStore.Shutdown)
+func (server *Server) RegisterShutdownFunc(name string, fn func() error) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.shutdownFuncs = append(server.shutdownFuncs, namedShutdownFunc{name, fn})
+}
+
+//  #####
+// #     # ###### #####  #    # ######
+// #       #      #    # #    # #
+//  #####  #####  #    # #    # #####
+//       # #      #####  #    # #
+// #     # #      #   #   #  #  #
+//  #####  ###### #    #   ##   ######
+//
+
+// ListenAndServe listens on the TCP address host and then calls Serve.
+func (server *Server) ListenAndServe(ctx context.Context, host string) error {
+	if err := validateRouterForStart(server.Router); err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", host)
+	if err != nil {
+		return fmt.Errorf("3621140793 net.Listen returned error: %v", err)
+	}
+	return server.Serve(ctx, listener)
+}
+
+// Serve blocks until ctx is done, a shutdown signal arrives, Shutdown is called, or the listener fails.
+// Then in-flight requests are drained (up to DrainTimeout) and the registered shutdown funcs are called.
+// Returns nil after a clean shutdown.
+func (server *Server) Serve(ctx context.Context, listener net.Listener) error {
+	return server.ServeListeners(ctx, listener)
+}
+
+// ServeListeners is Serve for several listeners at once (eg TCP and a unix socket, or inherited fds).
+// All listeners share one http.Server and are shut down together.
+func (server *Server) ServeListeners(ctx context.Context, listeners ...net.Listener) error {
+	closeAll := func() {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+	}
+	if err := validateRouterForStart(server.Router); err != nil {
+		closeAll()
+		return err
+	}
+	if len(listeners) == 0 {
+		return fmt.Errorf("3621140797 no listeners")
+	}
+	httpServer := server.newHTTPServer()
+
+	if server.Options.TLS.enabled() {
+		tlsConfig, err := server.Options.TLS.buildConfig()
+		if err != nil {
+			closeAll()
+			return err
+		}
+		// ServeTLS wraps the listeners itself, and adds h2 to NextProtos
+		httpServer.TLSConfig = tlsConfig
+	}
+
+	addrs := make([]net.Addr, 0, len(listeners))
+	for _, listener := range listeners {
+		addrs = append(addrs, listener.Addr())
+	}
+
+	server.mu.Lock()
+	if server.shuttingDown {
+		server.mu.Unlock()
+		closeAll()
+		return http.ErrServerClosed
+	}
+	server.httpServer = httpServer
+	server.mu.Unlock()
+
+	logStartup(server.Router, addrs...)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	signals := make(chan os.Signal, 1)
+	if server.Options.DisableSignalHandling == false {
+		shutdownSignals := server.Options.ShutdownSignals
+		if shutdownSignals == nil {
+			shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+		}
+		signal.Notify(signals, shutdownSignals...)
+		defer signal.Stop(signals)
+	}
+
+	serveErrs := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		go func(listener net.Listener) {
+			if httpServer.TLSConfig != nil {
+				serveErrs <- httpServer.ServeTLS(listener, "", "")
+			} else {
+				serveErrs <- httpServer.Serve(listener)
+			}
+		}(listener)
+	}
+
+	select {
+	case err := <-serveErrs:
+		if err == http.ErrServerClosed {
+			// someone called Shutdown directly.  wait for them to finish.
+			<-server.shutdownDone
+			return server.shutdownErr
+		}
+		server.Shutdown()
+		return fmt.Errorf("3621140792 http.Server.Serve returned error: %v", err)
+	case sig := <-signals:
+		log.Println("Received", sig, "shutting down")
+	case <-ctx.Done():
+		log.Println("Context done, shutting down")
+	}
+
+	return server.Shutdown()
+}
+
+// Shutdown stops accepting connections, waits up to DrainTimeout for in-flight requests,
+// then calls the registered shutdown funcs in order.  Safe to call more than once, only the first call does anything.
+// Called before Serve, it makes Serve return http.ErrServerClosed right away.
+func (server *Server) Shutdown() error {
+	server.shutdownOnce.Do(func() {
+		defer close(server.shutdownDone)
+
+		server.mu.Lock()
+		server.shuttingDown = true
+		httpServer := server.httpServer
+		shutdownFuncs := server.shutdownFuncs
+		server.mu.Unlock()
+
+		var errs []string
+		if httpServer != nil {
+			drainCtx := context.Background()
+			if server.Options.DrainTimeout > 0 {
+				var cancel context.CancelFunc
+				drainCtx, cancel = context.WithTimeout(drainCtx, server.Options.DrainTimeout)
+				defer cancel()
+			}
+			err := httpServer.Shutdown(drainCtx)
+			if err != nil {
+				// drain timed out.  cut off whoever is left.
+				httpServer.Close()
+				errs = append(errs, fmt.Sprintf("3621140794 drain: %v", err))
+			}
+		}
+
+		for _, shutdownFunc := range shutdownFuncs {
+			err := shutdownFunc.fn()
+			if err != nil {
+				log.Println("3621140795 shutdown func", shutdownFunc.name, "returned error:", err)
+				errs = append(errs, fmt.Sprintf("%s: %v", shutdownFunc.name, err))
+			}
+		}
+
+		if len(errs) > 0 {
+			server.shutdownErr = fmt.Errorf("3621140796 shutdown errors: %v", errs)
+		}
+	})
+
+	<-server.shutdownDone
+	return server.shutdownErr
+}
+
+func (server *Server) newHTTPServer() *http.Server {
+	return &http.Server{
+		Handler:           server.Router,
+		ReadTimeout:       server.Options.ReadTimeout,
+		ReadHeaderTimeout: server.Options.ReadHeaderTimeout,
+		WriteTimeout:      server.Options.WriteTimeout,
+		IdleTimeout:       server.Options.IdleTimeout,
+		MaxHeaderBytes:    server.Options.MaxHeaderBytes,
+		ErrorLog:          server.Options.ErrorLog,
+	}
+}
+
+// #     #
+// #     # ##### # #      # ##### # ######  ####
+// #     #   #   # #      #   #   # #      #
+// #     #   #   # #      #   #   # #####   ####
+// #     #   #   # #      #   #   # #           #
+// #     #   #   # #      #   #   # #      #    #
+//  #####    #   # ###### #   #   # ######  ####
+//
+
+func validateRouterForStart(routerPtr *Router) error {
+	if routerPtr == nil || routerPtr.AllRoutesCount() == 0 {
+		return fmt.Errorf("3621140790 Router has no valid routes defined")
+	}
+	return nil
+}
+
+func logStartup(routerPtr *Router, addrs ...net.Addr) {
+	log.Printf("Starting Grunway Server (%v, grunway v%v(%v))", runtime.Version(), Version(), BuildNumber())
+
+	log.Println("All Routes:")
+	routerPtr.LogAllRoutes("↳ Route:")
+
+	for _, addr := range addrs {
+		log.Println("Listening from ", addr.Network(), addr)
+	}
+	time.Sleep(100 * time.Millisecond) // give the log statements time to print...
+}
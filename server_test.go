@@ -0,0 +1,118 @@
+package grunway
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type SlowController struct {
+	started chan struct{}
+}
+
+func (sc *SlowController) GetHandlerV1(ctx *Context) RouteHandlerResult {
+	close(sc.started)
+	time.Sleep(200 * time.Millisecond)
+	return ctx.MakeRouteHandlerResultOk()
+}
+
+func TestServerGracefulShutdown(t *testing.T) {
+	slow := &SlowController{started: make(chan struct{})}
+	router := NewRouter()
+	router.BasePath = "/api/"
+	router.PostProcessors = nil
+	router.RegisterEntity("slow", slow)
+
+	options := DefaultServerOptions()
+	options.DisableSignalHandling = true
+	server := NewServer(router, options)
+
+	var order []string
+	server.RegisterShutdownFunc("first", func() error { order = append(order, "first"); return nil })
+	server.RegisterShutdownFunc("second", func() error { order = append(order, "second"); return nil })
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(ctx, listener) }()
+
+	responses := make(chan int, 1)
+	go func() {
+		response, err := http.Get("http://" + listener.Addr().String() + "/api/v1/slow/1")
+		if err != nil {
+			responses <- 0
+			return
+		}
+		ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		responses <- response.StatusCode
+	}()
+
+	<-slow.started
+	cancel()
+
+	if code := <-responses; code != http.StatusOK {
+		t.Error("1730592281 in-flight request should have drained, got", code)
+	}
+	if err := <-served; err != nil {
+		t.Error("1730592282 expected clean shutdown, got", err)
+	}
+	if reflect.DeepEqual(order, []string{"first", "second"}) == false {
+		t.Error("1730592283 shutdown funcs called out of order:", order)
+	}
+
+	// second call is a no-op
+	if err := server.Shutdown(); err != nil {
+		t.Error("1730592284", err)
+	}
+}
+
+func TestServerEmptyRouter(t *testing.T) {
+	server := NewServer(NewRouter(), DefaultServerOptions())
+	if err := server.ListenAndServe(context.Background(), "127.0.0.1:0"); err == nil {
+		t.Error("1730592285 expected error for router without routes")
+	}
+}
+
+func TestServerShutdownBeforeServe(t *testing.T) {
+	router := NewRouter()
+	router.BasePath = "/api/"
+	router.PostProcessors = nil
+	router.RegisterEntity("slow", &SlowController{started: make(chan struct{})})
+
+	options := DefaultServerOptions()
+	options.DisableSignalHandling = true
+	server := NewServer(router, options)
+	if err := server.Shutdown(); err != nil {
+		t.Fatal("1730592286", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(context.Background(), listener) }()
+	select {
+	case err := <-served:
+		if err != http.ErrServerClosed {
+			t.Error("1730592287 expected http.ErrServerClosed, got", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("1730592288 Serve kept running after Shutdown")
+	}
+
+	// and the listener was closed, not left serving
+	if conn, err := net.Dial("tcp", listener.Addr().String()); err == nil {
+		conn.Close()
+		t.Error("1730592289 listener still accepting connections")
+	}
+}
@@ -0,0 +1,120 @@
+package grunway
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A simple HMAC-SHA256 request signing scheme.
+//
+// The client sends three headers:
+//
+//	Grunway-PublicKey: <public key>
+//	Grunway-Timestamp: <unix seconds>
+//	Grunway-Signature: base64(HMAC-SHA256(secretKey, stringToSign))
+//
+// where stringToSign is:
+//
+//	METHOD + "\n" + RequestURI + "\n" + timestamp + "\n" + hex(sha256(body))
+
+const (
+	HTTPHeaderPublicKey = "Grunway-PublicKey"
+	HTTPHeaderTimestamp = "Grunway-Timestamp"
+	HTTPHeaderSignature = "Grunway-Signature"
+
+	DefaultSignatureMaxSkew = 5 * time.Minute
+
+	// signed bodies are read into memory to be hashed
+	DefaultSignatureMaxBodyBytes = 10 << 20
+
+	SignatureMissingErrorNumber      = 4030000001
+	SignatureTimestampErrorNumber    = 4030000002
+	SignatureUnknownKeyErrorNumber   = 4030000003
+	SignatureMismatchErrorNumber     = 4030000004
+	SignatureBodyErrorNumber         = 4030000005
+	SignatureBodyTooLargeErrorNumber = 4030000006
+)
+
+// SignRequest sets the signature headers on req.  body must be the exact bytes that will be sent.
+func SignRequest(req *http.Request, publicKey, secretKey string, body []byte, now time.Time) {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	req.Header.Set(HTTPHeaderPublicKey, publicKey)
+	req.Header.Set(HTTPHeaderTimestamp, timestamp)
+	req.Header.Set(HTTPHeaderSignature, computeRequestSignature(secretKey, req.Method, req.URL.RequestURI(), timestamp, body))
+}
+
+// VerifyRequestSignature checks the signature headers against the secret returned by getSecretKey.
+// The body is read and then restored, so handlers can still read ctx.Req.Body.
+// Bodies over DefaultSignatureMaxBodyBytes are rejected, see VerifyRequestSignatureWithLimit.
+// returns the public key the request was signed with.
+func VerifyRequestSignature(ctx *Context, getSecretKey func(publicKey string) (string, int), maxSkew time.Duration, now time.Time) (publicKey string, isValid bool, errNum int) {
+	return VerifyRequestSignatureWithLimit(ctx, getSecretKey, maxSkew, DefaultSignatureMaxBodyBytes, now)
+}
+
+// VerifyRequestSignatureWithLimit is VerifyRequestSignature w/ a cap on the body size.  maxBodyBytes <= 0 means the default.
+func VerifyRequestSignatureWithLimit(ctx *Context, getSecretKey func(publicKey string) (string, int), maxSkew time.Duration, maxBodyBytes int64, now time.Time) (publicKey string, isValid bool, errNum int) {
+	if maxBodyBytes <= 0 {
+		maxBodyBytes = DefaultSignatureMaxBodyBytes
+	}
+	req := ctx.Req
+	publicKey = req.Header.Get(HTTPHeaderPublicKey)
+	timestamp := req.Header.Get(HTTPHeaderTimestamp)
+	signature := req.Header.Get(HTTPHeaderSignature)
+	if publicKey == "" || timestamp == "" || signature == "" {
+		return "", false, SignatureMissingErrorNumber
+	}
+
+	unixSeconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", false, SignatureTimestampErrorNumber
+	}
+	skew := now.Sub(time.Unix(unixSeconds, 0))
+	if skew > maxSkew || skew < -maxSkew {
+		return "", false, SignatureTimestampErrorNumber
+	}
+
+	secretKey, errNum := getSecretKey(publicKey)
+	if errNum != 0 || secretKey == "" {
+		if errNum == 0 {
+			errNum = SignatureUnknownKeyErrorNumber
+		}
+		return "", false, errNum
+	}
+
+	var body []byte
+	if req.Body != nil {
+		// no effect if something already read the body, hence the length check as well
+		req.Body = http.MaxBytesReader(ctx.w, req.Body, maxBodyBytes)
+		body, err = ctx.RequestBody()
+		if _, tooLarge := err.(*http.MaxBytesError); tooLarge || int64(len(body)) > maxBodyBytes {
+			return "", false, SignatureBodyTooLargeErrorNumber
+		}
+		if err != nil {
+			return "", false, SignatureBodyErrorNumber
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	expected := computeRequestSignature(secretKey, req.Method, req.URL.RequestURI(), timestamp, body)
+	if hmac.Equal([]byte(expected), []byte(strings.TrimSpace(signature))) == false {
+		return "", false, SignatureMismatchErrorNumber
+	}
+	return publicKey, true, 0
+}
+
+func computeRequestSignature(secretKey, method, requestURI, timestamp string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	stringToSign := strings.Join([]string{method, requestURI, timestamp, hex.EncodeToString(bodyHash[:])}, "\n")
+
+	mac := hmac.New(sha256.New, []byte(secretKey))
+	mac.Write([]byte(stringToSign))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,254 @@
+package grunway
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amattn/deeperror"
+)
+
+const (
+	httpHeaderContentTypeEventStream = "text/event-stream"
+	httpHeaderLastEventID            = "Last-Event-ID"
+
+	DefaultSSEHeartbeat = 15 * time.Second
+
+	SSEUnsupportedErrorNumber = 5000000502 // the ResponseWriter can't flush
+)
+
+// SSEEvent is one Server-Sent Event.  Data is the Payload encoded as JSON.
+type SSEEvent struct {
+	ID      string // optional.  sent as id:, and what the client sends back as Last-Event-ID
+	Event   string // optional.  defaults to Payload.PayloadType()
+	Payload Payload
+	Retry   time.Duration // optional reconnection hint
+}
+
+// SSEReplaySource supplies the events a reconnecting client missed.
+// EventsSince gets the client's Last-Event-ID and returns the events after it, oldest first.
+type SSEReplaySource interface {
+	EventsSince(lastEventID string) ([]SSEEvent, error)
+}
+
+type SSEOptions struct {
+	Retry     time.Duration   // optional reconnection hint sent when the stream opens
+	Heartbeat time.Duration   // comment line sent when idle, keeps proxies from timing out.  0 means DefaultSSEHeartbeat, negative disables
+	Replay    SSEReplaySource // optional, consulted when the request has a Last-Event-ID
+}
+
+// SSEEncoder lets EventSource clients (Accept: text/event-stream) through negotiation.  Streams are written by
+// MakeRouteHandlerResultSSE.  Anything else, eg an error before the stream opens, is sent as a single event.
+// Registered by default, but only used when the Accept header names text/event-stream.
+type SSEEncoder struct{}
+
+func (encoder SSEEncoder) MediaType() string {
+	return httpHeaderContentTypeEventStream
+}
+func (encoder SSEEncoder) namedOnly() {}
+func (encoder SSEEncoder) Encode(v interface{}) ([]byte, error) {
+	data, err := JSONEncoder{}.Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	buffer := new(bytes.Buffer)
+	writeSSEData(buffer, data)
+	return buffer.Bytes(), nil
+}
+
+func writeSSEData(buffer *bytes.Buffer, data []byte) {
+	for _, line := range strings.Split(string(data), "\n") {
+		buffer.WriteString("data: ")
+		buffer.WriteString(line)
+		buffer.WriteString("\n")
+	}
+	buffer.WriteString("\n")
+}
+
+func encodeSSEEvent(event SSEEvent) ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	if event.ID != "" {
+		buffer.WriteString("id: " + sseFieldValue(event.ID) + "\n")
+	}
+	name := event.Event
+	if name == "" && event.Payload != nil {
+		name = event.Payload.PayloadType()
+	}
+	if name != "" {
+		buffer.WriteString("event: " + sseFieldValue(name) + "\n")
+	}
+	if event.Retry > 0 {
+		buffer.WriteString("retry: " + strconv.FormatInt(int64(event.Retry/time.Millisecond), 10) + "\n")
+	}
+	data, err := json.Marshal(event.Payload)
+	if err != nil {
+		return nil, err
+	}
+	writeSSEData(buffer, data)
+	return buffer.Bytes(), nil
+}
+
+// ids and event names are single line fields
+func sseFieldValue(value string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+}
+
+// LastEventID is the id of the last Server-Sent Event a reconnecting client saw, "" for new connections.
+func (ctx *Context) LastEventID() string {
+	return ctx.Req.Header.Get(httpHeaderLastEventID)
+}
+
+// MakeRouteHandlerResultSSE streams events until the channel is closed or the client goes away.
+// If the request has a Last-Event-ID and options.Replay is set, the missed events are sent first.
+// Producers should stop when ctx.Req.Context() is done, the stream no longer reads from events after that.
+func (ctx *Context) MakeRouteHandlerResultSSE(events <-chan SSEEvent, options SSEOptions) RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultCustom(func(innerCtx *Context) {
+		writeSSE(innerCtx, events, options)
+	})
+}
+
+func writeSSE(ctx *Context, events <-chan SSEEvent, options SSEOptions) {
+	if ctx.written == true {
+		log.Println(deeperror.New(2218304471, "ERROR attempt to write multiple times to same writer", nil))
+		return
+	}
+	flusher, canFlush := ctx.w.(http.Flusher)
+	if canFlush == false {
+		ctx.SendSimpleErrorPayload(http.StatusInternalServerError, SSEUnsupportedErrorNumber, InternalServerErrorPrefix)
+		return
+	}
+
+	// replay before committing to a 200, so a failing source is still a proper error
+	var replayed []SSEEvent
+	if lastEventID := ctx.LastEventID(); lastEventID != "" && options.Replay != nil {
+		var err error
+		replayed, err = options.Replay.EventsSince(lastEventID)
+		if err != nil {
+			rhr := ctx.MakeRouteHandlerResultFromError(err)
+			ctx.SendErrorInfoPayload(rhr.rerr.statusCode, rhr.rerr.errorInfo)
+			return
+		}
+	}
+
+	ctx.written = true
+	ctx.SetHeader(httpHeaderContentType, httpHeaderContentTypeEventStream)
+	ctx.SetHeader("Cache-Control", "no-cache")
+	ctx.SetHeader("X-Accel-Buffering", "no") // nginx
+	ctx.StatusCode = http.StatusOK
+	ctx.clearWriteDeadline()
+	ctx.w.WriteHeader(http.StatusOK)
+
+	var writeErr error
+	write := func(p []byte) {
+		if writeErr != nil {
+			return
+		}
+		n, err := ctx.w.Write(p)
+		ctx.ContentLength += n
+		writeErr = err
+	}
+	send := func(event SSEEvent) {
+		encoded, err := encodeSSEEvent(event)
+		if err != nil {
+			log.Println("2218304472 cannot encode event", event.ID, err)
+			return
+		}
+		write(encoded)
+	}
+
+	if options.Retry > 0 {
+		write([]byte("retry: " + strconv.FormatInt(int64(options.Retry/time.Millisecond), 10) + "\n\n"))
+	}
+	for _, event := range replayed {
+		send(event)
+	}
+	flusher.Flush()
+
+	heartbeat := options.Heartbeat
+	if heartbeat == 0 {
+		heartbeat = DefaultSSEHeartbeat
+	}
+	var heartbeats <-chan time.Time
+	if heartbeat > 0 {
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+		heartbeats = ticker.C
+	}
+
+	done := ctx.Req.Context().Done()
+	for writeErr == nil {
+		select {
+		case <-done:
+			writeErr = ctx.Req.Context().Err()
+			continue
+		case event, open := <-events:
+			if open == false {
+				runPostProcessors(ctx)
+				return
+			}
+			send(event)
+		case <-heartbeats:
+			write([]byte(": heartbeat\n\n"))
+		}
+		flusher.Flush()
+	}
+	// client went away
+	runPostProcessors(ctx)
+}
+
+//  #####   #####  #######    #     #
+// #     # #     # #          #     # #  ####  #####  ####  #####  #   #
+// #       #       #          #     # # #        #   #    # #    #  # #
+//  #####   #####  #####      ####### #  ####    #   #    # #    #   #
+//       #       # #          #     # #      #   #   #    # #####    #
+// #     # #     # #          #     # # #    #   #   #    # #   #    #
+//  #####   #####  #######    #     # #  ####    #    ####  #    #   #
+//
+
+// SSEHistory is an in-memory SSEReplaySource that keeps the last Capacity events.
+// Add assigns sequential ids to events that don't have one.
+type SSEHistory struct {
+	Capacity int
+
+	mu     sync.Mutex
+	events []SSEEvent
+	nextID uint64
+}
+
+func NewSSEHistory(capacity int) *SSEHistory {
+	history := new(SSEHistory)
+	history.Capacity = capacity
+	return history
+}
+
+// Add records event and returns it w/ its id.
+func (history *SSEHistory) Add(event SSEEvent) SSEEvent {
+	history.mu.Lock()
+	defer history.mu.Unlock()
+	history.nextID++
+	if event.ID == "" {
+		event.ID = strconv.FormatUint(history.nextID, 10)
+	}
+	history.events = append(history.events, event)
+	if history.Capacity > 0 && len(history.events) > history.Capacity {
+		history.events = history.events[len(history.events)-history.Capacity:]
+	}
+	return event
+}
+
+// EventsSince returns the events after lastEventID.  If that event has already been dropped, everything kept is returned.
+func (history *SSEHistory) EventsSince(lastEventID string) ([]SSEEvent, error) {
+	history.mu.Lock()
+	defer history.mu.Unlock()
+	for i := len(history.events) - 1; i >= 0; i-- {
+		if history.events[i].ID == lastEventID {
+			return append([]SSEEvent{}, history.events[i+1:]...), nil
+		}
+	}
+	return append([]SSEEvent{}, history.events...), nil
+}
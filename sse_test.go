@@ -0,0 +1,133 @@
+package grunway
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type orderStatusPayload struct {
+	Status string
+}
+
+func (payload orderStatusPayload) PayloadType() string {
+	return "orderstatus"
+}
+
+type orderFeedController struct {
+	history *SSEHistory
+	live    []string
+	wait    bool // keep the channel open until the client goes away
+}
+
+func (controller *orderFeedController) GetHandlerV1Feed(ctx *Context) RouteHandlerResult {
+	events := make(chan SSEEvent)
+	go func() {
+		defer close(events)
+		for _, status := range controller.live {
+			event := controller.history.Add(SSEEvent{Payload: orderStatusPayload{status}})
+			select {
+			case events <- event:
+			case <-ctx.Req.Context().Done():
+				return
+			}
+		}
+		if controller.wait {
+			<-ctx.Req.Context().Done()
+		}
+	}()
+	return ctx.MakeRouteHandlerResultSSE(events, SSEOptions{Retry: 2 * time.Second, Heartbeat: 5 * time.Millisecond, Replay: controller.history})
+}
+
+func TestSSE(t *testing.T) {
+	feed := &orderFeedController{history: NewSSEHistory(10)}
+	router := NewRouter()
+	router.BasePath = "/api/"
+	router.PostProcessors = nil
+	router.RegisterEntity("orders", feed)
+
+	get := func(lastEventID string, reqCtx context.Context) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://localhost/api/v1/orders/feed", nil)
+		if reqCtx != nil {
+			req = req.WithContext(reqCtx)
+		}
+		req.Header.Set("Accept", httpHeaderContentTypeEventStream)
+		if lastEventID != "" {
+			req.Header.Set(httpHeaderLastEventID, lastEventID)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	feed.live = []string{"placed", "packed"}
+	w := get("", nil)
+	expected := "retry: 2000\n\n" +
+		"id: 1\nevent: orderstatus\ndata: {\"Status\":\"placed\"}\n\n" +
+		"id: 2\nevent: orderstatus\ndata: {\"Status\":\"packed\"}\n\n"
+	if w.Code != http.StatusOK || w.Header().Get(httpHeaderContentType) != httpHeaderContentTypeEventStream || strings.Replace(w.Body.String(), ": heartbeat\n\n", "", -1) != expected {
+		t.Errorf("2218304490 unexpected stream %d %v\n%s", w.Code, w.Header(), w.Body.String())
+	}
+
+	// resume: event 2 is replayed, then the live ones
+	feed.live = []string{"shipped"}
+	w = get("1", nil)
+	body := w.Body.String()
+	if strings.Contains(body, "id: 1\n") || strings.Index(body, "id: 2\n") > strings.Index(body, "id: 3\n") || strings.Contains(body, "shipped") == false {
+		t.Error("2218304491 expected the missed event before the live one", body)
+	}
+
+	// heartbeats while idle, and a clean end when the client goes away
+	feed.live, feed.wait = nil, true
+	reqCtx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	w = get("", reqCtx)
+	if strings.Contains(w.Body.String(), ": heartbeat\n\n") == false {
+		t.Error("2218304492 expected heartbeats", w.Body.String())
+	}
+
+	// errors before the stream opens go out as a single event
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://localhost/api/v1/orders/missing", nil)
+	req.Header.Set("Accept", httpHeaderContentTypeEventStream)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound || strings.HasPrefix(w.Body.String(), "data: {\"errorNumber\":4040000404") == false {
+		t.Error("2218304493 unexpected error event", w.Code, w.Body.String())
+	}
+}
+
+func TestSSEOutlivesWriteTimeout(t *testing.T) {
+	feed := &orderFeedController{history: NewSSEHistory(10), live: []string{"placed"}, wait: true}
+	router := NewRouter()
+	router.BasePath = "/api/"
+	router.RegisterEntity("orders", feed)
+	// recording wraps the writer, the deadline still has to reach the connection
+	router.PostProcessors = []PostProcessor{NewTrafficRecorder(ioutil.Discard, TrafficRecorderOptions{})}
+
+	server := httptest.NewUnstartedServer(router)
+	server.Config.WriteTimeout = 100 * time.Millisecond
+	server.Start()
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL+"/api/v1/orders/feed", nil)
+	req.Header.Set("Accept", httpHeaderContentTypeEventStream)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("2218304494", err)
+	}
+	defer resp.Body.Close()
+
+	// heartbeats keep coming well past the write timeout
+	start := time.Now()
+	reader := bufio.NewReader(resp.Body)
+	for time.Since(start) < 300*time.Millisecond {
+		if _, err := reader.ReadString('\n'); err != nil {
+			t.Fatal("2218304495 stream cut off after", time.Since(start), err)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package grunway
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"log"
+	"sort"
+)
+
+const DefaultStreamThreshold = 64 * 1024
+
+// StreamEncoder is an Encoder that can write straight to the response instead of building the whole body in memory.
+// JSONEncoder and XMLEncoder implement it.
+type StreamEncoder interface {
+	Encoder
+	EncodeTo(w io.Writer, v interface{}) error
+}
+
+// EncodeTo writes a PayloadWrapper one payload at a time.  The output is identical to Encode.
+func (encoder JSONEncoder) EncodeTo(w io.Writer, v interface{}) error {
+	pw, isPayloadWrapper := v.(*PayloadWrapper)
+	if isPayloadWrapper == false || pw == nil || len(pw.Payloads) == 0 {
+		encoded, err := encoder.Encode(v)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(encoded)
+		return err
+	}
+
+	// Payloads is the first field, so everything else can be marshalled as usual and spliced in after it
+	rest := *pw
+	rest.Payloads = nil
+	restBytes, err := MarshallPayloadWrapper(&rest)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, `{"Payloads":`)
+	if err != nil {
+		return err
+	}
+	err = writeJSONPayloadsMap(w, pw.Payloads)
+	if err != nil {
+		return err
+	}
+	if len(restBytes) > 2 {
+		_, err = w.Write(append([]byte(","), restBytes[1:]...))
+	} else {
+		_, err = io.WriteString(w, "}")
+	}
+	return err
+}
+
+// same output as json.Marshal(pmap): sorted keys, nil lists as null
+func writeJSONPayloadsMap(w io.Writer, pmap PayloadsMap) error {
+	payloadTypes := make([]string, 0, len(pmap))
+	for payloadType := range pmap {
+		payloadTypes = append(payloadTypes, payloadType)
+	}
+	sort.Strings(payloadTypes)
+
+	separator := "{"
+	for _, payloadType := range payloadTypes {
+		keyBytes, err := json.Marshal(payloadType)
+		if err != nil {
+			return err
+		}
+		if _, err = io.WriteString(w, separator); err != nil {
+			return err
+		}
+		if _, err = w.Write(append(keyBytes, ':')); err != nil {
+			return err
+		}
+		separator = ","
+
+		payloads := pmap[payloadType]
+		if payloads == nil {
+			if _, err = io.WriteString(w, "null"); err != nil {
+				return err
+			}
+			continue
+		}
+		for i, payload := range payloads {
+			payloadBytes, err := json.Marshal(payload)
+			if err != nil {
+				return err
+			}
+			if i == 0 {
+				payloadBytes = append([]byte("["), payloadBytes...)
+			} else {
+				payloadBytes = append([]byte(","), payloadBytes...)
+			}
+			if _, err = w.Write(payloadBytes); err != nil {
+				return err
+			}
+		}
+		closer := "]"
+		if len(payloads) == 0 {
+			closer = "[]"
+		}
+		if _, err := io.WriteString(w, closer); err != nil {
+			return err
+		}
+	}
+	if separator == "{" {
+		_, err := io.WriteString(w, "{}")
+		return err
+	}
+	_, err := io.WriteString(w, "}")
+	return err
+}
+
+func (encoder XMLEncoder) EncodeTo(w io.Writer, v interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	return xml.NewEncoder(w).Encode(xmlEncodable(v))
+}
+
+// ######
+// #     # ######  ####  #####   ####  #    #  ####  ######
+// #     # #      #      #    # #    # ##   # #      #
+// ######  #####   ####  #    # #    # # #  #  ####  #####
+// #   #   #           # #####  #    # #  # #      # #
+// #    #  #      #    # #      #    # #   ## #    # #
+// #     # ######  ####  #       ####  #    #  ####  ######
+//
+
+func (router *Router) streamThreshold() int {
+	if router == nil || router.StreamThreshold == 0 {
+		return DefaultStreamThreshold
+	}
+	return router.StreamThreshold
+}
+
+// writeStreamEncoded buffers the first StreamThreshold bytes.  If encoding fails before then, nothing has been
+// sent and a normal 500 goes out instead.  Past the threshold the status is committed, so a later failure can
+// only be logged and the body is left truncated.
+func writeStreamEncoded(ctx *Context, code int, encoder StreamEncoder, v interface{}) {
+	writer := &thresholdWriter{ctx: ctx, code: code, mediaType: encoder.MediaType(), threshold: ctx.router.streamThreshold()}
+	err := encoder.EncodeTo(writer, v)
+	if writer.writeErr != nil {
+		log.Println("3952513088 WRITE ERROR", writer.writeErr)
+		return
+	}
+	if err != nil {
+		if writer.streaming == false {
+			writeEncodingError(ctx, encoder, err)
+			return
+		}
+		log.Println("3589720732 encoding failed after", ctx.ContentLength, "bytes were streamed", ctx.Req.URL, err)
+		return
+	}
+	writer.finish()
+}
+
+// thresholdWriter holds output until it passes threshold (never, if threshold is negative), then streams.
+// Bytes that reach the client are counted in ctx.ContentLength.
+type thresholdWriter struct {
+	ctx       *Context
+	code      int
+	mediaType string
+	threshold int
+
+	buffer    bytes.Buffer
+	streaming bool
+	writeErr  error // first error from the ResponseWriter, ie the client went away
+}
+
+func (writer *thresholdWriter) Write(p []byte) (int, error) {
+	if writer.writeErr != nil {
+		return 0, writer.writeErr
+	}
+	if writer.streaming {
+		return writer.write(p)
+	}
+
+	writer.buffer.Write(p)
+	if writer.threshold >= 0 && writer.buffer.Len() > writer.threshold {
+		writer.writeHeader()
+		writer.streaming = true
+		if _, err := writer.write(writer.buffer.Bytes()); err != nil {
+			return 0, err
+		}
+		writer.buffer.Reset()
+	}
+	return len(p), nil
+}
+
+func (writer *thresholdWriter) write(p []byte) (int, error) {
+	n, err := writer.ctx.w.Write(p)
+	writer.ctx.ContentLength += n
+	if err != nil {
+		writer.writeErr = err
+	}
+	return n, err
+}
+
+func (writer *thresholdWriter) writeHeader() {
+	writer.ctx.SetHeader(httpHeaderContentType, writer.mediaType)
+	writer.ctx.StatusCode = writer.code
+	writer.ctx.w.WriteHeader(writer.code)
+}
+
+// finish writes anything still buffered
+func (writer *thresholdWriter) finish() {
+	if writer.streaming {
+		return
+	}
+	writer.writeHeader()
+	if _, err := writer.write(writer.buffer.Bytes()); err != nil {
+		log.Println("3952513088 WRITE ERROR", err)
+	}
+}
@@ -0,0 +1,101 @@
+package grunway
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/amattn/deeperror"
+)
+
+type badPayload struct {
+	Callback func()
+}
+
+func (payload badPayload) PayloadType() string {
+	return "broken" // sorts after "book"
+}
+
+func TestJSONEncodeToMatchesMarshal(t *testing.T) {
+	wrappers := []*PayloadWrapper{
+		NewPayloadWrapper(),
+		{ErrorInfo: ErrorInfo{ErrorNumber: 12, ErrorMessage: "<nope>"}, Alert: "maint"},
+		NewPayloadWrapper(BookPayload{1, "A & B", 3}, BookPayload{2, "<i>", 3}, AuthorPayload{3, "Bob"}),
+		{Payloads: PayloadsMap{"empty": []Payload{}, "nil": nil, "a\"b": []Payload{nil}}, Alert: "x"},
+	}
+	for _, pw := range wrappers {
+		expected, err := MarshallPayloadWrapper(pw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual := new(bytes.Buffer)
+		if err := (JSONEncoder{}).EncodeTo(actual, pw); err != nil {
+			t.Fatal(err)
+		}
+		if actual.String() != string(expected) {
+			t.Errorf("3589720790 streamed output differs\nexpected %s\n     got %s", expected, actual)
+		}
+	}
+}
+
+type shelfController struct {
+	payloads []Payload
+}
+
+func (controller *shelfController) GetHandlerV1All(ctx *Context) RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultPayloads(controller.payloads...)
+}
+
+func TestStreamedResponses(t *testing.T) {
+	shelf := new(shelfController)
+	router := NewRouter()
+	router.BasePath = "/api/"
+	router.PostProcessors = nil
+	router.StreamThreshold = 100
+	router.RegisterEntity("shelf", shelf)
+
+	get := func() (*httptest.ResponseRecorder, *Context) {
+		var captured *Context
+		router.PostProcessors = []PostProcessor{postProcessorFunc(func(ctx *Context) { captured = ctx })}
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://localhost/api/v1/shelf/all", nil)
+		router.ServeHTTP(w, req)
+		return w, captured
+	}
+
+	for i := 0; i < 50; i++ {
+		shelf.payloads = append(shelf.payloads, BookPayload{int64(i), "Volume", 1})
+	}
+	w, ctx := get()
+	expected, _ := MarshallPayloadWrapper(NewPayloadWrapper(shelf.payloads...))
+	if w.Code != http.StatusOK || w.Body.String() != string(expected) || ctx.ContentLength != len(expected) {
+		t.Error("3589720791 unexpected streamed response", w.Code, ctx.ContentLength, len(expected), w.Body.String())
+	}
+
+	// fails before the threshold, so the client gets a clean 500
+	shelf.payloads = []Payload{badPayload{}}
+	w, ctx = get()
+	if w.Code != http.StatusInternalServerError || ctx.StatusCode != http.StatusInternalServerError || strings.Contains(w.Body.String(), "3589720731") == false {
+		t.Error("3589720792 expected a 500", w.Code, w.Body.String())
+	}
+
+	// fails after the threshold: the 200 is already out, so the body is truncated
+	shelf.payloads = []Payload{}
+	for i := 0; i < 10; i++ {
+		shelf.payloads = append(shelf.payloads, BookPayload{int64(i), "Volume", 1})
+	}
+	shelf.payloads = append(shelf.payloads, badPayload{})
+	w, ctx = get()
+	if w.Code != http.StatusOK || ctx.ContentLength != w.Body.Len() || strings.HasSuffix(w.Body.String(), "}") {
+		t.Error("3589720793 expected a truncated 200", w.Code, ctx.ContentLength, w.Body.String())
+	}
+}
+
+type postProcessorFunc func(ctx *Context)
+
+func (fn postProcessorFunc) Process(ctx *Context) (bool, *deeperror.DeepError) {
+	fn(ctx)
+	return false, nil
+}
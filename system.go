@@ -0,0 +1,265 @@
+package grunway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultSystemEntityName   = "system"
+	DefaultReadinessTimeout   = 5 * time.Second
+	NotReadyErrorNumber       = 5030000001
+	notReadyErrorMessage      = "503 Service Unavailable: Not Ready"
+	readinessCheckPanicPrefix = "panic: "
+)
+
+// ReadinessCheckFunc returns nil if the dependency (db, cache, upstream service, etc.) is usable.
+// ctx is cancelled when the check's timeout expires.
+type ReadinessCheckFunc func(ctx context.Context) error
+
+type SystemRoutesOptions struct {
+	EntityName string // default DefaultSystemEntityName, ie /<BasePath>/v1/system/...
+
+	// reported by the build route
+	AppName    string
+	AppVersion string
+	AppBuild   string
+
+	// default timeout for readiness checks registered with a timeout of 0.  default DefaultReadinessTimeout
+	CheckTimeout time.Duration
+
+	// if true, requests to the system routes are not written by CommonLogger.
+	// load balancers and orchestrators tend to poll these every few seconds.
+	ExcludeFromAccessLog bool
+}
+
+// SystemController serves the opt-in system routes.  Use Router.EnableSystemRoutes to get one.
+//
+//	GET /<BasePath>/v1/system/health  liveness, always 200 if the process is serving
+//	GET /<BasePath>/v1/system/ready   readiness, 200 if every registered check passes, 503 otherwise
+//	GET /<BasePath>/v1/system/build   grunway version, go version and app version
+type SystemController struct {
+	Options SystemRoutesOptions
+
+	started time.Time
+
+	mu     sync.RWMutex
+	checks []readinessCheck
+}
+
+type readinessCheck struct {
+	name    string
+	timeout time.Duration
+	check   ReadinessCheckFunc
+}
+
+type HealthPayload struct {
+	Status        string
+	UptimeSeconds int64
+}
+
+func (payload HealthPayload) PayloadType() string {
+	return "health"
+}
+
+type ReadinessCheckResult struct {
+	Name       string
+	Ok         bool
+	Error      string `json:",omitempty"`
+	DurationMs int64
+}
+
+type ReadinessPayload struct {
+	Ready  bool
+	Checks []ReadinessCheckResult
+}
+
+func (payload ReadinessPayload) PayloadType() string {
+	return "readiness"
+}
+
+type BuildInfoPayload struct {
+	GrunwayVersion     string
+	GrunwayBuildNumber int64
+	GoVersion          string
+	GoOS               string
+	GoArch             string
+	AppName            string `json:",omitempty"`
+	AppVersion         string `json:",omitempty"`
+	AppBuild           string `json:",omitempty"`
+}
+
+func (payload BuildInfoPayload) PayloadType() string {
+	return "buildinfo"
+}
+
+//  #####
+// #     #  ####  #    # ###### #  ####
+// #       #    # ##   # #      # #    #
+// #       #    # # #  # #####  # #
+// #       #    # #  # # #      # #  ###
+// #     # #    # #   ## #      # #    #
+//  #####   ####  #    # #      #  ####
+//
+
+// EnableSystemRoutes registers the health, readiness and build info routes.
+// Add readiness checks to the returned controller.
+func (router *Router) EnableSystemRoutes(options SystemRoutesOptions) *SystemController {
+	if options.EntityName == "" {
+		options.EntityName = DefaultSystemEntityName
+	}
+	if options.CheckTimeout <= 0 {
+		options.CheckTimeout = DefaultReadinessTimeout
+	}
+
+	controller := new(SystemController)
+	controller.Options = options
+	controller.started = time.Now()
+	router.RegisterEntity(options.EntityName, controller)
+	return controller
+}
+
+// AddReadinessCheck registers a check run on every readiness request.
+// Checks run concurrently.  timeout 0 means Options.CheckTimeout.
+func (controller *SystemController) AddReadinessCheck(name string, timeout time.Duration, check ReadinessCheckFunc) {
+	if timeout <= 0 {
+		timeout = controller.Options.CheckTimeout
+	}
+	controller.mu.Lock()
+	defer controller.mu.Unlock()
+	controller.checks = append(controller.checks, readinessCheck{name, timeout, check})
+}
+
+// CheckReadiness runs all registered checks and returns the aggregate.  Results are sorted by name.
+func (controller *SystemController) CheckReadiness(ctx context.Context) ReadinessPayload {
+	controller.mu.RLock()
+	checks := make([]readinessCheck, len(controller.checks))
+	copy(checks, controller.checks)
+	controller.mu.RUnlock()
+
+	results := make([]ReadinessCheckResult, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check readinessCheck) {
+			defer wg.Done()
+			results[i] = runReadinessCheck(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+
+	payload := ReadinessPayload{Ready: true, Checks: results}
+	for _, result := range results {
+		if result.Ok == false {
+			payload.Ready = false
+		}
+	}
+	return payload
+}
+
+// returns once the check finishes or its timeout expires, whichever is first.
+// checks that ignore ctx are left running in the background.
+func runReadinessCheck(parent context.Context, check readinessCheck) ReadinessCheckResult {
+	ctx, cancel := context.WithTimeout(parent, check.timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("%s%v", readinessCheckPanicPrefix, r)
+			}
+		}()
+		done <- check.check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("timed out after %v", check.timeout)
+	}
+
+	result := ReadinessCheckResult{
+		Name:       check.name,
+		Ok:         err == nil,
+		DurationMs: int64(time.Since(start) / time.Millisecond),
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// #     #
+// #     #   ##   #    # #####  #      ###### #####   ####
+// #     #  #  #  ##   # #    # #      #      #    # #
+// ####### #    # # #  # #    # #      #####  #    #  ####
+// #     # ###### #  # # #    # #      #      #####       #
+// #     # #    # #   ## #    # #      #      #   #  #    #
+// #     # #    # #    # #####  ###### ###### #    #  ####
+//
+
+func (controller *SystemController) RouteDocs() map[string]HandlerDoc {
+	return map[string]HandlerDoc{
+		"GetHandlerV1Health": {Summary: "Liveness", Responses: []Payload{HealthPayload{}}},
+		"GetHandlerV1Ready": {
+			Summary:   "Readiness, aggregates the registered checks",
+			Responses: []Payload{ReadinessPayload{}},
+			Errors:    []HandlerErrorDoc{{StatusCode: http.StatusServiceUnavailable, ErrorNumber: NotReadyErrorNumber, Description: "a check failed or timed out"}},
+		},
+		"GetHandlerV1Build": {Summary: "Build info", Responses: []Payload{BuildInfoPayload{}}},
+	}
+}
+
+func (controller *SystemController) GetHandlerV1Health(ctx *Context) RouteHandlerResult {
+	controller.maybeExcludeFromAccessLog(ctx)
+	return ctx.MakeRouteHandlerResultPayloads(HealthPayload{
+		Status:        "ok",
+		UptimeSeconds: int64(time.Since(controller.started) / time.Second),
+	})
+}
+
+func (controller *SystemController) GetHandlerV1Ready(ctx *Context) RouteHandlerResult {
+	controller.maybeExcludeFromAccessLog(ctx)
+	payload := controller.CheckReadiness(ctx.Req.Context())
+	if payload.Ready {
+		return ctx.MakeRouteHandlerResultPayloads(payload)
+	}
+
+	// still send the check results, so whoever is looking can see what failed
+	return ctx.MakeRouteHandlerResultCustom(func(innerCtx *Context) {
+		payloadWrapper := NewPayloadWrapper(payload)
+		payloadWrapper.ErrorNumber = NotReadyErrorNumber
+		payloadWrapper.ErrorMessage = notReadyErrorMessage
+		writePayloadWrapper(innerCtx, http.StatusServiceUnavailable, payloadWrapper)
+	})
+}
+
+func (controller *SystemController) GetHandlerV1Build(ctx *Context) RouteHandlerResult {
+	controller.maybeExcludeFromAccessLog(ctx)
+	return ctx.MakeRouteHandlerResultPayloads(BuildInfoPayload{
+		GrunwayVersion:     Version(),
+		GrunwayBuildNumber: BuildNumber(),
+		GoVersion:          runtime.Version(),
+		GoOS:               runtime.GOOS,
+		GoArch:             runtime.GOARCH,
+		AppName:            controller.Options.AppName,
+		AppVersion:         controller.Options.AppVersion,
+		AppBuild:           controller.Options.AppBuild,
+	})
+}
+
+func (controller *SystemController) maybeExcludeFromAccessLog(ctx *Context) {
+	if controller.Options.ExcludeFromAccessLog {
+		ctx.ExcludeFromAccessLog()
+	}
+}
@@ -0,0 +1,95 @@
+package grunway
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/amattn/deeperror"
+)
+
+func TestSystemRoutes(t *testing.T) {
+	router := NewRouter()
+	router.BasePath = "/api/"
+	router.PostProcessors = nil
+	system := router.EnableSystemRoutes(SystemRoutesOptions{AppVersion: "1.2.3", ExcludeFromAccessLog: true})
+
+	get := func(path string) (int, *PayloadWrapper) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://localhost/api/v1/system/"+path, nil)
+		router.ServeHTTP(w, req)
+		body, _ := ioutil.ReadAll(w.Body)
+		pw, err := UnmarshalPayloadWrapper(body, HealthPayload{}, ReadinessPayload{}, BuildInfoPayload{})
+		if err != nil {
+			t.Fatal("2870145501", path, err, string(body))
+		}
+		return w.Code, pw
+	}
+
+	if code, pw := get("health"); code != http.StatusOK || len(pw.Payloads["health"]) != 1 {
+		t.Error("2870145502 unexpected health response", code, pw)
+	}
+
+	code, pw := get("build")
+	if code != http.StatusOK || len(pw.Payloads["buildinfo"]) != 1 {
+		t.Fatal("2870145503 unexpected build response", code, pw)
+	}
+	buildInfo := pw.Payloads["buildinfo"][0].(*BuildInfoPayload)
+	if buildInfo.GrunwayVersion != Version() || buildInfo.AppVersion != "1.2.3" {
+		t.Error("2870145504 unexpected build info", buildInfo)
+	}
+
+	// no checks means ready
+	if code, _ := get("ready"); code != http.StatusOK {
+		t.Error("2870145505 expected 200 with no checks, got", code)
+	}
+
+	system.AddReadinessCheck("db", 0, func(ctx context.Context) error { return nil })
+	system.AddReadinessCheck("cache", 0, func(ctx context.Context) error { return errors.New("connection refused") })
+	system.AddReadinessCheck("slow", 20*time.Millisecond, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	code, pw = get("ready")
+	if code != http.StatusServiceUnavailable || pw.ErrorNumber != NotReadyErrorNumber {
+		t.Fatal("2870145506 expected 503, got", code, pw.ErrorNumber)
+	}
+	readiness := pw.Payloads["readiness"][0].(*ReadinessPayload)
+	if readiness.Ready || len(readiness.Checks) != 3 {
+		t.Fatal("2870145507 unexpected readiness", readiness)
+	}
+	expectedOk := map[string]bool{"cache": false, "db": true, "slow": false}
+	for _, result := range readiness.Checks {
+		if result.Ok != expectedOk[result.Name] {
+			t.Error("2870145508 unexpected result for", result.Name, result)
+		}
+	}
+}
+
+func TestSystemRoutesExcludedFromAccessLog(t *testing.T) {
+	router := NewRouter()
+	router.BasePath = "/api/"
+	recorder := new(recordingPostProcessor)
+	router.PostProcessors = []PostProcessor{recorder}
+	router.EnableSystemRoutes(SystemRoutesOptions{ExcludeFromAccessLog: true})
+
+	req, _ := http.NewRequest("GET", "http://localhost/api/v1/system/health", nil)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	if len(recorder.excluded) != 1 || recorder.excluded[0] == false {
+		t.Error("2870145509 expected the request to be excluded from the access log", recorder.excluded)
+	}
+}
+
+type recordingPostProcessor struct {
+	excluded []bool
+}
+
+func (recorder *recordingPostProcessor) Process(ctx *Context) (bool, *deeperror.DeepError) {
+	recorder.excluded = append(recorder.excluded, ctx.excludedFromAccessLog)
+	return false, nil
+}
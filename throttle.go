@@ -0,0 +1,463 @@
+package grunway
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/amattn/deeperror"
+)
+
+const (
+	LoginThrottledAccountLockedErrorNumber  = 4290000001
+	LoginThrottledAccountBackoffErrorNumber = 4290000002
+	LoginThrottledIPLockedErrorNumber       = 4290000003
+	LoginThrottledIPBackoffErrorNumber      = 4290000004
+
+	LoginFailedErrorNumber = 4010000001
+
+	httpHeaderRetryAfter = "Retry-After"
+)
+
+// LoginAttemptCounter stores failed login counts.
+// Implementations must be safe for concurrent use.  Back it with a shared store (memcache, redis, sql, etc.)
+// to throttle across multiple processes.
+type LoginAttemptCounter interface {
+	// returns the number of failures and the time of the most recent failure.  unknown keys return 0, zero time
+	Failures(key string) (count int, lastFailure time.Time, err error)
+	// atomically increments the failure count, sets the most recent failure time and returns the new count
+	IncrementFailures(key string, now time.Time) (count int, err error)
+	// atomically undoes one IncrementFailures, for an attempt that turned out not to be a failure.
+	// the most recent failure time may be left as is.
+	DecrementFailures(key string) error
+	ResetFailures(key string) error
+}
+
+type LoginThrottleConfig struct {
+	// Failures older than this are forgotten.
+	FailureWindow time.Duration
+
+	// After this many failures, each further attempt must wait BaseDelay * 2^(failures-BackoffThreshold), capped at MaxDelay
+	BackoffThreshold int
+	BaseDelay        time.Duration
+	MaxDelay         time.Duration
+
+	// After this many failures, the key is locked out for LockoutDuration (measured from the last failure)
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+}
+
+type LoginThrottle struct {
+	Store   AccountStore
+	Counter LoginAttemptCounter
+
+	AccountConfig LoginThrottleConfig // keyed by normalized email
+	IPConfig      LoginThrottleConfig // keyed by client ip.  usually more permissive, since many users can share an ip.
+
+	now func() time.Time // swappable for testing
+}
+
+// LoginThrottledError is returned by LoginThrottle.Login when an attempt is rejected without checking credentials.
+type LoginThrottledError struct {
+	ErrorNumber int64
+	Message     string
+	RetryAfter  time.Duration
+}
+
+func (lte *LoginThrottledError) Error() string {
+	return fmt.Sprintf("%d %s (retry after %v)", lte.ErrorNumber, lte.Message, lte.RetryAfter)
+}
+
+func DefaultLoginThrottleAccountConfig() LoginThrottleConfig {
+	return LoginThrottleConfig{
+		FailureWindow:    time.Hour,
+		BackoffThreshold: 3,
+		BaseDelay:        time.Second,
+		MaxDelay:         time.Minute,
+		LockoutThreshold: 10,
+		LockoutDuration:  15 * time.Minute,
+	}
+}
+
+func DefaultLoginThrottleIPConfig() LoginThrottleConfig {
+	return LoginThrottleConfig{
+		FailureWindow:    time.Hour,
+		BackoffThreshold: 20,
+		BaseDelay:        time.Second,
+		MaxDelay:         time.Minute,
+		LockoutThreshold: 100,
+		LockoutDuration:  time.Hour,
+	}
+}
+
+func NewLoginThrottle(store AccountStore, counter LoginAttemptCounter) *LoginThrottle {
+	lt := new(LoginThrottle)
+	lt.Store = store
+	lt.Counter = counter
+	lt.AccountConfig = DefaultLoginThrottleAccountConfig()
+	lt.IPConfig = DefaultLoginThrottleIPConfig()
+	lt.now = time.Now
+	return lt
+}
+
+// #
+// #        ####   ####  # #    #
+// #       #    # #    # # ##   #
+// #       #    # #      # # #  #
+// #       #    # #  ### # #  # #
+// #       #    # #    # # #   ##
+// #######  ####   ####  # #    #
+//
+
+// Login checks both the account and client ip counters before calling through to Store.Login.
+// Returns *LoginThrottledError if the attempt was rejected, otherwise whatever Store.Login returns.
+//
+// Every attempt is counted as a failure against both keys before Store.Login is called, so concurrent attempts
+// can't all get in under the threshold.  Success resets the account key and takes the attempt back off the ip key.
+// Only bad credentials count: a nil account, or a DeepError w/ a 401 or 403 status.  Any other error from
+// Store.Login (eg the database is down) is returned as is, and the attempt is taken back off both keys.
+func (lt *LoginThrottle) Login(clientIP, submittedEmail, submittedPassword string) (*Account, error) {
+	now := lt.now()
+	accountKey := "acct:" + normalizedEmail(submittedEmail)
+	ipKey := "ip:" + clientIP
+
+	accountCount, throttledErr, err := lt.check(accountKey, lt.AccountConfig, now, LoginThrottledAccountLockedErrorNumber, LoginThrottledAccountBackoffErrorNumber)
+	if throttledErr != nil || err != nil {
+		return nil, firstError(throttledErr, err)
+	}
+	var ipCount int
+	if clientIP != "" {
+		ipCount, throttledErr, err = lt.check(ipKey, lt.IPConfig, now, LoginThrottledIPLockedErrorNumber, LoginThrottledIPBackoffErrorNumber)
+		if throttledErr != nil || err != nil {
+			return nil, firstError(throttledErr, err)
+		}
+	}
+
+	throttledErr, err = lt.reserve(accountKey, accountCount, lt.AccountConfig, now, LoginThrottledAccountLockedErrorNumber, LoginThrottledAccountBackoffErrorNumber)
+	if throttledErr != nil || err != nil {
+		return nil, firstError(throttledErr, err)
+	}
+	if clientIP != "" {
+		throttledErr, err = lt.reserve(ipKey, ipCount, lt.IPConfig, now, LoginThrottledIPLockedErrorNumber, LoginThrottledIPBackoffErrorNumber)
+		if throttledErr != nil || err != nil {
+			lt.release(accountKey)
+			return nil, firstError(throttledErr, err)
+		}
+	}
+
+	acct, loginErr := lt.Store.Login(submittedEmail, submittedPassword)
+	if loginErr == nil && acct != nil {
+		err = lt.Counter.ResetFailures(accountKey)
+		if err == nil && clientIP != "" {
+			err = lt.Counter.DecrementFailures(ipKey)
+		}
+		if err != nil {
+			return nil, deeperror.New(2748193602, "Login throttle counter failure", err)
+		}
+		return acct, nil
+	}
+
+	if isBadCredentialsError(loginErr) == false {
+		// the store failed, not the user
+		lt.release(accountKey)
+		if clientIP != "" {
+			lt.release(ipKey)
+		}
+		return nil, loginErr
+	}
+	if loginErr == nil {
+		loginErr = deeperror.NewHTTPError(LoginFailedErrorNumber, "Invalid email or password", nil, http.StatusUnauthorized)
+	}
+	return nil, loginErr
+}
+
+// Convenience wrapper around Login that uses the request's remote address as the client ip.
+func (lt *LoginThrottle) LoginWithContext(ctx *Context, submittedEmail, submittedPassword string) (*Account, error) {
+	return lt.Login(ClientIP(ctx.Req), submittedEmail, submittedPassword)
+}
+
+// RetryAfter reports how long the given email/ip pair must wait before the next attempt will be considered.
+// zero means an attempt may be made now.
+func (lt *LoginThrottle) RetryAfter(clientIP, email string) (time.Duration, error) {
+	now := lt.now()
+	accountWait, err := lt.wait("acct:"+normalizedEmail(email), lt.AccountConfig, now)
+	if err != nil {
+		return 0, err
+	}
+	if clientIP == "" {
+		return accountWait, nil
+	}
+	ipWait, err := lt.wait("ip:"+clientIP, lt.IPConfig, now)
+	if err != nil {
+		return 0, err
+	}
+	if ipWait > accountWait {
+		return ipWait, nil
+	}
+	return accountWait, nil
+}
+
+// returns the failure count the decision was based on, see reserve
+func (lt *LoginThrottle) check(key string, config LoginThrottleConfig, now time.Time, lockedErrNum, backoffErrNum int64) (int, *LoginThrottledError, error) {
+	count, lastFailure, err := lt.Counter.Failures(key)
+	if err != nil {
+		return 0, nil, deeperror.New(2748193603, "Login throttle counter failure", err)
+	}
+	if count > 0 && config.FailureWindow > 0 && now.Sub(lastFailure) > config.FailureWindow {
+		// stale failures shouldn't count towards the next lockout
+		err = lt.Counter.ResetFailures(key)
+		if err != nil {
+			return 0, nil, deeperror.New(2748193605, "Login throttle counter failure", err)
+		}
+		return 0, nil, nil
+	}
+	wait, locked := config.delay(count, lastFailure, now)
+	return count, makeLoginThrottledError(wait, locked, lockedErrNum, backoffErrNum), nil
+}
+
+// reserve counts the attempt as a failure before it is made.  If other attempts got in since check saw checkedCount,
+// their failures are from just now, and may be enough to throttle this one.  A throttled attempt isn't counted.
+func (lt *LoginThrottle) reserve(key string, checkedCount int, config LoginThrottleConfig, now time.Time, lockedErrNum, backoffErrNum int64) (*LoginThrottledError, error) {
+	count, err := lt.Counter.IncrementFailures(key, now)
+	if err != nil {
+		return nil, deeperror.New(2748193601, "Login throttle counter failure", err)
+	}
+	previousCount := count - 1
+	if previousCount <= checkedCount {
+		return nil, nil
+	}
+	wait, locked := config.delay(previousCount, now, now)
+	throttledErr := makeLoginThrottledError(wait, locked, lockedErrNum, backoffErrNum)
+	if throttledErr != nil {
+		lt.release(key)
+	}
+	return throttledErr, nil
+}
+
+// takes back a reservation.  a counter that can't do that leaves an extra failure behind, which only errs on the safe side.
+func (lt *LoginThrottle) release(key string) {
+	err := lt.Counter.DecrementFailures(key)
+	if err != nil {
+		log.Println("2748193606 Login throttle counter failure", key, err)
+	}
+}
+
+func makeLoginThrottledError(wait time.Duration, locked bool, lockedErrNum, backoffErrNum int64) *LoginThrottledError {
+	if wait <= 0 {
+		return nil
+	}
+	if locked {
+		return &LoginThrottledError{lockedErrNum, "Too many failed login attempts, temporarily locked", wait}
+	}
+	return &LoginThrottledError{backoffErrNum, "Too many failed login attempts, slow down", wait}
+}
+
+// bad credentials count towards throttling, anything else is the store's problem
+func isBadCredentialsError(err error) bool {
+	if err == nil {
+		return true
+	}
+	derr, isDeepError := err.(*deeperror.DeepError)
+	if isDeepError == false || derr.StatusCodeIsDefaultValue() {
+		return false
+	}
+	return derr.StatusCode == http.StatusUnauthorized || derr.StatusCode == http.StatusForbidden
+}
+
+func (lt *LoginThrottle) wait(key string, config LoginThrottleConfig, now time.Time) (time.Duration, error) {
+	count, lastFailure, err := lt.Counter.Failures(key)
+	if err != nil {
+		return 0, deeperror.New(2748193604, "Login throttle counter failure", err)
+	}
+	wait, _ := config.delay(count, lastFailure, now)
+	return wait, nil
+}
+
+// returns how much longer the caller must wait, and whether that wait is a lockout (as opposed to a backoff)
+func (config LoginThrottleConfig) delay(count int, lastFailure time.Time, now time.Time) (time.Duration, bool) {
+	if count == 0 {
+		return 0, false
+	}
+	if config.FailureWindow > 0 && now.Sub(lastFailure) > config.FailureWindow {
+		return 0, false
+	}
+
+	if config.LockoutThreshold > 0 && count >= config.LockoutThreshold {
+		remaining := lastFailure.Add(config.LockoutDuration).Sub(now)
+		if remaining > 0 {
+			return remaining, true
+		}
+		return 0, false
+	}
+
+	if config.BackoffThreshold > 0 && count >= config.BackoffThreshold {
+		backoff := config.MaxDelay
+		exponent := float64(count - config.BackoffThreshold)
+		if exponent < 62 {
+			scaled := float64(config.BaseDelay) * math.Pow(2, exponent)
+			if scaled < float64(config.MaxDelay) || config.MaxDelay <= 0 {
+				backoff = time.Duration(scaled)
+			}
+		}
+		remaining := lastFailure.Add(backoff).Sub(now)
+		if remaining > 0 {
+			return remaining, false
+		}
+	}
+	return 0, false
+}
+
+// #     #
+// #     # ##### # #      # ##### # ######  ####
+// #     #   #   # #      #   #   # #      #
+// #     #   #   # #      #   #   # #####   ####
+// #     #   #   # #      #   #   # #           #
+// #     #   #   # #      #   #   # #      #    #
+//  #####    #   # ###### #   #   # ######  ####
+//
+
+// MakeRouteHandlerResultLoginFailure turns an error from LoginThrottle.Login (or AccountStore.Login)
+// into the appropriate response: 429 w/ a Retry-After header when throttled, the DeepError's status otherwise.
+func (ctx *Context) MakeRouteHandlerResultLoginFailure(err error) RouteHandlerResult {
+	switch typedErr := err.(type) {
+	case *LoginThrottledError:
+		return ctx.MakeRouteHandlerResultRetryAfter(http.StatusTooManyRequests, typedErr.ErrorNumber, typedErr.Message, typedErr.RetryAfter)
+	case *deeperror.DeepError:
+		if typedErr.StatusCodeIsDefaultValue() == false {
+			return ctx.MakeRouteHandlerResultError(typedErr.StatusCode, typedErr.Num, typedErr.EndUserMsg)
+		}
+	}
+	return ctx.MakeRouteHandlerResultError(http.StatusUnauthorized, LoginFailedErrorNumber, "Invalid email or password")
+}
+
+// Retry-After is sent in whole seconds, rounded up.
+func (ctx *Context) MakeRouteHandlerResultRetryAfter(code int, errNo int64, errMsg string, retryAfter time.Duration) RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultCustom(func(innerCtx *Context) {
+		seconds := int64(math.Ceil(retryAfter.Seconds()))
+		if seconds < 1 {
+			seconds = 1
+		}
+		innerCtx.SetHeader(httpHeaderRetryAfter, strconv.FormatInt(seconds, 10))
+		innerCtx.SendSimpleErrorPayload(code, errNo, errMsg)
+	})
+}
+
+// ClientIP returns the host portion of req.RemoteAddr.
+// X-Forwarded-For is intentionally ignored, since it is trivially spoofed unless you control the proxy.
+func ClientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+func firstError(throttledErr *LoginThrottledError, err error) error {
+	if throttledErr != nil {
+		return throttledErr
+	}
+	return err
+}
+
+// #     #
+// ##   ## ###### #    #  ####  #####  #   #
+// # # # # #      ##  ## #    # #    #  # #
+// #  #  # #####  # ## # #    # #    #   #
+// #     # #      #    # #    # #####    #
+// #     # #      #    # #    # #   #    #
+// #     # ###### #    #  ####  #    #   #
+//
+
+// MemoryLoginAttemptCounter is an in-process LoginAttemptCounter.
+// Entries older than MaxAge are pruned periodically.
+type MemoryLoginAttemptCounter struct {
+	MaxAge time.Duration
+
+	mu         sync.Mutex
+	records    map[string]*memoryLoginAttemptRecord
+	operations int
+}
+
+type memoryLoginAttemptRecord struct {
+	count       int
+	lastFailure time.Time
+}
+
+const memoryLoginAttemptPruneInterval = 1024
+
+func NewMemoryLoginAttemptCounter(maxAge time.Duration) *MemoryLoginAttemptCounter {
+	counter := new(MemoryLoginAttemptCounter)
+	counter.MaxAge = maxAge
+	counter.records = make(map[string]*memoryLoginAttemptRecord)
+	return counter
+}
+
+func (counter *MemoryLoginAttemptCounter) Failures(key string) (int, time.Time, error) {
+	counter.mu.Lock()
+	defer counter.mu.Unlock()
+
+	record, exists := counter.records[key]
+	if exists == false {
+		return 0, time.Time{}, nil
+	}
+	return record.count, record.lastFailure, nil
+}
+
+func (counter *MemoryLoginAttemptCounter) IncrementFailures(key string, now time.Time) (int, error) {
+	counter.mu.Lock()
+	defer counter.mu.Unlock()
+
+	counter.operations++
+	if counter.operations%memoryLoginAttemptPruneInterval == 0 {
+		counter.prune(now)
+	}
+
+	record, exists := counter.records[key]
+	if exists == false || (counter.MaxAge > 0 && now.Sub(record.lastFailure) > counter.MaxAge) {
+		record = new(memoryLoginAttemptRecord)
+		counter.records[key] = record
+	}
+	record.count++
+	record.lastFailure = now
+	return record.count, nil
+}
+
+func (counter *MemoryLoginAttemptCounter) DecrementFailures(key string) error {
+	counter.mu.Lock()
+	defer counter.mu.Unlock()
+
+	record, exists := counter.records[key]
+	if exists == false {
+		return nil
+	}
+	record.count--
+	if record.count <= 0 {
+		delete(counter.records, key)
+	}
+	return nil
+}
+
+func (counter *MemoryLoginAttemptCounter) ResetFailures(key string) error {
+	counter.mu.Lock()
+	defer counter.mu.Unlock()
+
+	delete(counter.records, key)
+	return nil
+}
+
+// must hold lock
+func (counter *MemoryLoginAttemptCounter) prune(now time.Time) {
+	if counter.MaxAge <= 0 {
+		return
+	}
+	for key, record := range counter.records {
+		if now.Sub(record.lastFailure) > counter.MaxAge {
+			delete(counter.records, key)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package grunway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/amattn/deeperror"
+)
+
+// only Login is implemented, everything else panics
+type loginOnlyAccountStore struct {
+	AccountStore
+	email, password string
+}
+
+func (store *loginOnlyAccountStore) Login(submittedEmail, submittedPassword string) (*Account, error) {
+	if normalizedEmail(submittedEmail) == store.email && submittedPassword == store.password {
+		return &Account{PKey: 1, Email: store.email}, nil
+	}
+	return nil, deeperror.NewHTTPError(1, "Invalid email or password", nil, http.StatusUnauthorized)
+}
+
+func makeTestLoginThrottle(now *time.Time) *LoginThrottle {
+	lt := NewLoginThrottle(&loginOnlyAccountStore{email: "alice@example.com", password: "password1"}, NewMemoryLoginAttemptCounter(time.Hour))
+	lt.AccountConfig = LoginThrottleConfig{
+		FailureWindow:    time.Hour,
+		BackoffThreshold: 2,
+		BaseDelay:        time.Second,
+		MaxDelay:         10 * time.Second,
+		LockoutThreshold: 5,
+		LockoutDuration:  time.Minute,
+	}
+	lt.now = func() time.Time { return *now }
+	return lt
+}
+
+func expectThrottled(t *testing.T, err error, errNum int64, retryAfter time.Duration) {
+	throttledErr, ok := err.(*LoginThrottledError)
+	if ok == false {
+		t.Fatalf("1882734651 expected *LoginThrottledError, got %T %v", err, err)
+	}
+	if throttledErr.ErrorNumber != errNum || throttledErr.RetryAfter != retryAfter {
+		t.Fatalf("1882734652 expected %d/%v, got %d/%v", errNum, retryAfter, throttledErr.ErrorNumber, throttledErr.RetryAfter)
+	}
+}
+
+func TestLoginThrottleBackoffAndLockout(t *testing.T) {
+	now := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	lt := makeTestLoginThrottle(&now)
+
+	// two free failures
+	for i := 0; i < 2; i++ {
+		_, err := lt.Login("10.0.0.1", "alice@example.com", "wrong")
+		if _, ok := err.(*LoginThrottledError); ok || err == nil {
+			t.Fatalf("1882734653 attempt %d expected plain login failure, got %v", i, err)
+		}
+	}
+
+	// then exponential backoff: 1s, 2s, 4s
+	_, err := lt.Login("10.0.0.1", "alice@example.com", "password1")
+	expectThrottled(t, err, LoginThrottledAccountBackoffErrorNumber, time.Second)
+
+	for _, delay := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		now = now.Add(delay)
+		_, err = lt.Login("10.0.0.1", "ALICE@example.com", "wrong")
+		if _, ok := err.(*LoginThrottledError); ok || err == nil {
+			t.Fatalf("1882734654 expected plain login failure after waiting %v, got %v", delay, err)
+		}
+	}
+
+	// 5 failures: locked out, even with the right password
+	_, err = lt.Login("10.0.0.2", "alice@example.com", "password1")
+	expectThrottled(t, err, LoginThrottledAccountLockedErrorNumber, time.Minute)
+
+	now = now.Add(time.Minute)
+	acct, err := lt.Login("10.0.0.2", "alice@example.com", "password1")
+	if err != nil || acct == nil {
+		t.Fatal("1882734655 expected login to succeed after lockout expires", err)
+	}
+
+	// success resets the account counter
+	count, _, _ := lt.Counter.Failures("acct:alice@example.com")
+	if count != 0 {
+		t.Errorf("1882734656 expected account failures reset, got %d", count)
+	}
+}
+
+func TestLoginThrottleByIP(t *testing.T) {
+	now := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	lt := makeTestLoginThrottle(&now)
+	lt.IPConfig = LoginThrottleConfig{FailureWindow: time.Hour, LockoutThreshold: 3, LockoutDuration: time.Hour}
+
+	// spraying different accounts from one ip
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		lt.Login("10.0.0.1", email, "wrong")
+	}
+
+	_, err := lt.Login("10.0.0.1", "alice@example.com", "password1")
+	expectThrottled(t, err, LoginThrottledIPLockedErrorNumber, time.Hour)
+
+	acct, err := lt.Login("10.0.0.2", "alice@example.com", "password1")
+	if err != nil || acct == nil {
+		t.Fatal("1882734657 other ips should be unaffected", err)
+	}
+}
+
+func TestLoginThrottleFailureWindow(t *testing.T) {
+	now := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	lt := makeTestLoginThrottle(&now)
+
+	for i := 0; i < 4; i++ {
+		lt.Login("", "alice@example.com", "wrong")
+		now = now.Add(10 * time.Second)
+	}
+	now = now.Add(2 * time.Hour)
+
+	// stale failures are forgotten, so one more failure doesn't trigger a lockout
+	lt.Login("", "alice@example.com", "wrong")
+	wait, _ := lt.RetryAfter("", "alice@example.com")
+	if wait != 0 {
+		t.Errorf("1882734658 expected no wait after window expiry, got %v", wait)
+	}
+}
+
+// every attempt fails, slowly enough for concurrent attempts to overlap
+type slowLoginAccountStore struct {
+	AccountStore
+	calls int32
+	err   error
+}
+
+func (store *slowLoginAccountStore) Login(submittedEmail, submittedPassword string) (*Account, error) {
+	atomic.AddInt32(&store.calls, 1)
+	time.Sleep(20 * time.Millisecond)
+	return nil, store.err
+}
+
+func TestLoginThrottleConcurrentAttempts(t *testing.T) {
+	now := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	lt := makeTestLoginThrottle(&now)
+	store := &slowLoginAccountStore{err: deeperror.NewHTTPError(1, "Invalid email or password", nil, http.StatusUnauthorized)}
+	lt.Store = store
+
+	// all 20 pass check before any failure is recorded, only the first 2 (BackoffThreshold) may reach the store
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lt.Login("10.0.0.1", "alice@example.com", "guess")
+		}()
+	}
+	wg.Wait()
+	if calls := atomic.LoadInt32(&store.calls); calls != 2 {
+		t.Error("1882734662 expected concurrent attempts to be throttled, store saw", calls)
+	}
+	if count, _, _ := lt.Counter.Failures("acct:alice@example.com"); count != 2 {
+		t.Error("1882734663 throttled attempts shouldn't count as failures, got", count)
+	}
+}
+
+func TestLoginThrottleIgnoresStoreErrors(t *testing.T) {
+	now := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	lt := makeTestLoginThrottle(&now)
+	storeErr := deeperror.NewHTTPError(2, "database unavailable", nil, http.StatusServiceUnavailable)
+	lt.Store = &slowLoginAccountStore{err: storeErr}
+
+	for i := 0; i < 10; i++ {
+		if _, err := lt.Login("10.0.0.1", "alice@example.com", "password1"); err != storeErr {
+			t.Fatal("1882734664 expected the store's error, got", err)
+		}
+	}
+	accountCount, _, _ := lt.Counter.Failures("acct:alice@example.com")
+	ipCount, _, _ := lt.Counter.Failures("ip:10.0.0.1")
+	if accountCount != 0 || ipCount != 0 {
+		t.Error("1882734665 store errors shouldn't count as failures", accountCount, ipCount)
+	}
+}
+
+func TestRetryAfterHeader(t *testing.T) {
+	w := httptest.NewRecorder()
+	ctx := &Context{w: w, Req: httptest.NewRequest("POST", "/api/v1/session/", nil), router: NewRouter()}
+	ctx.router.PostProcessors = nil
+
+	rhr := ctx.MakeRouteHandlerResultLoginFailure(&LoginThrottledError{LoginThrottledAccountLockedErrorNumber, "locked", 1500 * time.Millisecond})
+	rhr.crr(ctx)
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("1882734659 expected 429, got %d", w.Code)
+	}
+	if retryAfter := w.Header().Get(httpHeaderRetryAfter); retryAfter != "2" {
+		t.Errorf("1882734660 expected Retry-After: 2, got %q", retryAfter)
+	}
+	if errNum := w.Header().Get("Grunway-ErrorNumber"); errNum != "4290000001" {
+		t.Errorf("1882734661 expected Grunway-ErrorNumber 4290000001, got %q", errNum)
+	}
+}
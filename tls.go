@@ -0,0 +1,258 @@
+package grunway
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TLSOptions configures HTTPS (and optionally mutual TLS) for a Server.
+type TLSOptions struct {
+	// PEM files for the server certificate.  They are watched and reloaded when they change on disk,
+	// so certificates can be renewed without a restart.
+	CertFile string
+	KeyFile  string
+
+	// How often to check the cert files for changes.  default 1 minute.
+	ReloadInterval time.Duration
+
+	// For mTLS: PEM bundle of CAs allowed to issue client certificates.
+	// Setting this defaults ClientAuth to tls.RequireAndVerifyClientCert.
+	// A ClientAuth that verifies certificates requires CAs, either from here or Config.ClientCAs.
+	ClientCAFile string
+	ClientAuth   tls.ClientAuthType
+
+	// Optional base config.  Certificates, GetCertificate, ClientCAs and ClientAuth are filled in from the fields above.
+	Config *tls.Config
+}
+
+func (options *TLSOptions) enabled() bool {
+	return options != nil && (options.CertFile != "" || options.Config != nil)
+}
+
+func (options *TLSOptions) buildConfig() (*tls.Config, error) {
+	var config *tls.Config
+	if options.Config != nil {
+		config = options.Config.Clone()
+	} else {
+		config = new(tls.Config)
+	}
+	if config.MinVersion == 0 {
+		config.MinVersion = tls.VersionTLS12
+	}
+
+	if options.CertFile != "" {
+		reloader, err := NewCertificateReloader(options.CertFile, options.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if options.ReloadInterval > 0 {
+			reloader.CheckInterval = options.ReloadInterval
+		}
+		config.GetCertificate = reloader.GetCertificate
+	}
+	if config.GetCertificate == nil && len(config.Certificates) == 0 {
+		return nil, fmt.Errorf("1940277410 TLS enabled but no certificate configured")
+	}
+
+	if options.ClientCAFile != "" {
+		pemBytes, err := ioutil.ReadFile(options.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("1940277411 cannot read ClientCAFile: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if pool.AppendCertsFromPEM(pemBytes) == false {
+			return nil, fmt.Errorf("1940277412 no certificates found in ClientCAFile %s", options.ClientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if options.ClientAuth != tls.NoClientCert {
+		config.ClientAuth = options.ClientAuth
+	}
+	verifiesClientCerts := config.ClientAuth == tls.VerifyClientCertIfGiven || config.ClientAuth == tls.RequireAndVerifyClientCert
+	if verifiesClientCerts && config.ClientCAs == nil {
+		// crypto/tls would fall back to the system roots, ie any publicly issued certificate
+		return nil, fmt.Errorf("1940277413 ClientAuth %v verifies client certificates, but there is no ClientCAFile", config.ClientAuth)
+	}
+
+	return config, nil
+}
+
+// ######
+// #     # ###### #       ####    ##   #####  ###### #####
+// #     # #      #      #    #  #  #  #    # #      #    #
+// ######  #####  #      #    # #    # #    # #####  #    #
+// #   #   #      #      #    # ###### #    # #      #####
+// #    #  #      #      #    # #    # #    # #      #   #
+// #     # ###### ######  ####  #    # #####  ###### #    #
+//
+
+// CertificateReloader serves a certificate from disk, reloading it when either file's modification time changes.
+// Use GetCertificate as tls.Config.GetCertificate.
+// If a reload fails (eg the files are mid-rotation), the previous certificate keeps being served.
+type CertificateReloader struct {
+	CertFile      string
+	KeyFile       string
+	CheckInterval time.Duration
+
+	mu          sync.Mutex
+	cert        *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+	lastCheck   time.Time
+}
+
+func NewCertificateReloader(certFile, keyFile string) (*CertificateReloader, error) {
+	reloader := new(CertificateReloader)
+	reloader.CertFile = certFile
+	reloader.KeyFile = keyFile
+	reloader.CheckInterval = time.Minute
+
+	err := reloader.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// Reload unconditionally reloads the certificate, eg from a SIGHUP handler.
+func (reloader *CertificateReloader) Reload() error {
+	certInfo, err := os.Stat(reloader.CertFile)
+	if err != nil {
+		return fmt.Errorf("1940277420 cannot stat CertFile: %v", err)
+	}
+	keyInfo, err := os.Stat(reloader.KeyFile)
+	if err != nil {
+		return fmt.Errorf("1940277421 cannot stat KeyFile: %v", err)
+	}
+	cert, err := tls.LoadX509KeyPair(reloader.CertFile, reloader.KeyFile)
+	if err != nil {
+		return fmt.Errorf("1940277422 cannot load key pair: %v", err)
+	}
+
+	reloader.mu.Lock()
+	defer reloader.mu.Unlock()
+	reloader.cert = &cert
+	reloader.certModTime = certInfo.ModTime()
+	reloader.keyModTime = keyInfo.ModTime()
+	reloader.lastCheck = time.Now()
+	return nil
+}
+
+func (reloader *CertificateReloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	reloader.maybeReload(time.Now())
+
+	reloader.mu.Lock()
+	defer reloader.mu.Unlock()
+	return reloader.cert, nil
+}
+
+func (reloader *CertificateReloader) maybeReload(now time.Time) {
+	reloader.mu.Lock()
+	if now.Sub(reloader.lastCheck) < reloader.CheckInterval {
+		reloader.mu.Unlock()
+		return
+	}
+	reloader.lastCheck = now
+	certModTime, keyModTime := reloader.certModTime, reloader.keyModTime
+	reloader.mu.Unlock()
+
+	certInfo, certErr := os.Stat(reloader.CertFile)
+	keyInfo, keyErr := os.Stat(reloader.KeyFile)
+	if certErr != nil || keyErr != nil {
+		return
+	}
+	if certInfo.ModTime().Equal(certModTime) && keyInfo.ModTime().Equal(keyModTime) {
+		return
+	}
+
+	err := reloader.Reload()
+	if err != nil {
+		log.Println("1940277423 certificate reload failed, keeping previous certificate:", err)
+	} else {
+		log.Println("Reloaded TLS certificate from", reloader.CertFile)
+	}
+}
+
+//  #####                               #####
+// #     # #      # ###### #    # ##### #     # ###### #####  #####
+// #       #      # #      ##   #   #   #       #      #    #   #
+// #       #      # #####  # #  #   #   #       #####  #    #   #
+// #       #      # #      #  # #   #   #       #      #####    #
+// #     # #      # #      #   ##   #   #     # #      #   #    #
+//  #####  ###### # ###### #    #   #    #####  ###### #    #   #
+//
+
+const (
+	ClientCertMissingErrorNumber   = 4030000201 // not a TLS connection, or no verified client certificate
+	ClientCertForbiddenErrorNumber = 4030000202 // verified, but the principal isn't allowed
+)
+
+// ClientCertAuthenticator is an AuthHandler for mTLS.  It trusts only certificates that the TLS stack
+// has already verified (see TLSOptions.ClientCAFile) and maps them to a principal string.
+//
+// On success, ctx.PublicKey is set to the principal.
+type ClientCertAuthenticator struct {
+	// optional, defaults to DefaultClientCertPrincipal
+	Principal func(cert *x509.Certificate) string
+
+	// optional.  if set, only these principals are allowed
+	AllowedPrincipals map[string]bool
+
+	// optional, for finer grained checks (eg per route).  called after AllowedPrincipals
+	Authorize func(principal string, routePtr *Route, ctx *Context) bool
+}
+
+// DefaultClientCertPrincipal uses the first DNS SAN, then the first URI SAN (eg a SPIFFE id),
+// then the first email SAN, then the subject common name.
+func DefaultClientCertPrincipal(cert *x509.Certificate) string {
+	if len(cert.DNSNames) > 0 {
+		return cert.DNSNames[0]
+	}
+	if len(cert.URIs) > 0 {
+		return cert.URIs[0].String()
+	}
+	if len(cert.EmailAddresses) > 0 {
+		return cert.EmailAddresses[0]
+	}
+	return strings.TrimSpace(cert.Subject.CommonName)
+}
+
+func (auth *ClientCertAuthenticator) PerformAuth(routePtr *Route, ctx *Context) (bool, int) {
+	connState := ctx.Req.TLS
+	if connState == nil || len(connState.VerifiedChains) == 0 || len(connState.VerifiedChains[0]) == 0 {
+		return false, ClientCertMissingErrorNumber
+	}
+	leaf := connState.VerifiedChains[0][0]
+
+	principalFunc := auth.Principal
+	if principalFunc == nil {
+		principalFunc = DefaultClientCertPrincipal
+	}
+	principal := principalFunc(leaf)
+	if principal == "" {
+		return false, ClientCertForbiddenErrorNumber
+	}
+
+	if auth.AllowedPrincipals != nil && auth.AllowedPrincipals[principal] == false {
+		return false, ClientCertForbiddenErrorNumber
+	}
+	if auth.Authorize != nil && auth.Authorize(principal, routePtr, ctx) == false {
+		return false, ClientCertForbiddenErrorNumber
+	}
+
+	ctx.PublicKey = principal
+	return true, 0
+}
+
+// There are no shared secrets with certificate auth.
+func (auth *ClientCertAuthenticator) GetSecretKey(publicKey string) (string, int) {
+	return "", ClientCertMissingErrorNumber
+}
@@ -0,0 +1,192 @@
+package grunway
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func makeTestCert(t *testing.T, template *x509.Certificate, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	parentCert, parentKey := template, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+type InternalController struct {
+	ClientCertAuthenticator
+}
+
+func (ic *InternalController) AuthGetHandlerV1Whoami(ctx *Context) RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultGenericJSON(ctx.PublicKey)
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grunway-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := makeTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "test ca"}, IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}, nil)
+	serverCert := makeTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "server"}, IPAddresses: []net.IP{net.ParseIP("127.0.0.1")}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}, ca)
+	clientCert := makeTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "ignored"}, DNSNames: []string{"billing.internal"}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}, ca)
+
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	router := NewRouter()
+	router.BasePath = "/api/"
+	router.PostProcessors = nil
+	router.RegisterEntity("internal", &InternalController{ClientCertAuthenticator{AllowedPrincipals: map[string]bool{"billing.internal": true}}})
+
+	options := DefaultServerOptions()
+	options.DisableSignalHandling = true
+	options.TLS = &TLSOptions{
+		CertFile:     write("server.pem", serverCert.certPEM),
+		KeyFile:      write("server.key", serverCert.keyPEM),
+		ClientCAFile: write("ca.pem", ca.certPEM),
+	}
+	server := NewServer(router, options)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(context.Background(), listener)
+	defer server.Shutdown()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	keyPair, err := tls.X509KeyPair(clientCert.certPEM, clientCert.keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{keyPair}}, ForceAttemptHTTP2: true}}
+
+	response, err := client.Get("https://" + listener.Addr().String() + "/api/v1/internal/whoami")
+	if err != nil {
+		t.Fatal("2811450471", err)
+	}
+	body, _ := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK || string(body) != `"billing.internal"` {
+		t.Errorf("2811450472 expected 200 billing.internal, got %d %s", response.StatusCode, body)
+	}
+	if response.ProtoMajor != 2 {
+		t.Error("2811450479 expected HTTP/2 to be negotiated, got", response.Proto)
+	}
+
+	// no client cert: the handshake itself fails
+	anonymous := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	if response, err := anonymous.Get("https://" + listener.Addr().String() + "/api/v1/internal/whoami"); err == nil {
+		response.Body.Close()
+		t.Error("2811450473 expected request without client cert to fail")
+	}
+}
+
+func TestClientCertAuthenticatorWithoutTLS(t *testing.T) {
+	auth := &ClientCertAuthenticator{}
+	ctx := &Context{Req: &http.Request{}}
+	if ok, errNum := auth.PerformAuth(nil, ctx); ok || errNum != ClientCertMissingErrorNumber {
+		t.Errorf("2811450474 expected ClientCertMissingErrorNumber, got %v %d", ok, errNum)
+	}
+}
+
+func TestTLSClientAuthNeedsCAs(t *testing.T) {
+	base := &tls.Config{Certificates: []tls.Certificate{{}}}
+	for _, clientAuth := range []tls.ClientAuthType{tls.VerifyClientCertIfGiven, tls.RequireAndVerifyClientCert} {
+		options := &TLSOptions{Config: base, ClientAuth: clientAuth}
+		if _, err := options.buildConfig(); err == nil {
+			t.Error("2811450476 expected an error for a verifying ClientAuth w/o CAs", clientAuth)
+		}
+	}
+
+	options := &TLSOptions{Config: base, ClientAuth: tls.RequireAnyClientCert}
+	if _, err := options.buildConfig(); err != nil {
+		t.Error("2811450477 unverified client certs don't need CAs", err)
+	}
+	withCAs := base.Clone()
+	withCAs.ClientCAs = x509.NewCertPool()
+	options = &TLSOptions{Config: withCAs, ClientAuth: tls.RequireAndVerifyClientCert}
+	if _, err := options.buildConfig(); err != nil {
+		t.Error("2811450478 Config.ClientCAs should be enough", err)
+	}
+}
+
+func TestCertificateReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grunway-tls-reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	first := makeTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "first"}}, nil)
+	ioutil.WriteFile(certFile, first.certPEM, 0600)
+	ioutil.WriteFile(keyFile, first.keyPEM, 0600)
+
+	reloader, err := NewCertificateReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloader.CheckInterval = 0
+
+	second := makeTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "second"}}, nil)
+	ioutil.WriteFile(certFile, second.certPEM, 0600)
+	ioutil.WriteFile(keyFile, second.keyPEM, 0600)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	os.Chtimes(keyFile, later, later)
+
+	cert, _ := reloader.GetCertificate(nil)
+	leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+	if leaf.Subject.CommonName != "second" {
+		t.Errorf("2811450475 expected reloaded certificate, got %v", leaf.Subject.CommonName)
+	}
+}
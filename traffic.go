@@ -0,0 +1,457 @@
+package grunway
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/amattn/deeperror"
+)
+
+const (
+	RedactedValue          = "[REDACTED]"
+	DefaultMaxRecordedBody = 1 << 20
+)
+
+// ResponseCapturer is implemented by PostProcessors that need the request and response bodies.
+// If any PostProcessor returns true, the router tees the response body and, once the route is found and the request
+// is authorized, buffers the request body.  They are available from ctx.ResponseBody and ctx.RequestBody.
+//
+// Only the first DefaultMaxRecordedBody bytes of each body are kept, see CaptureLimiter and ctx.CapturedBodiesTruncated.
+type ResponseCapturer interface {
+	CapturesResponses() bool
+}
+
+// CaptureLimiter is optionally implemented by ResponseCapturers that want more or less than DefaultMaxRecordedBody.
+// The router keeps as much as the largest limit asks for.
+type CaptureLimiter interface {
+	CaptureLimit() int
+}
+
+// 0 if nothing captures
+func (router *Router) captureLimit() int {
+	limit := 0
+	for _, postProcessor := range router.PostProcessors {
+		capturer, ok := postProcessor.(ResponseCapturer)
+		if ok == false || capturer.CapturesResponses() == false {
+			continue
+		}
+		capturerLimit := DefaultMaxRecordedBody
+		if limiter, ok := postProcessor.(CaptureLimiter); ok && limiter.CaptureLimit() > 0 {
+			capturerLimit = limiter.CaptureLimit()
+		}
+		if capturerLimit > limit {
+			limit = capturerLimit
+		}
+	}
+	return limit
+}
+
+// Responses are teed, up to limit.  See captureRequestBody for the request.
+func (ctx *Context) captureResponse(limit int) {
+	ctx.capturedResponse = &capturingResponseWriter{ResponseWriter: ctx.w, limit: limit}
+	ctx.w = ctx.capturedResponse
+}
+
+// Request bodies that fit are buffered as if ctx.RequestBody had been called.  Larger ones are left for the handler
+// to read as usual, w/ nothing kept.  Called after routing and auth, so unknown routes and unauthorized
+// requests never get their bodies buffered.
+func (ctx *Context) captureRequestBody() {
+	ctx.requestBodyCaptured = true
+	limit := ctx.capturedResponse.limit
+	if ctx.cachedRequestBody != nil || ctx.cachedRequestBodyError != nil {
+		// already read, eg to check a signature
+		ctx.capturedRequestTruncated = len(ctx.cachedRequestBody) > limit
+		return
+	}
+	if ctx.Req.Body == nil {
+		return
+	}
+	// one byte past the limit tells a body that fits from one that doesn't
+	original := ctx.Req.Body
+	prefix, err := ioutil.ReadAll(io.LimitReader(original, int64(limit)+1))
+	switch {
+	case err != nil:
+		ctx.cachedRequestBodyError = err
+	case len(prefix) > limit:
+		ctx.capturedRequestTruncated = true
+		ctx.Req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(prefix), original), original}
+	default:
+		ctx.cachedRequestBody = prefix
+		ctx.Req.Body = ioutil.NopCloser(bytes.NewReader(prefix))
+	}
+}
+
+// ResponseBody returns the bytes written so far, up to the capture limit.
+// Only populated when a PostProcessor implements ResponseCapturer.
+func (ctx *Context) ResponseBody() []byte {
+	if ctx.capturedResponse == nil {
+		return nil
+	}
+	return ctx.capturedResponse.body.Bytes()
+}
+
+// CapturedBodiesTruncated reports bodies that went past the capture limit.  A truncated request body wasn't buffered,
+// so ctx.RequestBody reads it from the connection as usual (or returns what's left, if the handler got there first).
+// The body of a request that never reached its handler (eg a 404 or 403) isn't buffered either, and counts as truncated.
+// A truncated response body is only the first part of what was sent.
+func (ctx *Context) CapturedBodiesTruncated() (request, response bool) {
+	if ctx.capturedResponse == nil {
+		return false, false
+	}
+	request = ctx.capturedRequestTruncated
+	if ctx.requestBodyCaptured == false {
+		request = ctx.Req.Body != nil && ctx.Req.Body != http.NoBody && ctx.Req.ContentLength != 0
+	}
+	return request, ctx.capturedResponse.truncated
+}
+
+type capturingResponseWriter struct {
+	http.ResponseWriter
+	body      bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (writer *capturingResponseWriter) Write(p []byte) (int, error) {
+	kept := p
+	if room := writer.limit - writer.body.Len(); len(kept) > room {
+		kept = kept[:room]
+		writer.truncated = true
+	}
+	writer.body.Write(kept)
+	return writer.ResponseWriter.Write(p)
+}
+
+// pass through, so wrapping doesn't hide streaming or protocol upgrades.
+// Unwrap is for http.ResponseController, eg clearing the write deadline for streams.
+func (writer *capturingResponseWriter) Unwrap() http.ResponseWriter {
+	return writer.ResponseWriter
+}
+func (writer *capturingResponseWriter) Flush() {
+	if flusher, ok := writer.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+func (writer *capturingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := writer.ResponseWriter.(http.Hijacker)
+	if ok == false {
+		return nil, nil, fmt.Errorf("2590157101 ResponseWriter does not support Hijack")
+	}
+	return hijacker.Hijack()
+}
+
+// ######
+// #     # ######  ####   ####  #####  #####
+// #     # #      #    # #    # #    # #    #
+// ######  #####  #      #    # #    # #    #
+// #   #   #      #      #    # #####  #    #
+// #    #  #      #    # #    # #   #  #    #
+// #     # ######  ####   ####  #    # #####
+//
+
+// RecordedExchange is one line of a recording.  Bodies that are valid JSON are stored as JSON, others as text (see RecordTextBodies).
+type RecordedExchange struct {
+	Time            time.Time         `json:"time"`
+	Method          string            `json:"method"`
+	URL             string            `json:"url"` // path and query, eg /api/v1/book/12?expand=author
+	RequestHeaders  map[string]string `json:"requestHeaders,omitempty"`
+	RequestBody     json.RawMessage   `json:"requestBody,omitempty"`
+	RequestText     string            `json:"requestText,omitempty"`
+	Status          int               `json:"status"`
+	ErrorNumber     int64             `json:"errorNumber,omitempty"`
+	ResponseHeaders map[string]string `json:"responseHeaders,omitempty"`
+	ResponseBody    json.RawMessage   `json:"responseBody,omitempty"`
+	ResponseText    string            `json:"responseText,omitempty"`
+
+	// bodies over MaxBodyBytes aren't recorded, nor are text bodies w/o RecordTextBodies
+	RequestTruncated  bool `json:"requestTruncated,omitempty"`
+	ResponseTruncated bool `json:"responseTruncated,omitempty"`
+}
+
+type TrafficRecorderOptions struct {
+	// request/response headers to record.  nothing else is recorded, so credentials stay out by default.
+	// nil means Content-Type and Accept.
+	Headers []string
+
+	// values at these JSON pointers are replaced w/ RedactedValue in both bodies, eg "/Password" or "/Payloads/account/*/Email"
+	RedactPointers []string
+
+	MaxBodyBytes int // bodies larger than this are not recorded, or kept in memory.  default DefaultMaxRecordedBody
+
+	// bodies that aren't JSON, eg form posts, are only recorded (as text) if true.  RedactPointers can't reach into them.
+	RecordTextBodies bool
+
+	Filter func(ctx *Context) bool // optional, return false to skip a request
+}
+
+// TrafficRecorder is a PostProcessor that writes sanitized request/response pairs as JSON Lines.
+//
+//	recorder, err := grunway.NewFileTrafficRecorder("traffic.jsonl", grunway.TrafficRecorderOptions{RedactPointers: []string{"/Password"}})
+//	router.PostProcessors = append(router.PostProcessors, recorder)
+//
+// Only PayloadWrapper responses pass through PostProcessors, so raw JSON routes are not recorded.
+type TrafficRecorder struct {
+	Options TrafficRecorderOptions
+
+	mu     sync.Mutex
+	out    io.Writer
+	closer io.Closer
+}
+
+func NewTrafficRecorder(out io.Writer, options TrafficRecorderOptions) *TrafficRecorder {
+	if options.Headers == nil {
+		options.Headers = []string{httpHeaderContentType, "Accept"}
+	}
+	if options.MaxBodyBytes <= 0 {
+		options.MaxBodyBytes = DefaultMaxRecordedBody
+	}
+	recorder := new(TrafficRecorder)
+	recorder.Options = options
+	recorder.out = out
+	return recorder
+}
+
+// NewFileTrafficRecorder appends to path.  Call Close when done.
+func NewFileTrafficRecorder(path string, options TrafficRecorderOptions) (*TrafficRecorder, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("2590157110 cannot open traffic recording: %v", err)
+	}
+	recorder := NewTrafficRecorder(file, options)
+	recorder.closer = file
+	return recorder, nil
+}
+
+func (recorder *TrafficRecorder) Close() error {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	if recorder.closer == nil {
+		return nil
+	}
+	err := recorder.closer.Close()
+	recorder.closer = nil
+	return err
+}
+
+func (recorder *TrafficRecorder) CapturesResponses() bool {
+	return true
+}
+func (recorder *TrafficRecorder) CaptureLimit() int {
+	return recorder.Options.MaxBodyBytes
+}
+
+func (recorder *TrafficRecorder) Process(ctx *Context) (bool, *deeperror.DeepError) {
+	if recorder.Options.Filter != nil && recorder.Options.Filter(ctx) == false {
+		return false, nil
+	}
+
+	exchange := RecordedExchange{
+		Time:            time.Now().UTC(),
+		Method:          ctx.Req.Method,
+		URL:             ctx.Req.URL.RequestURI(),
+		RequestHeaders:  recorder.selectHeaders(ctx.Req.Header),
+		Status:          ctx.StatusCode,
+		ResponseHeaders: recorder.selectHeaders(ctx.w.Header()),
+	}
+	exchange.ErrorNumber = recordedErrorNumber(ctx.w.Header(), ctx.ResponseBody())
+
+	requestTruncated, responseTruncated := ctx.CapturedBodiesTruncated()
+	if requestTruncated == false {
+		requestBody, _ := ctx.RequestBody()
+		exchange.RequestBody, exchange.RequestText, requestTruncated = recorder.sanitizeBody(requestBody)
+	}
+	if responseTruncated == false {
+		exchange.ResponseBody, exchange.ResponseText, responseTruncated = recorder.sanitizeBody(ctx.ResponseBody())
+	}
+	exchange.RequestTruncated, exchange.ResponseTruncated = requestTruncated, responseTruncated
+
+	line, err := json.Marshal(exchange)
+	if err != nil {
+		return false, nil
+	}
+	line = append(line, '\n')
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	if recorder.out != nil {
+		recorder.out.Write(line)
+	}
+	return false, nil
+}
+
+func (recorder *TrafficRecorder) selectHeaders(header http.Header) map[string]string {
+	selected := map[string]string{}
+	for _, key := range recorder.Options.Headers {
+		if value := header.Get(key); value != "" {
+			selected[http.CanonicalHeaderKey(key)] = value
+		}
+	}
+	if len(selected) == 0 {
+		return nil
+	}
+	return selected
+}
+
+// omitted is true for bodies over MaxBodyBytes (another capturer may have asked for more than we record),
+// and for text bodies w/o RecordTextBodies
+func (recorder *TrafficRecorder) sanitizeBody(body []byte) (sanitized json.RawMessage, text string, omitted bool) {
+	if len(body) > recorder.Options.MaxBodyBytes {
+		return nil, "", true
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil, "", false
+	}
+	doc, err := decodeJSONDocument(body)
+	if err != nil {
+		if recorder.Options.RecordTextBodies == false {
+			// eg password=... in a form post
+			return nil, "", true
+		}
+		return nil, string(body), false
+	}
+	for _, pointer := range recorder.Options.RedactPointers {
+		doc = rewriteJSONPointer(doc, splitJSONPointer(pointer), func(interface{}) (interface{}, bool) {
+			return RedactedValue, false
+		})
+	}
+	sanitized, err = json.Marshal(doc)
+	if err != nil {
+		return nil, "", false
+	}
+	return sanitized, "", false
+}
+
+// ######
+// #     # ###### #####  #        ##   #   #
+// #     # #      #    # #       #  #   # #
+// ######  #####  #    # #      #    #   #
+// #   #   #      #####  #      ######   #
+// #    #  #      #      #      #    #   #
+// #     # ###### #      ###### #    #   #
+//
+
+// ExchangeDifference is one way a response differs from a RecordedExchange, see RecordedExchange.Compare.
+type ExchangeDifference struct {
+	Field    string // "status", "errorNumber" or "payloads"
+	Pointer  string `json:",omitempty"` // for payloads, where they differ
+	Expected string
+	Actual   string
+}
+
+// Compare checks a replayed response against the recording: status, ErrorNumber and Payloads.
+// Values at ignorePointers are left out of the payload comparison, which reports at most maxPayloadDifferences (10 if <= 0).
+// Payloads aren't compared if the recorded response body was truncated.
+//
+// grunwaytest.ReplayTraffic runs a whole recording through a router.
+func (exchange RecordedExchange) Compare(status int, header http.Header, body []byte, ignorePointers []string, maxPayloadDifferences int) []ExchangeDifference {
+	if maxPayloadDifferences <= 0 {
+		maxPayloadDifferences = 10
+	}
+	differences := []ExchangeDifference{}
+	if status != exchange.Status {
+		differences = append(differences, ExchangeDifference{
+			Field:    "status",
+			Expected: strconv.Itoa(exchange.Status),
+			Actual:   strconv.Itoa(status),
+		})
+	}
+	actualErrorNumber := recordedErrorNumber(header, body)
+	if actualErrorNumber != exchange.ErrorNumber {
+		differences = append(differences, ExchangeDifference{
+			Field:    "errorNumber",
+			Expected: strconv.FormatInt(exchange.ErrorNumber, 10),
+			Actual:   strconv.FormatInt(actualErrorNumber, 10),
+		})
+	}
+
+	if exchange.ResponseTruncated {
+		// nothing to compare against
+		return differences
+	}
+	expectedPayloads := replayPayloads(exchange.ResponseBody, ignorePointers)
+	actualPayloads := replayPayloads(body, ignorePointers)
+	for _, pointer := range jsonDifferences("/Payloads", expectedPayloads, actualPayloads, maxPayloadDifferences) {
+		differences = append(differences, ExchangeDifference{
+			Field:    "payloads",
+			Pointer:  pointer,
+			Expected: jsonPointerValueString(expectedPayloads, pointer),
+			Actual:   jsonPointerValueString(actualPayloads, pointer),
+		})
+	}
+	return differences
+}
+
+// not every error path sets the Grunway-ErrorNumber header (eg sendNotFoundPayload), so fall back to the body
+func recordedErrorNumber(header http.Header, body []byte) int64 {
+	errorNumber, err := strconv.ParseInt(header.Get("Grunway-ErrorNumber"), 10, 64)
+	if err == nil {
+		return errorNumber
+	}
+	var errorInfo ErrorInfo
+	if json.Unmarshal(body, &errorInfo) != nil {
+		return 0
+	}
+	return errorInfo.ErrorNumber
+}
+
+// the Payloads of a PayloadWrapper body, w/ the ignored pointers removed.  nil if there are none.
+func replayPayloads(body []byte, ignorePointers []string) interface{} {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+	doc, err := decodeJSONDocument(body)
+	if err != nil {
+		return nil
+	}
+	for _, pointer := range ignorePointers {
+		doc = rewriteJSONPointer(doc, splitJSONPointer(pointer), func(interface{}) (interface{}, bool) {
+			return nil, true
+		})
+	}
+	if wrapper, ok := doc.(map[string]interface{}); ok {
+		return wrapper["Payloads"]
+	}
+	return nil
+}
+
+// pointer is relative to the document root, ie it starts w/ /Payloads
+func jsonPointerValueString(payloads interface{}, pointer string) string {
+	var found interface{} = payloads
+	segments := splitJSONPointer(pointer)
+	if len(segments) > 0 && segments[0] == "Payloads" {
+		segments = segments[1:]
+	}
+	for _, segment := range segments {
+		switch typed := found.(type) {
+		case map[string]interface{}:
+			found = typed[segment]
+		case []interface{}:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(typed) {
+				found = nil
+			} else {
+				found = typed[index]
+			}
+		default:
+			found = nil
+		}
+	}
+	if found == nil {
+		return "<missing>"
+	}
+	encoded, _ := json.Marshal(found)
+	return string(encoded)
+}
@@ -0,0 +1,203 @@
+package grunway
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type ticketPayload struct {
+	PKey     int64
+	Name     string
+	Password string
+	Issued   int64
+}
+
+func (payload ticketPayload) PayloadType() string {
+	return "ticket"
+}
+
+type ticketController struct {
+	name   string
+	issued int64
+}
+
+func (controller *ticketController) GetHandlerV1(ctx *Context) RouteHandlerResult {
+	controller.issued++
+	return ctx.MakeRouteHandlerResultPayloads(ticketPayload{PKey: ctx.End.PrimaryKey, Name: controller.name, Issued: controller.issued})
+}
+func (controller *ticketController) PostHandlerV1(ctx *Context) RouteHandlerResult {
+	var ticket ticketPayload
+	body, _ := ctx.RequestBody()
+	if err := json.Unmarshal(body, &ticket); err != nil {
+		return ctx.MakeRouteHandlerResultError(http.StatusBadRequest, 2590157190, "bad ticket")
+	}
+	return ctx.MakeRouteHandlerResultPayloads(ticket)
+}
+
+func makeTicketRouter(name string, postProcessors ...PostProcessor) *Router {
+	router := NewRouter()
+	router.BasePath = "/api/"
+	router.PostProcessors = postProcessors
+	router.RegisterEntity("ticket", &ticketController{name: name})
+	return router
+}
+
+func TestTrafficRecordAndCompare(t *testing.T) {
+	recording := new(bytes.Buffer)
+	recorder := NewTrafficRecorder(recording, TrafficRecorderOptions{RedactPointers: []string{"/Password", "/Payloads/ticket/*/Password"}})
+	router := makeTicketRouter("admit one", recorder)
+
+	send := func(method, path, body string) {
+		req := httptest.NewRequest(method, "http://localhost"+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "secret")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	send("GET", "/api/v1/ticket/7", "")
+	send("POST", "/api/v1/ticket/", `{"PKey":8,"Name":"vip","Password":"hunter2"}`)
+	send("POST", "/api/v1/ticket/", `not json`)
+	send("GET", "/api/v1/nothing/1", "")
+
+	lines := strings.Split(strings.TrimSpace(recording.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatal("2590157191 expected 4 recorded exchanges, got", len(lines), recording.String())
+	}
+	if strings.Contains(recording.String(), "hunter2") || strings.Contains(recording.String(), "secret") {
+		t.Fatal("2590157192 recording was not sanitized", recording.String())
+	}
+	var exchange RecordedExchange
+	json.Unmarshal([]byte(lines[2]), &exchange)
+	// text can't be redacted, so it's left out by default
+	if exchange.Status != http.StatusBadRequest || exchange.ErrorNumber != 2590157190 || exchange.RequestText != "" || exchange.RequestTruncated == false {
+		t.Error("2590157193 unexpected exchange", lines[2])
+	}
+	exchange = RecordedExchange{}
+	json.Unmarshal([]byte(lines[3]), &exchange)
+	if exchange.Status != http.StatusNotFound || exchange.ErrorNumber == 0 {
+		t.Error("2590157194 expected the not found error number from the body", lines[3])
+	}
+
+	// Issued changes on every request, so it has to be ignored.  The redacted password doesn't round trip either.
+	ignore := []string{"/Payloads/ticket/*/Issued", "/Payloads/ticket/*/Password"}
+	replay := func(routerPtr *Router, line string, ignorePointers []string) []ExchangeDifference {
+		var exchange RecordedExchange
+		json.Unmarshal([]byte(line), &exchange)
+		w := httptest.NewRecorder()
+		routerPtr.ServeHTTP(w, httptest.NewRequest(exchange.Method, exchange.URL, strings.NewReader(exchange.RequestText)))
+		return exchange.Compare(w.Code, w.Header(), w.Body.Bytes(), ignorePointers, 0)
+	}
+	for _, i := range []int{0, 2, 3} {
+		if differences := replay(makeTicketRouter("admit one"), lines[i], ignore); len(differences) != 0 {
+			t.Error("2590157195 expected a clean comparison", lines[i], differences)
+		}
+	}
+
+	differences := replay(makeTicketRouter("admit two"), lines[0], ignore)
+	if len(differences) != 1 || differences[0].Pointer != "/Payloads/ticket/0/Name" || differences[0].Expected != `"admit one"` || differences[0].Actual != `"admit two"` {
+		t.Error("2590157196 expected one payload difference", differences)
+	}
+
+	busyRouter := makeTicketRouter("admit one")
+	busyRouter.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://localhost/api/v1/ticket/1", nil))
+	differences = replay(busyRouter, lines[0], ignore[1:])
+	if len(differences) != 1 || differences[0].Pointer != "/Payloads/ticket/0/Issued" {
+		t.Error("2590157198 expected Issued to differ without IgnorePointers", differences)
+	}
+
+	var exchange404 RecordedExchange
+	json.Unmarshal([]byte(lines[3]), &exchange404)
+	if differences = exchange404.Compare(http.StatusOK, http.Header{}, nil, nil, 0); len(differences) != 2 || differences[0].Field != "status" || differences[1].Field != "errorNumber" {
+		t.Error("2590157197 expected status and errorNumber differences", differences)
+	}
+}
+
+type countingReader struct {
+	reader *strings.Reader
+	read   int
+}
+
+func (reader *countingReader) Read(p []byte) (int, error) {
+	n, err := reader.reader.Read(p)
+	reader.read += n
+	return n, err
+}
+
+func TestTrafficRecorderTextBodies(t *testing.T) {
+	form := "name=vip&password=hunter2"
+	for _, recordText := range []bool{false, true} {
+		recording := new(bytes.Buffer)
+		router := makeTicketRouter("admit one", NewTrafficRecorder(recording, TrafficRecorderOptions{RecordTextBodies: recordText}))
+		req := httptest.NewRequest("POST", "http://localhost/api/v1/ticket/", strings.NewReader(form))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+
+		var exchange RecordedExchange
+		json.Unmarshal(recording.Bytes(), &exchange)
+		if recordText && (exchange.RequestText != form || exchange.RequestTruncated) {
+			t.Error("2590157205 expected the form as text", recording.String())
+		}
+		if recordText == false && (strings.Contains(recording.String(), "hunter2") || exchange.RequestTruncated == false) {
+			t.Error("2590157206 expected the form to be left out", recording.String())
+		}
+	}
+
+	// requests that never reach a handler don't get their bodies buffered
+	recording := new(bytes.Buffer)
+	router := makeTicketRouter("admit one", NewTrafficRecorder(recording, TrafficRecorderOptions{}))
+	body := &countingReader{reader: strings.NewReader(`{"Name":"nobody"}`)}
+	req := httptest.NewRequest("POST", "http://localhost/api/v1/nothing/", body)
+	req.ContentLength = -1
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var exchange RecordedExchange
+	json.Unmarshal(recording.Bytes(), &exchange)
+	if w.Code != http.StatusNotFound || body.read != 0 || exchange.RequestTruncated == false || exchange.ResponseBody == nil {
+		t.Error("2590157207 unexpected capture for an unknown route", w.Code, body.read, recording.String())
+	}
+}
+
+func TestTrafficRecorderBodyLimit(t *testing.T) {
+	recording := new(bytes.Buffer)
+	router := makeTicketRouter("admit one", NewTrafficRecorder(recording, TrafficRecorderOptions{MaxBodyBytes: 32}))
+
+	longName := strings.Repeat("x", 100)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "http://localhost/api/v1/ticket/", strings.NewReader(`{"PKey":8,"Name":"`+longName+`"}`)))
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), longName) == false {
+		t.Fatal("2590157200 the handler should still see the whole body", w.Code, w.Body.String())
+	}
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://localhost/api/v1/ticket/7", nil))
+
+	lines := strings.Split(strings.TrimSpace(recording.String()), "\n")
+	if len(lines) != 2 || strings.Contains(recording.String(), longName) {
+		t.Fatal("2590157201 oversized bodies should not be recorded", recording.String())
+	}
+	var exchange RecordedExchange
+	json.Unmarshal([]byte(lines[0]), &exchange)
+	if exchange.RequestTruncated == false || exchange.ResponseTruncated == false || exchange.Status != http.StatusOK {
+		t.Error("2590157202 expected both bodies flagged as truncated", lines[0])
+	}
+	exchange = RecordedExchange{}
+	json.Unmarshal([]byte(lines[1]), &exchange)
+	if exchange.RequestTruncated || exchange.ResponseTruncated == false {
+		t.Error("2590157203 expected only the response flagged as truncated", lines[1])
+	}
+
+	if differences := exchange.Compare(http.StatusOK, http.Header{}, []byte(`{"Payloads":{"ticket":[]}}`), nil, 0); len(differences) != 0 {
+		t.Error("2590157204 truncated responses can't be compared", differences)
+	}
+}
+
+func TestJSONPointerRewrite(t *testing.T) {
+	doc, _ := decodeJSONDocument([]byte(`{"a/b":{"x":1},"list":[{"y":1},{"y":2}]}`))
+	doc = rewriteJSONPointer(doc, splitJSONPointer("/a~1b/x"), func(interface{}) (interface{}, bool) { return "z", false })
+	doc = rewriteJSONPointer(doc, splitJSONPointer("/list/*/y"), func(interface{}) (interface{}, bool) { return nil, true })
+	encoded, _ := json.Marshal(doc)
+	if string(encoded) != `{"a/b":{"x":"z"},"list":[{},{}]}` {
+		t.Error("2590157199 unexpected rewrite", string(encoded))
+	}
+}
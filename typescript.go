@@ -0,0 +1,400 @@
+package grunway
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const DefaultTypeScriptClientName = "GrunwayClient"
+
+type TypeScriptOptions struct {
+	// payload types to emit even if no RouteDocs mention them
+	Payloads []Payload
+
+	ClientName string // default DefaultTypeScriptClientName
+	SkipClient bool   // only emit the types
+}
+
+var typeScriptIdentifier = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// TypeScript generates TypeScript interfaces for the payload types (as documented by RouteDocs), the PayloadWrapper
+// envelope and ErrorInfo, plus a fetch based client w/ one method per route.
+//
+// Output is deterministic: the same routes and types always generate the same bytes, so it can be checked in
+// and diffed in CI.  See the grunwaytsgen package for a command line wrapper.
+func (router *Router) TypeScript(options TypeScriptOptions) []byte {
+	if options.ClientName == "" {
+		options.ClientName = DefaultTypeScriptClientName
+	}
+	gen := newTypeScriptGenerator()
+
+	type tsRoute struct {
+		info RouteInfo
+		doc  HandlerDoc
+	}
+	routes := []tsRoute{}
+	for _, routeInfo := range router.AllRoutes() {
+		if _, isDocsController := router.Controllers[routeInfo.EntityName].(*OpenAPIController); isDocsController {
+			// serves raw JSON, not a PayloadWrapper
+			continue
+		}
+		if routeInfo.WebSocket {
+			// not request/response
+			continue
+		}
+		doc := router.handlerDoc(routeInfo)
+		if doc.Request != nil {
+			gen.payload(doc.Request)
+		}
+		for _, payload := range doc.Responses {
+			gen.payload(payload)
+		}
+		routes = append(routes, tsRoute{routeInfo, doc})
+	}
+	for _, payload := range options.Payloads {
+		gen.payload(payload)
+	}
+
+	out := new(bytes.Buffer)
+	out.WriteString("// Code generated by grunway. DO NOT EDIT.\n\n")
+	out.WriteString(typeScriptEnvelope)
+
+	names := make([]string, 0, len(gen.interfaces))
+	for name := range gen.interfaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "\nexport interface %s %s\n", name, gen.interfaces[name])
+	}
+
+	payloadTypes := make([]string, 0, len(gen.payloadTypes))
+	for payloadType := range gen.payloadTypes {
+		payloadTypes = append(payloadTypes, payloadType)
+	}
+	sort.Strings(payloadTypes)
+	out.WriteString("\n/** PayloadType() -> interface */\nexport interface PayloadTypes {\n")
+	for _, payloadType := range payloadTypes {
+		fmt.Fprintf(out, "  %s: %s;\n", typeScriptPropertyName(payloadType), gen.payloadTypes[payloadType])
+	}
+	out.WriteString("}\n")
+
+	if options.SkipClient {
+		return out.Bytes()
+	}
+
+	out.WriteString(typeScriptClientPreamble)
+	fmt.Fprintf(out, "\nexport class %s {\n", options.ClientName)
+	out.WriteString(typeScriptClientRequest)
+	for _, route := range routes {
+		writeTypeScriptMethod(out, gen, route.info, route.info.PathTemplate, route.doc)
+		if route.info.KeyedPathTemplate != "" {
+			// actions take an optional id, the keyed variant gets its own method
+			writeTypeScriptMethod(out, gen, route.info, route.info.KeyedPathTemplate, route.doc)
+		}
+	}
+	out.WriteString("}\n")
+	return out.Bytes()
+}
+
+// pathTemplate is routeInfo's PathTemplate or KeyedPathTemplate
+func writeTypeScriptMethod(out *bytes.Buffer, gen *typeScriptGenerator, routeInfo RouteInfo, pathTemplate string, doc HandlerDoc) {
+	params := []string{}
+	hasID := strings.Contains(pathTemplate, "{id}")
+	if hasID {
+		params = append(params, "id: number")
+	}
+	bodyArg := "undefined"
+	switch routeInfo.Method {
+	case "POST", "PUT", "PATCH":
+		if doc.Request != nil {
+			params = append(params, "body: "+gen.payloadRef(doc.Request))
+		} else {
+			params = append(params, "body?: unknown")
+		}
+		bodyArg = "body"
+	}
+	params = append(params, "init?: RequestInit")
+
+	payloadsType := "Record<string, unknown[]>"
+	if len(doc.Responses) > 0 {
+		fields := []string{}
+		for _, payload := range doc.Responses {
+			fields = append(fields, fmt.Sprintf("%s?: %s[]", typeScriptPropertyName(payload.PayloadType()), gen.payloadRef(payload)))
+		}
+		payloadsType = "{ " + strings.Join(fields, "; ") + " }"
+	}
+
+	path := "\"" + pathTemplate + "\""
+	if hasID {
+		path = "`" + strings.Replace(pathTemplate, "{id}", "${id}", 1) + "`"
+	}
+	methodName := typeScriptMethodName(routeInfo)
+	if pathTemplate == routeInfo.KeyedPathTemplate {
+		methodName += "WithID"
+	}
+
+	out.WriteString("\n  /**\n")
+	if doc.Summary != "" {
+		fmt.Fprintf(out, "   * %s\n", doc.Summary)
+	}
+	fmt.Fprintf(out, "   * %s %s", routeInfo.Method, pathTemplate)
+	if routeInfo.RequiresAuth {
+		out.WriteString(" (auth required)")
+	}
+	out.WriteString("\n")
+	if routeInfo.Deprecated {
+		deprecation := ""
+		if routeInfo.Deprecation != nil {
+			deprecation = strings.TrimSpace(routeInfo.Deprecation.Message + " " + routeInfo.Deprecation.Replacement)
+		}
+		fmt.Fprintf(out, "   * @deprecated %s\n", deprecation)
+	}
+	out.WriteString("   */\n")
+	fmt.Fprintf(out, "  %s(%s): Promise<PayloadWrapper<%s>> {\n", methodName, strings.Join(params, ", "), payloadsType)
+	fmt.Fprintf(out, "    return this.request(\"%s\", %s, %s, init);\n", routeInfo.Method, path, bodyArg)
+	out.WriteString("  }\n")
+}
+
+// eg entity "book", handler "AuthPostHandlerV1Revoke" -> bookPostV1Revoke
+func typeScriptMethodName(routeInfo RouteInfo) string {
+	handler := strings.TrimPrefix(routeInfo.Handler, MAGIC_AUTH_REQUIRED_PREFIX)
+	handler = strings.Replace(handler, MAGIC_HANDLER_KEYWORD, "", 1)
+
+	entity := ""
+	upperNext := false
+	for i, r := range routeInfo.EntityName {
+		isAlnum := (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+		if isAlnum == false {
+			upperNext = true
+			continue
+		}
+		s := string(r)
+		if upperNext && i > 0 {
+			s = strings.ToUpper(s)
+		}
+		upperNext = false
+		entity += s
+	}
+	if entity == "" || (entity[0] >= '0' && entity[0] <= '9') {
+		entity = "_" + entity
+	}
+	return strings.ToLower(entity[:1]) + entity[1:] + handler
+}
+
+func typeScriptPropertyName(name string) string {
+	if typeScriptIdentifier.MatchString(name) {
+		return name
+	}
+	return fmt.Sprintf("%q", name)
+}
+
+// #######
+//    #    #   # #####  ######  ####
+//    #     # #  #    # #      #
+//    #      #   #    # #####   ####
+//    #      #   #####  #           #
+//    #      #   #      #      #    #
+//    #      #   #      ######  ####
+//
+
+type typeScriptGenerator struct {
+	interfaces   map[string]string       // name -> body
+	names        map[reflect.Type]string // named struct -> interface name
+	payloadTypes map[string]string       // PayloadType() -> interface name
+}
+
+func newTypeScriptGenerator() *typeScriptGenerator {
+	gen := new(typeScriptGenerator)
+	gen.interfaces = make(map[string]string)
+	gen.names = make(map[reflect.Type]string)
+	gen.payloadTypes = make(map[string]string)
+	return gen
+}
+
+func (gen *typeScriptGenerator) payload(payload Payload) {
+	gen.payloadTypes[payload.PayloadType()] = gen.payloadRef(payload)
+}
+
+// payloads registered as pointers are still never null
+func (gen *typeScriptGenerator) payloadRef(payload Payload) string {
+	t := reflect.TypeOf(payload)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return gen.typeRef(t)
+}
+
+func (gen *typeScriptGenerator) typeRef(t reflect.Type) string {
+	if t.Kind() == reflect.Ptr {
+		elem := gen.typeRef(t.Elem())
+		if strings.HasSuffix(elem, " | null") {
+			return elem
+		}
+		return elem + " | null"
+	}
+	if t == timeType {
+		return "string" // RFC 3339
+	}
+	if t == jsonRawMessageType {
+		return "unknown"
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "string" // base64
+		}
+		elem := gen.typeRef(t.Elem())
+		if strings.Contains(elem, " ") {
+			elem = "(" + elem + ")"
+		}
+		return elem + "[]"
+	case reflect.Map:
+		return "Record<string, " + gen.typeRef(t.Elem()) + ">"
+	case reflect.Struct:
+		if t.Name() == "" {
+			return gen.structBody(t, true)
+		}
+		name, exists := gen.names[t]
+		if exists == false {
+			name = gen.interfaceName(t)
+			gen.names[t] = name
+			gen.interfaces[name] = "{}" // placeholder, in case of recursive types
+			gen.interfaces[name] = gen.structBody(t, false)
+		}
+		return name
+	default:
+		return "unknown"
+	}
+}
+
+func (gen *typeScriptGenerator) interfaceName(t reflect.Type) string {
+	name := t.Name()
+	if _, taken := gen.interfaces[name]; taken || name == "ErrorInfo" || name == "PayloadWrapper" || name == "PayloadTypes" {
+		// same name, different package, or one of ours
+		name = strings.NewReplacer("/", "_", ".", "_", "-", "_").Replace(t.PkgPath() + "." + t.Name())
+	}
+	return name
+}
+
+// omitempty and pointer fields are optional.  anonymous structs are inlined on one line.
+func (gen *typeScriptGenerator) structBody(t reflect.Type, inline bool) string {
+	members := []string{}
+	for _, field := range structJSONFields(t) {
+		optional := ""
+		if field.OmitEmpty || field.Type.Kind() == reflect.Ptr {
+			optional = "?"
+		}
+		fieldType := "string"
+		if field.AsString == false {
+			fieldType = gen.typeRef(field.Type)
+		}
+		members = append(members, fmt.Sprintf("%s%s: %s;", typeScriptPropertyName(field.Name), optional, fieldType))
+	}
+	if len(members) == 0 {
+		return "{}"
+	}
+	if inline {
+		return "{ " + strings.Join(members, " ") + " }"
+	}
+	return "{\n  " + strings.Join(members, "\n  ") + "\n}"
+}
+
+// mirrors ErrorInfo and PayloadWrapper in payload.go
+const typeScriptEnvelope = `export interface ErrorInfo {
+  errorNumber?: number;
+  errorMessage?: string;
+  debugNumber?: number;
+  debugMessage?: string;
+}
+
+/** Every grunway response is wrapped in this envelope.  Payloads is keyed by PayloadType(). */
+export interface PayloadWrapper<P = Record<string, unknown[]>> extends ErrorInfo {
+  Payloads?: P;
+  Alert?: string;
+  Meta?: Record<string, unknown>;
+  Links?: PayloadLinks;
+}
+
+export interface PayloadLinks {
+  self?: string;
+  next?: string;
+  prev?: string;
+}
+`
+
+const typeScriptClientPreamble = `
+export class GrunwayError extends Error {
+  readonly status: number;
+  readonly info: ErrorInfo;
+  readonly alert?: string;
+
+  constructor(status: number, info: ErrorInfo, alert?: string) {
+    super(info.errorMessage || "HTTP " + status);
+    this.name = "GrunwayError";
+    this.status = status;
+    this.info = info;
+    this.alert = alert;
+  }
+}
+
+export interface ClientOptions {
+  /** scheme and host, eg "https://api.example.com".  paths already include the router's BasePath */
+  baseURL?: string;
+  fetch?: typeof fetch;
+  headers?: Record<string, string>;
+  /** called before every request, eg to sign it */
+  prepare?: (method: string, url: string, init: RequestInit) => RequestInit | Promise<RequestInit>;
+}
+`
+
+const typeScriptClientRequest = `  private readonly options: ClientOptions;
+
+  constructor(options: ClientOptions = {}) {
+    this.options = options;
+  }
+
+  protected async request<P>(method: string, path: string, body: unknown, init?: RequestInit): Promise<PayloadWrapper<P>> {
+    const url = (this.options.baseURL || "") + path;
+    const headers: Record<string, string> = { Accept: "application/json", ...this.options.headers };
+    if (body !== undefined) {
+      headers["Content-Type"] = "application/json";
+    }
+    let requestInit: RequestInit = {
+      ...init,
+      method,
+      headers: { ...headers, ...(init && (init.headers as Record<string, string>)) },
+      body: body === undefined ? undefined : JSON.stringify(body),
+    };
+    if (this.options.prepare) {
+      requestInit = await this.options.prepare(method, url, requestInit);
+    }
+    const doFetch = this.options.fetch || fetch;
+    const response = await doFetch(url, requestInit);
+    const text = await response.text();
+    const wrapper: PayloadWrapper<P> = text ? JSON.parse(text) : {};
+    if (!response.ok) {
+      const info: ErrorInfo = {
+        errorNumber: wrapper.errorNumber,
+        errorMessage: wrapper.errorMessage,
+        debugNumber: wrapper.debugNumber,
+        debugMessage: wrapper.debugMessage,
+      };
+      throw new GrunwayError(response.status, info, wrapper.Alert);
+    }
+    return wrapper;
+  }
+`
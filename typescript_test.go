@@ -0,0 +1,86 @@
+package grunway
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+type tsNested struct {
+	When  time.Time
+	Tags  []string          `json:"tags,omitempty"`
+	Attrs map[string]string `json:"attrs"`
+	Next  *tsNested
+}
+
+type tsTestPayload struct {
+	ID       int64 `json:"id"`
+	Name     string
+	Count    int64 `json:",string"`
+	Nested   tsNested
+	Inline   struct{ A bool }
+	Optional string `json:"opt,omitempty"`
+	Embedded
+	hidden string
+}
+
+func (payload tsTestPayload) PayloadType() string {
+	return "ts-test"
+}
+
+type tsTestController struct{}
+
+func (controller *tsTestController) RouteDocs() map[string]HandlerDoc {
+	return map[string]HandlerDoc{
+		"GetHandlerV1":     {Summary: "Fetch one", Responses: []Payload{tsTestPayload{}}},
+		"PostHandlerV1Add": {Request: &tsTestPayload{}},
+	}
+}
+func (controller *tsTestController) GetHandlerV1(ctx *Context) RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultOk()
+}
+func (controller *tsTestController) PostHandlerV1Add(ctx *Context) RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultOk()
+}
+
+func TestTypeScript(t *testing.T) {
+	router := NewRouter()
+	router.BasePath = "/api/"
+	router.RegisterEntity("ts-thing", &tsTestController{})
+
+	generated := router.TypeScript(TypeScriptOptions{})
+	ts := string(generated)
+
+	expected := []string{
+		"export interface ErrorInfo {",
+		"export interface PayloadWrapper<P = Record<string, unknown[]>> extends ErrorInfo {",
+		"export interface tsTestPayload {\n  id: number;\n  Name: string;\n  Count: string;\n  Nested: tsNested;\n  Inline: { A: boolean; };\n  opt?: string;\n  inner: number;\n}",
+		"export interface tsNested {\n  When: string;\n  tags?: string[];\n  attrs: Record<string, string>;\n  Next?: tsNested | null;\n}",
+		`"ts-test": tsTestPayload;`,
+		"tsThingGetV1(id: number, init?: RequestInit): Promise<PayloadWrapper<{ \"ts-test\"?: tsTestPayload[] }>>",
+		"return this.request(\"GET\", `/api/v1/ts-thing/${id}`, undefined, init);",
+		"tsThingPostV1Add(body: tsTestPayload, init?: RequestInit)",
+		"tsThingPostV1AddWithID(id: number, body: tsTestPayload, init?: RequestInit)",
+		"return this.request(\"POST\", `/api/v1/ts-thing/${id}/add`, body, init);",
+		"export class GrunwayClient {",
+	}
+	for _, snippet := range expected {
+		if strings.Contains(ts, snippet) == false {
+			t.Error("2216920401 missing:", snippet)
+		}
+	}
+	if strings.Contains(ts, "hidden") {
+		t.Error("2216920402 unexported fields should be skipped")
+	}
+	if t.Failed() {
+		t.Log(ts)
+	}
+
+	// deterministic
+	for i := 0; i < 5; i++ {
+		if bytes.Equal(generated, router.TypeScript(TypeScriptOptions{})) == false {
+			t.Fatal("2216920403 output is not deterministic")
+		}
+	}
+}
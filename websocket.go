@@ -0,0 +1,658 @@
+package grunway
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// WebSocket routes use the controller convention w/ their own prefix and signature:
+//
+//	func (dc *DocController) AuthWebSocketHandlerV1Edit(ctx *grunway.Context, conn *grunway.WebSocketConn) {
+//		conn.RegisterPayloads(EditPayload{})
+//		for {
+//			pw, err := conn.Receive()
+//			...
+//		}
+//	}
+//
+// serves GET /api/v1/doc/12/edit.  The upgrade happens after auth and middleware, so an unauthorized client
+// gets an ordinary 403 payload.  Messages are PayloadWrappers encoded as JSON text frames.
+// The connection is closed when the handler returns.
+
+const (
+	MAGIC_WEBSOCKET_HANDLER_PREFIX = "WebSocketHandler"
+
+	WebSocketUpgradeRequiredErrorNumber = 4260000426 // not a websocket request, or an unsupported version
+	WebSocketBadHandshakeErrorNumber    = 4000000010
+	WebSocketOriginErrorNumber          = 4030000010
+	WebSocketHijackErrorNumber          = 5000000503
+
+	UpgradeRequiredPrefix = "426 Upgrade Required"
+
+	webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+// close codes, RFC 6455 section 7.4.1
+const (
+	WebSocketCloseNormal          = 1000
+	WebSocketCloseGoingAway       = 1001
+	WebSocketCloseProtocolError   = 1002
+	WebSocketCloseUnsupportedData = 1003
+	WebSocketCloseNoStatus        = 1005
+	WebSocketCloseInvalidPayload  = 1007
+	WebSocketClosePolicyViolation = 1008
+	WebSocketCloseMessageTooBig   = 1009
+	WebSocketCloseInternalError   = 1011
+	webSocketCloseAbnormal        = 1006 // never sent, used when the connection drops w/o a close frame
+)
+
+const (
+	DefaultWebSocketMaxMessageBytes = 1 << 20
+	DefaultWebSocketPingInterval    = 30 * time.Second
+	DefaultWebSocketWriteTimeout    = 10 * time.Second
+	DefaultWebSocketQueueSize       = 16
+
+	webSocketCloseTimeout = time.Second // how long to wait for the peer's close frame
+)
+
+var (
+	ErrWebSocketClosed       = errors.New("2650341801 websocket is closed")
+	ErrWebSocketBackpressure = errors.New("2650341802 websocket send queue is full")
+)
+
+// WebSocketCloseError is returned by Receive once the connection has closed.
+type WebSocketCloseError struct {
+	Code   int
+	Reason string
+}
+
+func (closeErr *WebSocketCloseError) Error() string {
+	return fmt.Sprintf("2650341803 websocket closed: %d %s", closeErr.Code, closeErr.Reason)
+}
+
+type WebSocketOptions struct {
+	// nil means the Origin header must be absent or match the request's Host
+	CheckOrigin func(req *http.Request) bool
+
+	MaxMessageBytes int64         // larger messages close the connection w/ 1009.  default DefaultWebSocketMaxMessageBytes
+	PingInterval    time.Duration // pings are sent this often; no frame from the peer for twice this long drops the connection.  default DefaultWebSocketPingInterval
+	WriteTimeout    time.Duration // per frame write deadline, and how long Send waits for room in the queue.  default DefaultWebSocketWriteTimeout
+
+	// messages buffered in each direction.  When the outgoing queue is full, Send blocks (up to WriteTimeout).
+	// When the incoming queue is full, the socket isn't read, so TCP pushes back on the peer.
+	QueueSize int // default DefaultWebSocketQueueSize
+}
+
+func (options WebSocketOptions) withDefaults() WebSocketOptions {
+	if options.MaxMessageBytes <= 0 {
+		options.MaxMessageBytes = DefaultWebSocketMaxMessageBytes
+	}
+	if options.PingInterval <= 0 {
+		options.PingInterval = DefaultWebSocketPingInterval
+	}
+	if options.WriteTimeout <= 0 {
+		options.WriteTimeout = DefaultWebSocketWriteTimeout
+	}
+	if options.QueueSize <= 0 {
+		options.QueueSize = DefaultWebSocketQueueSize
+	}
+	return options
+}
+
+// WebSocketHandler is the signature of WebSocketHandlerV<n><Action> methods.
+type WebSocketHandler func(ctx *Context, conn *WebSocketConn)
+
+func validateWebSocketHandler(unknownHandler interface{}) (WebSocketHandler, bool) {
+	validHandler, ok := unknownHandler.(func(*Context, *WebSocketConn))
+	if ok == false {
+		return nil, false
+	}
+	return validHandler, true
+}
+
+// adapts a WebSocketHandler to the usual RouteHandler, so routing, auth and middleware are unchanged
+func webSocketRouteHandler(wsHandler WebSocketHandler) RouteHandler {
+	return func(ctx *Context) RouteHandlerResult {
+		return ctx.MakeRouteHandlerResultCustom(func(innerCtx *Context) {
+			serveWebSocket(innerCtx, wsHandler)
+		})
+	}
+}
+
+// ######
+// #     #   ##   #    # #####   ####  #    #   ##   #    # ######
+// #     #  #  #  ##   # #    # #      #    #  #  #  #   #  #
+// #######  #    # # #  # #    #  ####  ###### #    # ####   #####
+// #     # ###### #  # # #    #      # #    # ###### #  #   #
+// #     # #    # #   ## #    # #    # #    # #    # #   #  #
+// #     # #    # #    # #####   ####  #    # #    # #    # ######
+//
+
+func headerHasToken(header http.Header, key, token string) bool {
+	for _, value := range header[http.CanonicalHeaderKey(key)] {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func webSocketAccept(key string) string {
+	hash := sha1.Sum([]byte(key + webSocketGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+func sameOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	originURL, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(originURL.Host, req.Host)
+}
+
+func serveWebSocket(ctx *Context, wsHandler WebSocketHandler) {
+	req := ctx.Req
+	options := ctx.router.WebSocketOptions.withDefaults()
+
+	if headerHasToken(req.Header, "Connection", "upgrade") == false || headerHasToken(req.Header, "Upgrade", "websocket") == false {
+		ctx.SetHeader("Upgrade", "websocket")
+		ctx.SendSimpleErrorPayload(http.StatusUpgradeRequired, WebSocketUpgradeRequiredErrorNumber, UpgradeRequiredPrefix)
+		return
+	}
+	if req.Header.Get("Sec-WebSocket-Version") != "13" {
+		ctx.SetHeader("Sec-WebSocket-Version", "13")
+		ctx.SendSimpleErrorPayload(http.StatusUpgradeRequired, WebSocketUpgradeRequiredErrorNumber, UpgradeRequiredPrefix)
+		return
+	}
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		ctx.SendSimpleErrorPayload(http.StatusBadRequest, WebSocketBadHandshakeErrorNumber, BadRequestPrefix)
+		return
+	}
+	checkOrigin := options.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if checkOrigin(req) == false {
+		ctx.SendSimpleErrorPayload(http.StatusForbidden, WebSocketOriginErrorNumber, "Forbidden")
+		return
+	}
+
+	hijacker, canHijack := ctx.w.(http.Hijacker)
+	if canHijack == false {
+		ctx.SendSimpleErrorPayload(http.StatusInternalServerError, WebSocketHijackErrorNumber, InternalServerErrorPrefix)
+		return
+	}
+	netConn, buffered, err := hijacker.Hijack()
+	if err != nil {
+		log.Println("2650341804 websocket hijack failed", err)
+		ctx.SendSimpleErrorPayload(http.StatusInternalServerError, WebSocketHijackErrorNumber, InternalServerErrorPrefix)
+		return
+	}
+	ctx.written = true
+	ctx.StatusCode = http.StatusSwitchingProtocols
+
+	handshake := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + webSocketAccept(key) + "\r\n\r\n"
+	netConn.SetWriteDeadline(time.Now().Add(options.WriteTimeout))
+	if _, err := netConn.Write([]byte(handshake)); err != nil {
+		log.Println("2650341805 websocket handshake failed", err)
+		netConn.Close()
+		runPostProcessors(ctx)
+		return
+	}
+
+	conn := newWebSocketConn(ctx, netConn, buffered.Reader, options)
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Println("2650341806 websocket handler panic", r)
+				conn.Close(WebSocketCloseInternalError, "")
+			}
+		}()
+		wsHandler(ctx, conn)
+	}()
+	conn.Close(WebSocketCloseNormal, "")
+	conn.wait()
+
+	runPostProcessors(ctx)
+}
+
+//  #####
+// #     #  ####  #    # #    #
+// #       #    # ##   # ##   #
+// #       #    # # #  # # #  #
+// #       #    # #  # # #  # #
+// #     # #    # #   ## #   ##
+//  #####   ####  #    # #    #
+//
+
+// WebSocketConn is a server side WebSocket connection.  Receive must only be called from one goroutine,
+// Send and Close are safe from any.
+type WebSocketConn struct {
+	ctx     *Context
+	netConn net.Conn
+	reader  *bufio.Reader
+	options WebSocketOptions
+
+	payloadsMu sync.Mutex
+	payloads   []Payload
+
+	writeMu   sync.Mutex // guards writes to netConn and ctx.ContentLength
+	closeSent bool       // guarded by writeMu
+
+	outgoing chan []byte
+	incoming chan []byte
+	readErr  error // set before incoming is closed
+
+	done      chan struct{}
+	closeOnce sync.Once
+	closeCode int
+	closeText string
+
+	wg sync.WaitGroup
+}
+
+func newWebSocketConn(ctx *Context, netConn net.Conn, reader *bufio.Reader, options WebSocketOptions) *WebSocketConn {
+	conn := new(WebSocketConn)
+	conn.ctx = ctx
+	conn.netConn = netConn
+	conn.reader = reader
+	conn.options = options
+	conn.outgoing = make(chan []byte, options.QueueSize)
+	conn.incoming = make(chan []byte, options.QueueSize)
+	conn.done = make(chan struct{})
+
+	conn.wg.Add(2)
+	go conn.readLoop()
+	go conn.writeLoop()
+	return conn
+}
+
+// RegisterPayloads sets the payload types Receive decodes.
+func (conn *WebSocketConn) RegisterPayloads(payloads ...Payload) {
+	conn.payloadsMu.Lock()
+	defer conn.payloadsMu.Unlock()
+	conn.payloads = append(conn.payloads, payloads...)
+}
+
+// Done is closed when the connection starts closing, from either side.
+func (conn *WebSocketConn) Done() <-chan struct{} {
+	return conn.done
+}
+
+// Receive returns the next message, decoded w/ the registered payload types.
+// If Done is closed the error is final: a *WebSocketCloseError, or the read error.  Otherwise the message couldn't
+// be decoded and the connection is still usable.
+func (conn *WebSocketConn) Receive() (*PayloadWrapper, error) {
+	data, err := conn.ReceiveRaw()
+	if err != nil {
+		return nil, err
+	}
+	conn.payloadsMu.Lock()
+	payloads := conn.payloads
+	conn.payloadsMu.Unlock()
+	return unmarshalWebSocketMessage(data, payloads)
+}
+
+// ReceiveRaw returns the next message's bytes, w/o decoding.
+func (conn *WebSocketConn) ReceiveRaw() ([]byte, error) {
+	data, open := <-conn.incoming
+	if open == false {
+		return nil, conn.readErr
+	}
+	return data, nil
+}
+
+// handlers only see the types they registered.  an unknown type is an *UnknownPayloadTypeError
+func unmarshalWebSocketMessage(data []byte, payloads []Payload) (*PayloadWrapper, error) {
+	return UnmarshalPayloadWrapperWithOptions(data, UnmarshalOptions{UnknownPayloads: UnknownPayloadsReject}, payloads...)
+}
+
+// Send wraps payloads in a PayloadWrapper and queues it.  If the peer is too slow and the queue stays full
+// for WriteTimeout, it returns ErrWebSocketBackpressure.
+func (conn *WebSocketConn) Send(payloads ...Payload) error {
+	return conn.SendWrapper(NewPayloadWrapper(payloads...))
+}
+
+func (conn *WebSocketConn) SendError(errNo int64, errMsg string) error {
+	payloadWrapper := NewPayloadWrapper()
+	payloadWrapper.ErrorNumber = errNo
+	payloadWrapper.ErrorMessage = errMsg
+	return conn.SendWrapper(payloadWrapper)
+}
+
+func (conn *WebSocketConn) SendWrapper(payloadWrapper *PayloadWrapper) error {
+	encoded, err := MarshallPayloadWrapper(payloadWrapper)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-conn.done:
+		return ErrWebSocketClosed
+	default:
+	}
+
+	timer := time.NewTimer(conn.options.WriteTimeout)
+	defer timer.Stop()
+	select {
+	case conn.outgoing <- encoded:
+		return nil
+	case <-conn.done:
+		return ErrWebSocketClosed
+	case <-timer.C:
+		return ErrWebSocketBackpressure
+	}
+}
+
+// Close sends a close frame after any queued messages.  Safe to call more than once.
+func (conn *WebSocketConn) Close(code int, reason string) {
+	conn.closeOnce.Do(func() {
+		conn.closeCode = code
+		conn.closeText = reason
+		close(conn.done)
+	})
+}
+
+func (conn *WebSocketConn) wait() {
+	conn.wg.Wait()
+	conn.netConn.Close()
+}
+
+func (conn *WebSocketConn) writeFrame(opcode byte, payload []byte) error {
+	conn.writeMu.Lock()
+	defer conn.writeMu.Unlock()
+	if conn.closeSent {
+		return ErrWebSocketClosed
+	}
+	if opcode == webSocketOpClose {
+		conn.closeSent = true
+	}
+	conn.netConn.SetWriteDeadline(time.Now().Add(conn.options.WriteTimeout))
+	err := writeWebSocketFrame(conn.netConn, opcode, payload, false)
+	if err == nil && opcode == webSocketOpText {
+		// read by PostProcessors, after the writer has stopped
+		conn.ctx.ContentLength += len(payload)
+	}
+	return err
+}
+
+func (conn *WebSocketConn) writeLoop() {
+	defer conn.wg.Done()
+	ticker := time.NewTicker(conn.options.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case message := <-conn.outgoing:
+			if err := conn.writeFrame(webSocketOpText, message); err != nil {
+				conn.Close(webSocketCloseAbnormal, "")
+			}
+		case <-ticker.C:
+			if err := conn.writeFrame(webSocketOpPing, nil); err != nil {
+				conn.Close(webSocketCloseAbnormal, "")
+			}
+		case <-conn.done:
+			// flush what's queued, then say goodbye
+			for {
+				select {
+				case message := <-conn.outgoing:
+					if conn.writeFrame(webSocketOpText, message) == nil {
+						continue
+					}
+				default:
+				}
+				break
+			}
+			if conn.closeCode != webSocketCloseAbnormal {
+				conn.writeFrame(webSocketOpClose, closeFramePayload(conn.closeCode, conn.closeText))
+			}
+			// the reader is waiting for the peer's close frame, but not forever
+			conn.netConn.SetReadDeadline(time.Now().Add(webSocketCloseTimeout))
+			return
+		}
+	}
+}
+
+func (conn *WebSocketConn) readLoop() {
+	defer conn.wg.Done()
+	defer close(conn.incoming)
+
+	var message []byte
+	var messageOpcode byte
+	for {
+		conn.netConn.SetReadDeadline(time.Now().Add(2 * conn.options.PingInterval))
+		select {
+		case <-conn.done:
+			// closing, the writer has set a shorter deadline
+			conn.netConn.SetReadDeadline(time.Now().Add(webSocketCloseTimeout))
+		default:
+		}
+
+		frame, err := readWebSocketFrame(conn.reader, conn.options.MaxMessageBytes, true)
+		if err != nil {
+			conn.readErr = conn.failRead(err)
+			return
+		}
+
+		switch frame.opcode {
+		case webSocketOpPing:
+			conn.writeFrame(webSocketOpPong, frame.payload)
+			continue
+		case webSocketOpPong:
+			continue
+		case webSocketOpClose:
+			code, reason := parseCloseFramePayload(frame.payload)
+			conn.writeFrame(webSocketOpClose, closeFramePayload(code, ""))
+			conn.Close(code, reason)
+			conn.readErr = &WebSocketCloseError{code, reason}
+			return
+		case webSocketOpText, webSocketOpBinary:
+			if message != nil {
+				conn.readErr = conn.failRead(&WebSocketCloseError{WebSocketCloseProtocolError, "expected a continuation frame"})
+				return
+			}
+			messageOpcode = frame.opcode
+			message = frame.payload
+		case webSocketOpContinuation:
+			if message == nil {
+				conn.readErr = conn.failRead(&WebSocketCloseError{WebSocketCloseProtocolError, "unexpected continuation frame"})
+				return
+			}
+			if int64(len(message)+len(frame.payload)) > conn.options.MaxMessageBytes {
+				conn.readErr = conn.failRead(&WebSocketCloseError{WebSocketCloseMessageTooBig, "message too big"})
+				return
+			}
+			message = append(message, frame.payload...)
+		default:
+			conn.readErr = conn.failRead(&WebSocketCloseError{WebSocketCloseProtocolError, "unknown opcode"})
+			return
+		}
+		if frame.fin == false {
+			continue
+		}
+
+		if messageOpcode == webSocketOpText && utf8.Valid(message) == false {
+			conn.readErr = conn.failRead(&WebSocketCloseError{WebSocketCloseInvalidPayload, "invalid utf-8"})
+			return
+		}
+		select {
+		case conn.incoming <- message:
+		case <-conn.done:
+			// nobody will read it
+		}
+		message = nil
+	}
+}
+
+// protocol violations are reported to the peer, everything else just ends the connection
+func (conn *WebSocketConn) failRead(err error) error {
+	closeErr, isCloseErr := err.(*WebSocketCloseError)
+	if isCloseErr {
+		conn.Close(closeErr.Code, closeErr.Reason)
+		return err
+	}
+	select {
+	case <-conn.done:
+		// we started the close and the peer didn't answer in time, or dropped the connection
+		return &WebSocketCloseError{conn.closeCode, conn.closeText}
+	default:
+	}
+	conn.Close(webSocketCloseAbnormal, "")
+	return err
+}
+
+// #######
+// #       #####    ##   #    # ######  ####
+// #       #    #  #  #  ##  ## #      #
+// #####   #    # #    # # ## # #####   ####
+// #       #####  ###### #    # #           #
+// #       #   #  #    # #    # #      #    #
+// #       #    # #    # #    # ######  ####
+//
+
+const (
+	webSocketOpContinuation byte = 0x0
+	webSocketOpText         byte = 0x1
+	webSocketOpBinary       byte = 0x2
+	webSocketOpClose        byte = 0x8
+	webSocketOpPing         byte = 0x9
+	webSocketOpPong         byte = 0xA
+)
+
+type webSocketFrame struct {
+	fin     bool
+	opcode  byte
+	payload []byte
+}
+
+// readWebSocketFrame reads one frame and unmasks it.  Client frames must be masked, server frames must not.
+func readWebSocketFrame(reader io.Reader, maxPayload int64, requireMask bool) (webSocketFrame, error) {
+	var frame webSocketFrame
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return frame, err
+	}
+	frame.fin = header[0]&0x80 != 0
+	frame.opcode = header[0] & 0x0F
+	if header[0]&0x70 != 0 {
+		return frame, &WebSocketCloseError{WebSocketCloseProtocolError, "reserved bits set"}
+	}
+	masked := header[1]&0x80 != 0
+	if masked != requireMask {
+		return frame, &WebSocketCloseError{WebSocketCloseProtocolError, "wrong masking"}
+	}
+
+	length := int64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		extended := make([]byte, 2)
+		if _, err := io.ReadFull(reader, extended); err != nil {
+			return frame, err
+		}
+		length = int64(binary.BigEndian.Uint16(extended))
+	case 127:
+		extended := make([]byte, 8)
+		if _, err := io.ReadFull(reader, extended); err != nil {
+			return frame, err
+		}
+		length = int64(binary.BigEndian.Uint64(extended) & (1<<63 - 1))
+	}
+	if frame.opcode >= webSocketOpClose && (length > 125 || frame.fin == false) {
+		return frame, &WebSocketCloseError{WebSocketCloseProtocolError, "invalid control frame"}
+	}
+	if length > maxPayload {
+		return frame, &WebSocketCloseError{WebSocketCloseMessageTooBig, "message too big"}
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(reader, mask[:]); err != nil {
+			return frame, err
+		}
+	}
+	frame.payload = make([]byte, length)
+	if _, err := io.ReadFull(reader, frame.payload); err != nil {
+		return frame, err
+	}
+	if masked {
+		for i := range frame.payload {
+			frame.payload[i] ^= mask[i%4]
+		}
+	}
+	return frame, nil
+}
+
+// writeWebSocketFrame writes one unfragmented frame.  mask is for clients (and tests).
+func writeWebSocketFrame(writer io.Writer, opcode byte, payload []byte, mask bool) error {
+	frame := make([]byte, 0, len(payload)+14)
+	frame = append(frame, 0x80|opcode)
+
+	maskBit := byte(0)
+	if mask {
+		maskBit = 0x80
+	}
+	switch length := len(payload); {
+	case length <= 125:
+		frame = append(frame, maskBit|byte(length))
+	case length <= 0xFFFF:
+		frame = append(frame, maskBit|126, byte(length>>8), byte(length))
+	default:
+		extended := make([]byte, 8)
+		binary.BigEndian.PutUint64(extended, uint64(length))
+		frame = append(frame, maskBit|127)
+		frame = append(frame, extended...)
+	}
+
+	if mask {
+		key := [4]byte{byte(time.Now().UnixNano()), 0x5a, 0xa5, byte(len(payload))}
+		frame = append(frame, key[:]...)
+		for i, b := range payload {
+			frame = append(frame, b^key[i%4])
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+	_, err := writer.Write(frame)
+	return err
+}
+
+func closeFramePayload(code int, reason string) []byte {
+	if code == 0 || code == WebSocketCloseNoStatus {
+		return nil
+	}
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	return append(payload, reason...)
+}
+
+func parseCloseFramePayload(payload []byte) (int, string) {
+	if len(payload) < 2 {
+		return WebSocketCloseNoStatus, ""
+	}
+	return int(binary.BigEndian.Uint16(payload)), string(payload[2:])
+}
@@ -0,0 +1,252 @@
+package grunway
+
+import (
+	"bufio"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+type shelfSocketController struct {
+	closed chan error
+}
+
+func (controller *shelfSocketController) PerformAuth(routePtr *Route, ctx *Context) (bool, int) {
+	if ctx.Req.Header.Get("X-Shelf-Key") == "" {
+		return false, 2650341850
+	}
+	return true, 0
+}
+func (controller *shelfSocketController) GetSecretKey(publicKey string) (string, int) {
+	return "", 0
+}
+
+func (controller *shelfSocketController) AuthWebSocketHandlerV1Echo(ctx *Context, conn *WebSocketConn) {
+	conn.RegisterPayloads(BookPayload{})
+	for {
+		pw, err := conn.Receive()
+		select {
+		case <-conn.Done():
+			controller.closed <- err
+			return
+		default:
+		}
+		if err != nil {
+			conn.SendError(2650341867, err.Error())
+			continue
+		}
+		books := pw.Payloads["book"]
+		if len(books) == 0 {
+			conn.SendError(2650341851, "expected books")
+			continue
+		}
+		conn.Send(books...)
+	}
+}
+
+// wrong signature for the prefix, skipped
+func (controller *shelfSocketController) WebSocketHandlerV1Wrong(ctx *Context) RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultOk()
+}
+
+type testWebSocketClient struct {
+	netConn net.Conn
+	reader  *bufio.Reader
+}
+
+func dialTestWebSocket(t *testing.T, server *httptest.Server, path string, header http.Header) (*testWebSocketClient, *http.Response) {
+	netConn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", server.URL+path, nil)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Write(netConn)
+	reader := bufio.NewReader(netConn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testWebSocketClient{netConn, reader}, resp
+}
+
+func (client *testWebSocketClient) send(opcode byte, payload string) {
+	writeWebSocketFrame(client.netConn, opcode, []byte(payload), true)
+}
+
+func (client *testWebSocketClient) read(t *testing.T) webSocketFrame {
+	client.netConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	frame, err := readWebSocketFrame(client.reader, DefaultWebSocketMaxMessageBytes, false)
+	if err != nil {
+		t.Fatal("2650341852 read failed", err)
+	}
+	return frame
+}
+
+func TestWebSocketRoutes(t *testing.T) {
+	controller := &shelfSocketController{closed: make(chan error, 1)}
+	router := NewRouter()
+	router.BasePath = "/api/"
+	router.RegisterEntity("shelf", controller)
+
+	if router.AllRoutesCount() != 1 {
+		t.Fatal("2650341853 expected only the echo route", router.AllRoutesDescription())
+	}
+	if routes := router.AllRoutes(); routes[0].Method != "GET" || routes[0].WebSocket == false {
+		t.Error("2650341854 expected a GET websocket route", routes[0])
+	}
+
+	logged := make(chan *Context, 8)
+	router.PostProcessors = []PostProcessor{postProcessorFunc(func(ctx *Context) {
+		if ctx.StatusCode == http.StatusSwitchingProtocols {
+			logged <- ctx
+		}
+	})}
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	key := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef"))
+	upgrade := http.Header{
+		"Connection":            {"Upgrade"},
+		"Upgrade":               {"websocket"},
+		"Sec-Websocket-Version": {"13"},
+		"Sec-Websocket-Key":     {key},
+	}
+
+	// auth runs before the upgrade
+	_, resp := dialTestWebSocket(t, server, "/api/v1/shelf/echo", upgrade)
+	if resp.StatusCode != http.StatusForbidden {
+		t.Error("2650341855 expected a 403", resp.StatusCode)
+	}
+
+	upgrade.Set("X-Shelf-Key", "k")
+	_, resp = dialTestWebSocket(t, server, "/api/v1/shelf/echo", http.Header{"X-Shelf-Key": {"k"}})
+	if resp.StatusCode != http.StatusUpgradeRequired || resp.Header.Get("Upgrade") != "websocket" {
+		t.Error("2650341856 expected a 426", resp.StatusCode, resp.Header)
+	}
+	upgrade.Set("Origin", "http://elsewhere.example")
+	_, resp = dialTestWebSocket(t, server, "/api/v1/shelf/echo", upgrade)
+	if resp.StatusCode != http.StatusForbidden {
+		t.Error("2650341857 expected the origin check to fail", resp.StatusCode)
+	}
+	upgrade.Del("Origin")
+
+	client, resp := dialTestWebSocket(t, server, "/api/v1/shelf/echo", upgrade)
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-Websocket-Accept") != "BACScCJPNqyz+UBoqMH89VmURoA=" {
+		t.Fatal("2650341858 expected a 101", resp.StatusCode, resp.Header)
+	}
+
+	client.send(webSocketOpText, `{"Payloads":{"book":[{"PKey":7,"Name":"Dune","AuthorId":2}]}}`)
+	frame := client.read(t)
+	pw, err := UnmarshalPayloadWrapper(frame.payload, BookPayload{})
+	if err != nil || frame.opcode != webSocketOpText || len(pw.Payloads["book"]) != 1 || pw.Payloads["book"][0].(*BookPayload).Name != "Dune" {
+		t.Error("2650341859 expected the book back", err, string(frame.payload))
+	}
+
+	// unknown payload types are an error message, not a dropped connection
+	client.send(webSocketOpText, `{"Payloads":{"scroll":[{}]}}`)
+	if frame = client.read(t); strings.Contains(string(frame.payload), "2650341867") == false {
+		t.Error("2650341868 expected a decode error message", string(frame.payload))
+	}
+	client.send(webSocketOpPing, "hi")
+	if frame = client.read(t); frame.opcode != webSocketOpPong || string(frame.payload) != "hi" {
+		t.Error("2650341860 expected a pong", frame.opcode, string(frame.payload))
+	}
+	client.send(webSocketOpText, `{"Payloads":{}}`)
+	if frame = client.read(t); strings.Contains(string(frame.payload), "2650341851") == false {
+		t.Error("2650341861 expected an error message", string(frame.payload))
+	}
+
+	client.send(webSocketOpClose, string(closeFramePayload(WebSocketCloseGoingAway, "bye")))
+	if frame = client.read(t); frame.opcode != webSocketOpClose {
+		t.Error("2650341862 expected the close echoed", frame.opcode)
+	}
+	closeErr, isCloseErr := (<-controller.closed).(*WebSocketCloseError)
+	if isCloseErr == false || closeErr.Code != WebSocketCloseGoingAway || closeErr.Reason != "bye" {
+		t.Error("2650341863 expected the client's close", closeErr)
+	}
+	client.netConn.Close()
+
+	// post processors run once the handler returns
+	select {
+	case ctx := <-logged:
+		if ctx.ContentLength == 0 {
+			t.Error("2650341864 expected PostProcessors to see the bytes sent", ctx.ContentLength)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("2650341869 expected PostProcessors to run")
+	}
+}
+
+func TestWebSocketProtocolErrors(t *testing.T) {
+	controller := &shelfSocketController{closed: make(chan error, 1)}
+	router := NewRouter()
+	router.BasePath = "/api/"
+	router.WebSocketOptions.MaxMessageBytes = 64
+	router.RegisterEntity("shelf", controller)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	upgrade := http.Header{
+		"Connection":            {"keep-alive, Upgrade"},
+		"Upgrade":               {"websocket"},
+		"Sec-Websocket-Version": {"13"},
+		"Sec-Websocket-Key":     {base64.StdEncoding.EncodeToString([]byte("0123456789abcdef"))},
+		"X-Shelf-Key":           {"k"},
+	}
+	expectClose := func(code int, send func(client *testWebSocketClient)) {
+		client, resp := dialTestWebSocket(t, server, "/api/v1/shelf/echo", upgrade)
+		defer client.netConn.Close()
+		if resp.StatusCode != http.StatusSwitchingProtocols {
+			t.Fatal("2650341865 expected a 101", resp.StatusCode)
+		}
+		send(client)
+		frame := client.read(t)
+		actual, _ := parseCloseFramePayload(frame.payload)
+		if frame.opcode != webSocketOpClose || actual != code {
+			t.Error("2650341866 expected close", code, "got", frame.opcode, actual)
+		}
+		<-controller.closed
+	}
+
+	expectClose(WebSocketCloseMessageTooBig, func(client *testWebSocketClient) {
+		client.send(webSocketOpText, strings.Repeat("x", 65))
+	})
+	expectClose(WebSocketCloseInvalidPayload, func(client *testWebSocketClient) {
+		client.send(webSocketOpText, "\xff\xfe")
+	})
+	expectClose(WebSocketCloseProtocolError, func(client *testWebSocketClient) {
+		// servers must reject unmasked frames
+		writeWebSocketFrame(client.netConn, webSocketOpText, []byte("{}"), false)
+	})
+}
+
+type clashingSocketController struct{}
+
+func (controller *clashingSocketController) GetHandlerV1Edit(ctx *Context) RouteHandlerResult {
+	return ctx.MakeRouteHandlerResultOk()
+}
+func (controller *clashingSocketController) WebSocketHandlerV1Edit(ctx *Context, conn *WebSocketConn) {
+}
+
+// registration log.Fatal's, so it runs in a child process
+func TestWebSocketRouteClash(t *testing.T) {
+	if os.Getenv("GRUNWAY_TEST_ROUTE_CLASH") == "1" {
+		NewRouter().RegisterEntity("doc", &clashingSocketController{})
+		return
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestWebSocketRouteClash$")
+	cmd.Env = append(os.Environ(), "GRUNWAY_TEST_ROUTE_CLASH=1")
+	output, err := cmd.CombinedOutput()
+	if err == nil || strings.Contains(string(output), "1411397819") == false {
+		t.Error("2650341870 expected registration to fail", err, string(output))
+	}
+}